@@ -19,18 +19,48 @@ func init() {
 
 // Config type represent backend config, and should include all backends config
 type Config struct {
-	BackendTimeout          time.Duration
-	VaultURL                string
-	VaultToken              string
-	VaultMaxTokenTTL        int64
-	VaultTokenPollingPeriod time.Duration
-	VaultRenewTTLIncrement  int
-	VaultEngine             string
+	BackendTimeout time.Duration
+	// StartupTimeout is the maximum time to wait for the backend to be ready at startup
+	StartupTimeout time.Duration
+	VaultURL       string
+	VaultToken     string
+	VaultEngine    string
+	// VaultAuthMethod is the auth method used to get a new token when the current one can not be renewed
+	VaultAuthMethod string
+	// VaultAuthRole is the role to login with through VaultAuthMethod
+	VaultAuthRole string
+	// VaultAuthMountPath is the path VaultAuthMethod is mounted at. It defaults to the auth method name
+	VaultAuthMountPath string
+	// VaultWrappedToken is a response-wrapping token to be unwrapped at startup instead of using VaultToken
+	VaultWrappedToken string
+	// VaultWrappedTokenFile is a file to read VaultWrappedToken from. It takes precedence over VaultWrappedToken
+	VaultWrappedTokenFile string
+	// VaultWrappedTokenPath is the expected creation path of the response-wrapping token
+	VaultWrappedTokenPath string
+	// VaultRevokeOnShutdown revokes the token on Close, if it was unwrapped or obtained through VaultAuthMethod
+	VaultRevokeOnShutdown bool
+}
+
+// SecretMetadata holds what a backend knows about a secret besides its data
+type SecretMetadata struct {
+	// Version is the current version of the secret
+	Version int
+	// UpdatedTime is the last time the secret was written
+	UpdatedTime time.Time
+	// CustomMetadata holds the user provided metadata of the secret
+	CustomMetadata map[string]string
 }
 
 // Client interface represent a backend client interface that should be implemented
 type Client interface {
 	ReadSecret(path string, key string) (string, error)
+	ReadSecretMetadata(path string) (*SecretMetadata, error)
+	// ReadSecretData returns every key of the secret at path
+	ReadSecretData(path string) (map[string]string, error)
+	// ListSecrets returns the path of every secret under path, recursively
+	ListSecrets(path string) ([]string, error)
+	// Close releases the backend resources once the client is not going to be used anymore
+	Close() error
 }
 
 // NewBackendClient returns and implementation of Client interface, given the selected backend
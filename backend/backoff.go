@@ -0,0 +1,42 @@
+package backend
+
+import "time"
+
+// Backoff retries an operation waiting an exponentially growing time between two attempts
+type Backoff struct {
+	// Initial is the time to wait after the first failed attempt
+	Initial time.Duration
+	// Max is the longest time to wait between two attempts
+	Max time.Duration
+}
+
+// StartupBackoff is the backoff used to wait for the backend and Kubernetes to be ready at startup
+var StartupBackoff = Backoff{Initial: time.Second, Max: 30 * time.Second}
+
+// Retry calls try until it succeeds, as long as the next attempt starts before timeout elapses, and returns the error
+// of the last attempt otherwise. onRetry is called with the error and the time to wait before every new attempt
+func (b Backoff) Retry(timeout time.Duration, try func() error, onRetry func(wait time.Duration, err error)) error {
+	deadline := time.Now().Add(timeout)
+	wait := b.Initial
+	for {
+		err := try()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		onRetry(wait, err)
+		time.Sleep(wait)
+		wait = b.Next(wait)
+	}
+}
+
+// Next returns the time to wait before the attempt following one that waited wait
+func (b Backoff) Next(wait time.Duration) time.Duration {
+	wait *= 2
+	if wait > b.Max {
+		return b.Max
+	}
+	return wait
+}
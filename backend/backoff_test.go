@@ -0,0 +1,56 @@
+package backend
+
+import (
+	e "errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffRetry(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond}
+	attempts := 0
+	var waits []time.Duration
+
+	err := b.Retry(time.Second, func() error {
+		attempts++
+		if attempts < 5 {
+			return e.New("not ready")
+		}
+		return nil
+	}, func(wait time.Duration, err error) {
+		waits = append(waits, wait)
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, attempts)
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}, waits)
+}
+
+func TestBackoffRetryTimeout(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	attempts := 0
+
+	err := b.Retry(20*time.Millisecond, func() error {
+		attempts++
+		return e.New("not ready")
+	}, func(wait time.Duration, err error) {})
+
+	assert.EqualError(t, err, "not ready")
+	assert.True(t, attempts > 1)
+}
+
+func TestBackoffRetryNoTimeLeft(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Second}
+	attempts := 0
+
+	// No attempt is retried if it would start once timeout elapsed
+	err := b.Retry(10*time.Millisecond, func() error {
+		attempts++
+		return e.New("not ready")
+	}, func(wait time.Duration, err error) {})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -1,7 +1,14 @@
 package backend
 
 import (
+	"compress/gzip"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/tuenti/secrets-manager/errors"
 )
@@ -10,11 +17,32 @@ const (
 	// Base64EncodingType is the internal code to represent a base64 encoding
 	Base64EncodingType = "base64"
 
+	// Base64URLEncodingType is the internal code to represent a URL-safe base64 encoding, padded or not
+	Base64URLEncodingType = "base64url"
+
+	// Base64RawEncodingType is the internal code to represent an unpadded base64 encoding
+	Base64RawEncodingType = "base64raw"
+
+	// Base32EncodingType is the internal code to represent a base32 encoding
+	Base32EncodingType = "base32"
+
+	// HexEncodingType is the internal code to represent a hexadecimal encoding
+	HexEncodingType = "hex"
+
+	// GunzipEncodingType is the internal code to represent gzip compressed data
+	GunzipEncodingType = "gunzip"
+
 	// TextEncodingType is the internal code to represent a basic text encoding
 	TextEncodingType = "text"
 
 	// DefaultEncodingType is the default encoding to use.
 	DefaultEncodingType = "text"
+
+	// encodingPipelineSeparator separates the encodings of a pipeline, applied from left to right
+	encodingPipelineSeparator = "|"
+
+	// maxGunzipSize is the largest size gzip compressed data is decompressed to, the size limit of a K8s Secret
+	maxGunzipSize = 1 << 20
 )
 
 // Decoder interface represents anything that can implement DecodeString: get some bytes from input string
@@ -27,36 +55,165 @@ type Base64Decoder struct {
 	Encoding string
 }
 
+// Base64URLDecoder represents a Decoder for URL-safe base64 text, padded or not
+type Base64URLDecoder struct {
+	Encoding string
+}
+
+// Base64RawDecoder represents a Decoder for unpadded base64 text
+type Base64RawDecoder struct {
+	Encoding string
+}
+
+// Base32Decoder represents a Decoder for base32 text
+type Base32Decoder struct {
+	Encoding string
+}
+
+// HexDecoder represents a Decoder for hexadecimal text
+type HexDecoder struct {
+	Encoding string
+}
+
+// GunzipDecoder represents a Decoder for gzip compressed data
+type GunzipDecoder struct {
+	Encoding string
+}
+
 // TextDecoder represents a Decoder for plain text
 type TextDecoder struct {
 	Encoding string
 }
 
+// PipelineDecoder represents a chain of Decoders, each one decoding the output of the previous one
+type PipelineDecoder struct {
+	Encoding string
+	Decoders []Decoder
+}
+
+func decodingError(encoding string, err error) error {
+	return &errors.DecodingError{ErrType: errors.DecodingErrorType, Encoding: encoding, Reason: err.Error()}
+}
+
 // DecodeString for Base64Decoder will get the text version (in bytes) of the input base64 text
 func (d Base64Decoder) DecodeString(input string) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(input)
 	if err != nil {
-		return nil, err
+		return nil, decodingError(d.Encoding, err)
 	}
 	return data, err
 }
 
+// DecodeString for Base64URLDecoder will get the bytes of the input URL-safe base64 text
+func (d Base64URLDecoder) DecodeString(input string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(input, "="))
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	return data, nil
+}
+
+// DecodeString for Base64RawDecoder will get the bytes of the input unpadded base64 text
+func (d Base64RawDecoder) DecodeString(input string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(input)
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	return data, nil
+}
+
+// DecodeString for Base32Decoder will get the bytes of the input base32 text
+func (d Base32Decoder) DecodeString(input string) ([]byte, error) {
+	data, err := base32.StdEncoding.DecodeString(input)
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	return data, nil
+}
+
+// DecodeString for HexDecoder will get the bytes of the input hexadecimal text
+func (d HexDecoder) DecodeString(input string) ([]byte, error) {
+	data, err := hex.DecodeString(input)
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	return data, nil
+}
+
+// DecodeString for GunzipDecoder will decompress the gzip compressed input, up to maxGunzipSize bytes
+func (d GunzipDecoder) DecodeString(input string) ([]byte, error) {
+	reader, err := gzip.NewReader(strings.NewReader(input))
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxGunzipSize+1))
+	if err != nil {
+		return nil, decodingError(d.Encoding, err)
+	}
+	if len(data) > maxGunzipSize {
+		return nil, decodingError(d.Encoding, fmt.Errorf("decompressed data is larger than %d bytes", maxGunzipSize))
+	}
+	return data, nil
+}
+
 // DecodeString for TextDecoder, will simply cast to []bytes the input text
 func (d TextDecoder) DecodeString(input string) ([]byte, error) {
 	return []byte(input), nil
 }
 
-// NewDecoder returns a new Decoder implementation or an error if the provided encoding is not implemented
-func NewDecoder(encoding string) (Decoder, error) {
-	if encoding == "" {
-		encoding = DefaultEncodingType
+// DecodeString for PipelineDecoder will run the input through every decoder of the pipeline
+func (d PipelineDecoder) DecodeString(input string) ([]byte, error) {
+	data := []byte(input)
+	for _, decoder := range d.Decoders {
+		var err error
+		data, err = decoder.DecodeString(string(data))
+		if err != nil {
+			return nil, err
+		}
 	}
+	return data, nil
+}
+
+func newSingleDecoder(encoding string) (Decoder, error) {
 	switch encoding {
 	case Base64EncodingType:
 		return Base64Decoder{Encoding: encoding}, nil
+	case Base64URLEncodingType:
+		return Base64URLDecoder{Encoding: encoding}, nil
+	case Base64RawEncodingType:
+		return Base64RawDecoder{Encoding: encoding}, nil
+	case Base32EncodingType:
+		return Base32Decoder{Encoding: encoding}, nil
+	case HexEncodingType:
+		return HexDecoder{Encoding: encoding}, nil
+	case GunzipEncodingType:
+		return GunzipDecoder{Encoding: encoding}, nil
 	case TextEncodingType:
 		return TextDecoder{Encoding: encoding}, nil
 	default:
 		return nil, &errors.EncodingNotImplementedError{ErrType: errors.EncodingNotImplementedErrorType, Encoding: encoding}
 	}
 }
+
+// NewDecoder returns a new Decoder implementation or an error if the provided encoding is not implemented.
+// Encodings can be chained as a pipeline, such as "base64|gunzip"
+func NewDecoder(encoding string) (Decoder, error) {
+	if encoding == "" {
+		encoding = DefaultEncodingType
+	}
+	if !strings.Contains(encoding, encodingPipelineSeparator) {
+		return newSingleDecoder(encoding)
+	}
+
+	pipeline := PipelineDecoder{Encoding: encoding}
+	for _, e := range strings.Split(encoding, encodingPipelineSeparator) {
+		decoder, err := newSingleDecoder(strings.TrimSpace(e))
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Decoders = append(pipeline.Decoders, decoder)
+	}
+	return pipeline, nil
+}
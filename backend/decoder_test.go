@@ -1,7 +1,11 @@
 package backend
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,7 +54,7 @@ func TestDecodeInvalidB64String(t *testing.T) {
 	b64data := "Invalid b64 data"
 	decoder, _ := NewDecoder("base64")
 	data, err := decoder.DecodeString(b64data)
-	assert.NotNil(t, err)
+	assert.True(t, errors.IsDecoding(err))
 	assert.Nil(t, data)
 }
 
@@ -61,3 +65,88 @@ func TestDecodeText(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, text, fmt.Sprintf("%s", data))
 }
+
+func TestDecodeB64URLString(t *testing.T) {
+	decoder, _ := NewDecoder("base64url")
+	data, err := decoder.DecodeString("Pz8_Pw==")
+	assert.Nil(t, err)
+	assert.Equal(t, "????", string(data))
+
+	data, err = decoder.DecodeString("Pz8_Pw")
+	assert.Nil(t, err)
+	assert.Equal(t, "????", string(data))
+}
+
+func TestDecodeB64RawString(t *testing.T) {
+	decoder, _ := NewDecoder("base64raw")
+	data, err := decoder.DecodeString("dGVzdGluZw")
+	assert.Nil(t, err)
+	assert.Equal(t, "testing", string(data))
+}
+
+func TestDecodeB32String(t *testing.T) {
+	decoder, _ := NewDecoder("base32")
+	data, err := decoder.DecodeString("ORSXG5DJNZTQ====")
+	assert.Nil(t, err)
+	assert.Equal(t, "testing", string(data))
+}
+
+func TestDecodeHexString(t *testing.T) {
+	decoder, _ := NewDecoder("hex")
+	data, err := decoder.DecodeString("74657374696e67")
+	assert.Nil(t, err)
+	assert.Equal(t, "testing", string(data))
+}
+
+func TestDecodeInvalidHexString(t *testing.T) {
+	decoder, _ := NewDecoder("hex")
+	data, err := decoder.DecodeString("not hex")
+	assert.Nil(t, data)
+	assert.True(t, errors.IsDecoding(err))
+}
+
+func gzipString(t *testing.T, s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return b.String()
+}
+
+func TestDecodeGunzip(t *testing.T) {
+	decoder, _ := NewDecoder("gunzip")
+	data, err := decoder.DecodeString(gzipString(t, "testing"))
+	assert.Nil(t, err)
+	assert.Equal(t, "testing", string(data))
+}
+
+func TestDecodeGunzipTooLarge(t *testing.T) {
+	decoder, _ := NewDecoder("gunzip")
+	data, err := decoder.DecodeString(gzipString(t, strings.Repeat("a", maxGunzipSize+1)))
+	assert.Nil(t, data)
+	assert.True(t, errors.IsDecoding(err))
+}
+
+func TestDecodePipeline(t *testing.T) {
+	decoder, err := NewDecoder("base64|gunzip")
+	assert.Nil(t, err)
+	assert.Len(t, decoder.(PipelineDecoder).Decoders, 2)
+
+	data, err := decoder.DecodeString(base64.StdEncoding.EncodeToString([]byte(gzipString(t, "testing"))))
+	assert.Nil(t, err)
+	assert.Equal(t, "testing", string(data))
+}
+
+func TestDecodePipelineInvalidInput(t *testing.T) {
+	decoder, _ := NewDecoder("base64 | gunzip")
+	data, err := decoder.DecodeString(base64.StdEncoding.EncodeToString([]byte("this is not gzip data")))
+	assert.Nil(t, data)
+	assert.EqualError(t, err, fmt.Sprintf("[%s] unable to decode %s data: %s", errors.DecodingErrorType, "gunzip", "gzip: invalid header"))
+}
+
+func TestNotImplementedDecoderInPipeline(t *testing.T) {
+	_, err := NewDecoder("base64|foo")
+	assert.EqualError(t, err, fmt.Sprintf("[%s] encoding %s not supported", errors.EncodingNotImplementedErrorType, "foo"))
+}
@@ -3,7 +3,11 @@ package backend
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -16,15 +20,24 @@ var (
 	metrics *vaultMetrics
 )
 
-const defaultSecretKey = "data"
+const (
+	defaultSecretKey = "data"
+	// loginRetryPeriod is the time to wait before retrying a failed token lookup or login
+	loginRetryPeriod = 15 * time.Second
+)
 
 type client struct {
-	vclient            *api.Client
-	logical            *api.Logical
-	maxTokenTTL        int64
-	tokenPollingPeriod time.Duration
-	renewTTLIncrement  int
-	engine             engine
+	vclient *api.Client
+	logical *api.Logical
+	engine  engine
+	auth    authMethod
+	// revokeToken is true if the token must be revoked on Close
+	revokeToken bool
+	// ctx bounds the lifetime watchers of leased secrets
+	ctx context.Context
+	// leases holds the secrets read with a lease, by path, while their lifetime watcher keeps them alive
+	leases      map[string]*api.Secret
+	leasesMutex sync.Mutex
 }
 
 func vaultClient(l *log.Logger, cfg Config) (*client, error) {
@@ -46,13 +59,42 @@ func vaultClient(l *log.Logger, cfg Config) (*client, error) {
 
 	vclient.SetToken(cfg.VaultToken)
 	sys := vclient.Sys()
-	health, err := sys.Health()
+	health, err := waitForVault(sys, cfg.StartupTimeout)
 
 	if err != nil {
 		logger.Debugf("could not contact Vault at %s: %v ", cfg.VaultURL, err)
 		return nil, err
 	}
 
+	wrappedToken, err := getWrappedToken(cfg)
+	if err != nil {
+		logger.Debugf("unable to read vault wrapped token from %s: %v", cfg.VaultWrappedTokenFile, err)
+		return nil, err
+	}
+	if wrappedToken != "" {
+		token, err := unwrapToken(vclient, wrappedToken, cfg.VaultWrappedTokenPath)
+		if err != nil {
+			logger.Debugf("unable to unwrap vault token: %v", err)
+			return nil, err
+		}
+		vclient.SetToken(token)
+		logger.Infof("successfully unwrapped vault token")
+	}
+
+	auth, err := newAuthMethod(cfg)
+	if err != nil {
+		logger.Debugf("unable to use auth method %s: %v", cfg.VaultAuthMethod, err)
+		return nil, err
+	}
+	if auth != nil && vclient.Token() == "" {
+		token, err := auth.login(vclient)
+		if err != nil {
+			logger.Debugf("unable to login with auth method %s: %v", cfg.VaultAuthMethod, err)
+			return nil, err
+		}
+		vclient.SetToken(token)
+	}
+
 	logger.Infof("successfully logged into Vault cluster %s", health.ClusterName)
 	logical := vclient.Logical()
 
@@ -64,17 +106,98 @@ func vaultClient(l *log.Logger, cfg Config) (*client, error) {
 
 	metrics = newVaultMetrics(cfg.VaultURL, health.Version, cfg.VaultEngine, health.ClusterID, health.ClusterName)
 
+	// A token given through vault.token would be useless after a restart if revoked, so only tokens
+	// obtained by secrets-manager itself are revoked
+	revokeToken := cfg.VaultRevokeOnShutdown && (wrappedToken != "" || auth != nil)
+	if cfg.VaultRevokeOnShutdown && !revokeToken {
+		logger.Warnf("vault token will not be revoked on shutdown, since it was neither unwrapped nor obtained through an auth method")
+	}
+
 	client := client{
-		vclient:            vclient,
-		logical:            logical,
-		maxTokenTTL:        cfg.VaultMaxTokenTTL,
-		tokenPollingPeriod: cfg.VaultTokenPollingPeriod,
-		renewTTLIncrement:  cfg.VaultRenewTTLIncrement,
-		engine:             engine,
+		vclient:     vclient,
+		logical:     logical,
+		engine:      engine,
+		auth:        auth,
+		revokeToken: revokeToken,
+		ctx:         context.Background(),
+		leases:      make(map[string]*api.Secret),
 	}
 	return &client, err
 }
 
+func checkVaultHealth(sys *api.Sys) (*api.HealthResponse, error) {
+	health, err := sys.Health()
+	if err != nil {
+		return nil, err
+	}
+	if !health.Initialized {
+		return nil, &errors.VaultNotReadyError{ErrType: errors.VaultNotReadyErrorType, Reason: "not initialized"}
+	}
+	if health.Sealed {
+		return nil, &errors.VaultNotReadyError{ErrType: errors.VaultNotReadyErrorType, Reason: "sealed"}
+	}
+	if health.Standby {
+		return nil, &errors.VaultNotReadyError{ErrType: errors.VaultNotReadyErrorType, Reason: "standby"}
+	}
+	return health, nil
+}
+
+// waitForVault checks Vault health with the startup backoff until it is ready or timeout elapses
+func waitForVault(sys *api.Sys, timeout time.Duration) (*api.HealthResponse, error) {
+	var health *api.HealthResponse
+	err := StartupBackoff.Retry(timeout, func() error {
+		var err error
+		health, err = checkVaultHealth(sys)
+		return err
+	}, func(wait time.Duration, err error) {
+		logger.Warnf("vault is not ready yet, retrying in %v: %v", wait, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+func getWrappedToken(cfg Config) (string, error) {
+	if cfg.VaultWrappedTokenFile == "" {
+		return cfg.VaultWrappedToken, nil
+	}
+	content, err := ioutil.ReadFile(cfg.VaultWrappedTokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// unwrapToken exchanges a response-wrapping token for the token it wraps. The wrapping token is
+// looked up before unwrapping it, so a token not created at expectedPath is never unwrapped.
+func unwrapToken(vclient *api.Client, wrappedToken string, expectedPath string) (string, error) {
+	// sys/wrapping/lookup and sys/wrapping/unwrap only need the wrapping token itself
+	vclient.ClearToken()
+
+	lookup, err := vclient.Logical().Write("sys/wrapping/lookup", map[string]interface{}{"token": wrappedToken})
+	if err != nil {
+		return "", &errors.VaultWrappedTokenInvalidError{ErrType: errors.VaultWrappedTokenInvalidErrorType, Reason: err.Error()}
+	}
+	if lookup == nil || lookup.Data == nil {
+		return "", &errors.VaultWrappedTokenInvalidError{ErrType: errors.VaultWrappedTokenInvalidErrorType, Reason: "empty lookup response"}
+	}
+
+	creationPath, _ := lookup.Data["creation_path"].(string)
+	if expectedPath != "" && creationPath != expectedPath {
+		return "", &errors.VaultWrappedTokenPathError{ErrType: errors.VaultWrappedTokenPathErrorType, ExpectedPath: expectedPath, CreationPath: creationPath}
+	}
+
+	secret, err := vclient.Logical().Unwrap(wrappedToken)
+	if err != nil {
+		return "", &errors.VaultWrappedTokenInvalidError{ErrType: errors.VaultWrappedTokenInvalidErrorType, Reason: err.Error()}
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", &errors.VaultWrappedTokenInvalidError{ErrType: errors.VaultWrappedTokenInvalidErrorType, Reason: "response does not wrap a token"}
+	}
+	return secret.Auth.ClientToken, nil
+}
+
 func (c *client) getToken() (*api.Secret, error) {
 	auth := c.vclient.Auth()
 	lookup, err := auth.Token().LookupSelf()
@@ -97,66 +220,115 @@ func (c *client) getTokenTTL(token *api.Secret) (int64, error) {
 	return ttl, nil
 }
 
-func (c *client) shouldRenewToken(ttl int64) bool {
-	if ttl < c.maxTokenTTL {
-		metrics.updateVaultTokenExpiredMetric(vaultTokenExpired)
-		return true
+// tokenLease returns the lease of the current token, along with the TTL it was created with
+func (c *client) tokenLease() (*lease, time.Duration, error) {
+	token, err := c.getToken()
+	if err != nil {
+		return nil, 0, err
 	}
-	metrics.updateVaultTokenExpiredMetric(vaultTokenNotExpired)
-	return false
-}
-
-func (c *client) renewToken(token *api.Secret) error {
-	isRenewable, err := token.TokenIsRenewable()
+	ttl, err := c.getTokenTTL(token)
+	if err != nil {
+		return nil, 0, err
+	}
+	renewable, err := token.TokenIsRenewable()
 	if err != nil {
 		logger.Errorf("could not check token renewability: %v", err)
-		metrics.updateVaultTokenRenewErrorsCountMetric(errors.UnknownErrorType)
-		return err
+		return nil, 0, err
 	}
-	if !isRenewable {
-		metrics.updateVaultTokenRenewErrorsCountMetric(errors.VaultTokenNotRenewableErrorType)
-		err = &errors.VaultTokenNotRenewableError{ErrType: errors.VaultTokenNotRenewableErrorType}
-		return err
+	creationTTL, ok := token.Data["creation_ttl"].(json.Number)
+	if !ok {
+		creationTTL = json.Number("0")
+	}
+	increment, err := creationTTL.Int64()
+	if err != nil {
+		logger.Errorf("couldn't decode creation_ttl from token: %v", err)
+		return nil, 0, err
 	}
+	return &lease{ttl: time.Duration(ttl) * time.Second, renewable: renewable}, time.Duration(increment) * time.Second, nil
+}
+
+func (c *client) renewToken(increment time.Duration) (*lease, error) {
 	auth := c.vclient.Auth()
-	if _, err = auth.Token().RenewSelf(c.renewTTLIncrement); err != nil {
-		log.Errorf("failed to renew token: %v", err)
+	secret, err := auth.Token().RenewSelf(int(increment.Seconds()))
+	if err != nil {
+		logger.Errorf("failed to renew token: %v", err)
 		metrics.updateVaultTokenRenewErrorsCountMetric(errors.UnknownErrorType)
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		metrics.updateVaultTokenRenewErrorsCountMetric(errors.UnknownErrorType)
+		return nil, fmt.Errorf("empty renew-self response")
+	}
+	metrics.updateVaultTokenTTLMetric(int64(secret.Auth.LeaseDuration))
+	return &lease{ttl: time.Duration(secret.Auth.LeaseDuration) * time.Second, renewable: secret.Auth.Renewable}, nil
+}
+
+func (c *client) login() error {
+	// Login endpoints do not need a token, and the current one may be expired already
+	c.vclient.ClearToken()
+	token, err := c.auth.login(c.vclient)
+	if err != nil {
 		return err
 	}
+	c.vclient.SetToken(token)
 	return nil
 }
 
-func (c *client) renewalLoop() {
-	token, err := c.getToken()
+// watchToken keeps the current token alive. It returns false when ctx is done, and true once the token
+// can not be renewed anymore or could not be looked up
+func (c *client) watchToken(ctx context.Context) bool {
+	tokenLease, increment, err := c.tokenLease()
 	if err != nil {
-		logger.Errorf("failed to fetch token: %v", err)
-		return
+		logger.Errorf("failed to look up token: %v", err)
+		return true
 	}
-	ttl, err := c.getTokenTTL(token)
-	if err != nil {
-		logger.Errorf("failed to read token TTL: %v", err)
-		return
-	} else if c.shouldRenewToken(ttl) {
-		logger.Warnf("token is really close to expire, current ttl: %d", ttl)
-		err := c.renewToken(token)
-		if err != nil {
-			logger.Errorf("could not renew token: %v", err)
-		} else {
-			logger.Infoln("token renewed successfully!")
-		}
-	} else {
-		return
+	if tokenLease.ttl == 0 {
+		logger.Infoln("token has no TTL, it does not need to be renewed")
+		<-ctx.Done()
+		return false
+	}
+
+	metrics.updateVaultTokenExpiredMetric(vaultTokenNotExpired)
+	metrics.updateVaultTokenMaxTTLReachedMetric(vaultTokenMaxTTLNotReached)
+	if !tokenLease.renewable {
+		metrics.updateVaultTokenRenewErrorsCountMetric(errors.VaultTokenNotRenewableErrorType)
+	}
+
+	watcher := &lifetimeWatcher{
+		name:      "token",
+		increment: increment,
+		renew:     c.renewToken,
+		maxTTLReached: func(ttl time.Duration) {
+			logger.Warnf("token reached its max TTL, it expires in %v", ttl)
+			metrics.updateVaultTokenMaxTTLReachedMetric(vaultTokenMaxTTLReached)
+		},
+		exhausted: func() {
+			metrics.updateVaultTokenExpiredMetric(vaultTokenExpired)
+		},
 	}
+	return watcher.watch(ctx, tokenLease)
 }
 
 func (c *client) startTokenRenewer(ctx context.Context) {
+	c.ctx = ctx
 	go func(ctx context.Context) {
 		for {
+			if !c.watchToken(ctx) {
+				logger.Infoln("gracefully shutting down token renewal go routine")
+				return
+			}
+
+			if c.auth == nil {
+				logger.Errorf("token can not be renewed anymore and no auth method is configured to get a new one")
+			} else if err := c.login(); err != nil {
+				logger.Errorf("could not login again into Vault: %v", err)
+			} else {
+				logger.Infoln("logged in again into Vault")
+				continue
+			}
+
 			select {
-			case <-time.After(c.tokenPollingPeriod):
-				c.renewalLoop()
-				break
+			case <-time.After(loginRetryPeriod):
 			case <-ctx.Done():
 				logger.Infoln("gracefully shutting down token renewal go routine")
 				return
@@ -165,14 +337,64 @@ func (c *client) startTokenRenewer(ctx context.Context) {
 	}(ctx)
 }
 
+// readSecret reads path from Vault. Secrets read with a lease, like dynamic credentials, are kept alive
+// by a lifetime watcher and served from memory, so they are only read again once their lease is exhausted
+func (c *client) readSecret(path string) (*api.Secret, error) {
+	c.leasesMutex.Lock()
+	secret, ok := c.leases[path]
+	c.leasesMutex.Unlock()
+	if ok {
+		return secret, nil
+	}
+
+	// Vault is read without holding the lock, so that a slow read does not block the reads of other paths
+	secret, err := c.logical.Read(path)
+	if err != nil || secret == nil || secret.LeaseID == "" || secret.LeaseDuration == 0 {
+		return secret, err
+	}
+
+	c.leasesMutex.Lock()
+	defer c.leasesMutex.Unlock()
+	// Another read of the same path may have got a lease meanwhile, the lease read last is not renewed and expires
+	if leased, ok := c.leases[path]; ok {
+		return leased, nil
+	}
+	c.leases[path] = secret
+	go c.watchSecretLease(path, secret)
+	return secret, nil
+}
+
+func (c *client) watchSecretLease(path string, secret *api.Secret) {
+	watcher := &lifetimeWatcher{
+		name:      path,
+		increment: time.Duration(secret.LeaseDuration) * time.Second,
+		renew: func(increment time.Duration) (*lease, error) {
+			renewed, err := c.vclient.Sys().Renew(secret.LeaseID, int(increment.Seconds()))
+			if err != nil {
+				metrics.updateVaultLeaseRenewErrorsCountMetric(path, errors.UnknownErrorType)
+				return nil, err
+			}
+			return &lease{ttl: time.Duration(renewed.LeaseDuration) * time.Second, renewable: renewed.Renewable}, nil
+		},
+		maxTTLReached: func(ttl time.Duration) {
+			logger.Warnf("lease for %s reached its max TTL, it expires in %v", path, ttl)
+		},
+		exhausted: func() {},
+	}
+	watcher.watch(c.ctx, &lease{ttl: time.Duration(secret.LeaseDuration) * time.Second, renewable: secret.Renewable})
+
+	c.leasesMutex.Lock()
+	delete(c.leases, path)
+	c.leasesMutex.Unlock()
+}
+
 func (c *client) ReadSecret(path string, key string) (string, error) {
 	data := ""
 	if key == "" {
 		key = defaultSecretKey
 	}
 
-	logical := c.logical
-	secret, err := logical.Read(path)
+	secret, err := c.readSecret(path)
 	if err != nil {
 		metrics.updateVaultSecretReadErrorsCountMetric(path, key, errors.UnknownErrorType)
 		return data, err
@@ -183,7 +405,7 @@ func (c *client) ReadSecret(path string, key string) (string, error) {
 		warnings := secret.Warnings
 		if secretData != nil {
 			if secretData[key] != nil {
-				data = secretData[key].(string)
+				data, err = stringValue(secretData[key])
 			} else {
 				metrics.updateVaultSecretReadErrorsCountMetric(path, key, errors.BackendSecretNotFoundErrorType)
 				err = &errors.BackendSecretNotFoundError{ErrType: errors.BackendSecretNotFoundErrorType, Path: path, Key: key}
@@ -201,3 +423,113 @@ func (c *client) ReadSecret(path string, key string) (string, error) {
 	}
 	return data, err
 }
+
+func (c *client) ReadSecretData(path string) (map[string]string, error) {
+	secret, err := c.readSecret(path)
+	if err != nil {
+		metrics.updateVaultSecretReadErrorsCountMetric(path, "", errors.UnknownErrorType)
+		return nil, err
+	}
+
+	var secretData map[string]interface{}
+	if secret != nil {
+		secretData = c.engine.getData(secret)
+	}
+	if secretData == nil {
+		metrics.updateVaultSecretReadErrorsCountMetric(path, "", errors.BackendSecretNotFoundErrorType)
+		return nil, &errors.BackendSecretNotFoundError{ErrType: errors.BackendSecretNotFoundErrorType, Path: path}
+	}
+
+	data := make(map[string]string, len(secretData))
+	for k, v := range secretData {
+		data[k], err = stringValue(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// stringValue returns the value of a secret key as a string, keeping non string values as JSON
+func stringValue(v interface{}) (string, error) {
+	if value, ok := v.(string); ok {
+		return value, nil
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (c *client) ListSecrets(path string) ([]string, error) {
+	listPath, err := c.engine.listPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.listSecrets(strings.Trim(path, "/"), listPath)
+}
+
+// listSecrets walks listPath recursively, returning the secrets found as paths under path
+func (c *client) listSecrets(path string, listPath string) ([]string, error) {
+	secret, err := c.logical.List(listPath)
+	if err != nil {
+		metrics.updateVaultSecretReadErrorsCountMetric(listPath, "", errors.UnknownErrorType)
+		return nil, err
+	}
+	// Listing a path with no secrets under it returns nothing
+	if secret == nil || secret.Data == nil {
+		return []string{}, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	paths := make([]string, 0, len(keys))
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			key = strings.TrimSuffix(key, "/")
+			subPaths, err := c.listSecrets(path+"/"+key, listPath+"/"+key)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, subPaths...)
+			continue
+		}
+		paths = append(paths, path+"/"+key)
+	}
+	return paths, nil
+}
+
+func (c *client) ReadSecretMetadata(path string) (*SecretMetadata, error) {
+	metadataPath, err := c.engine.metadataPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.logical.Read(metadataPath)
+	if err != nil {
+		metrics.updateVaultSecretReadErrorsCountMetric(metadataPath, "", errors.UnknownErrorType)
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		metrics.updateVaultSecretReadErrorsCountMetric(metadataPath, "", errors.BackendSecretNotFoundErrorType)
+		return nil, &errors.BackendSecretNotFoundError{ErrType: errors.BackendSecretNotFoundErrorType, Path: metadataPath}
+	}
+	return c.engine.getMetadata(secret)
+}
+
+// Close revokes the token if the client was configured to do so on shutdown
+func (c *client) Close() error {
+	if !c.revokeToken {
+		return nil
+	}
+	if err := c.vclient.Auth().Token().RevokeSelf(""); err != nil {
+		logger.Errorf("could not revoke vault token: %v", err)
+		return err
+	}
+	logger.Infoln("vault token revoked")
+	return nil
+}
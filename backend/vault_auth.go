@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/tuenti/secrets-manager/errors"
+)
+
+const (
+	kubernetesAuthMethodName = "kubernetes"
+	serviceAccountTokenFile  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// authMethod logs into Vault, returning a new client token
+type authMethod interface {
+	login(vclient *api.Client) (string, error)
+}
+
+type kubernetesAuth struct {
+	mountPath string
+	role      string
+	jwtFile   string
+}
+
+func (a kubernetesAuth) login(vclient *api.Client) (string, error) {
+	jwt, err := ioutil.ReadFile(a.jwtFile)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := vclient.Logical().Write(fmt.Sprintf("auth/%s/login", a.mountPath), map[string]interface{}{
+		"role": a.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("empty login response from auth/%s/login", a.mountPath)
+	}
+	return secret.Auth.ClientToken, nil
+}
+
+func newAuthMethod(cfg Config) (authMethod, error) {
+	mountPath := cfg.VaultAuthMountPath
+	if mountPath == "" {
+		mountPath = cfg.VaultAuthMethod
+	}
+	switch cfg.VaultAuthMethod {
+	case "":
+		return nil, nil
+	case kubernetesAuthMethodName:
+		return kubernetesAuth{mountPath: mountPath, role: cfg.VaultAuthRole, jwtFile: serviceAccountTokenFile}, nil
+	default:
+		return nil, &errors.VaultAuthMethodNotImplementedError{ErrType: errors.VaultAuthMethodNotImplementedErrorType, AuthMethod: cfg.VaultAuthMethod}
+	}
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/errors"
+)
+
+func TestNewAuthMethodNone(t *testing.T) {
+	auth, err := newAuthMethod(Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, auth)
+}
+
+func TestNewAuthMethodKubernetes(t *testing.T) {
+	auth, err := newAuthMethod(Config{VaultAuthMethod: "kubernetes", VaultAuthRole: "secrets-manager"})
+	assert.Nil(t, err)
+	assert.Equal(t, kubernetesAuth{mountPath: "kubernetes", role: "secrets-manager", jwtFile: serviceAccountTokenFile}, auth)
+}
+
+func TestNewAuthMethodKubernetesMountPath(t *testing.T) {
+	auth, err := newAuthMethod(Config{VaultAuthMethod: "kubernetes", VaultAuthRole: "secrets-manager", VaultAuthMountPath: "k8s-cluster-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-cluster-1", auth.(kubernetesAuth).mountPath)
+}
+
+func TestNewAuthMethodNotImplemented(t *testing.T) {
+	auth, err := newAuthMethod(Config{VaultAuthMethod: "foo"})
+	assert.Nil(t, auth)
+	assert.EqualError(t, err, fmt.Sprintf("[%s] vault auth method %s not supported", errors.VaultAuthMethodNotImplementedErrorType, "foo"))
+}
+
+func TestKubernetesAuthLogin(t *testing.T) {
+	jwtFile, err := ioutil.TempFile("", "jwt")
+	assert.Nil(t, err)
+	defer os.Remove(jwtFile.Name())
+	jwtFile.WriteString(fakeServiceAccountJWT)
+	jwtFile.Close()
+
+	vclient, _ := api.NewClient(&api.Config{Address: vaultCfg.VaultURL})
+	auth := kubernetesAuth{mountPath: "kubernetes", role: "secrets-manager", jwtFile: jwtFile.Name()}
+	token, err := auth.login(vclient)
+
+	assert.Nil(t, err)
+	assert.Equal(t, fakeToken, token)
+}
+
+func TestKubernetesAuthLoginMissingJWT(t *testing.T) {
+	vclient, _ := api.NewClient(&api.Config{Address: vaultCfg.VaultURL})
+	auth := kubernetesAuth{mountPath: "kubernetes", role: "secrets-manager", jwtFile: "/this/file/does/not/exist"}
+	token, err := auth.login(vclient)
+
+	assert.NotNil(t, err)
+	assert.Empty(t, token)
+}
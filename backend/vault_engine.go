@@ -1,6 +1,11 @@
 package backend
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/hashicorp/vault/api"
 	"github.com/tuenti/secrets-manager/errors"
 )
@@ -12,6 +17,11 @@ const (
 
 type engine interface {
 	getData(s *api.Secret) map[string]interface{}
+	// metadataPath returns the path where the metadata of the secret at path is kept
+	metadataPath(path string) (string, error)
+	getMetadata(s *api.Secret) (*SecretMetadata, error)
+	// listPath returns the path to list to find the secrets under path
+	listPath(path string) (string, error)
 }
 
 type kvEngineV1 struct {
@@ -26,6 +36,18 @@ func (e kvEngineV1) getData(s *api.Secret) map[string]interface{} {
 	return s.Data
 }
 
+func (e kvEngineV1) metadataPath(path string) (string, error) {
+	return "", &errors.VaultSecretMetadataNotSupportedError{ErrType: errors.VaultSecretMetadataNotSupportedErrorType, Engine: e.name, Path: path}
+}
+
+func (e kvEngineV1) getMetadata(s *api.Secret) (*SecretMetadata, error) {
+	return nil, &errors.VaultSecretMetadataNotSupportedError{ErrType: errors.VaultSecretMetadataNotSupportedErrorType, Engine: e.name}
+}
+
+func (e kvEngineV1) listPath(path string) (string, error) {
+	return strings.Trim(path, "/"), nil
+}
+
 func (e kvEngineV2) getData(s *api.Secret) map[string]interface{} {
 	if s.Data["data"] == nil {
 		return nil
@@ -33,6 +55,52 @@ func (e kvEngineV2) getData(s *api.Secret) map[string]interface{} {
 	return s.Data["data"].(map[string]interface{})
 }
 
+// metadataPath turns a <mount>/data/<path> secret path into <mount>/metadata/<path>
+func (e kvEngineV2) metadataPath(path string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) != 3 || parts[1] != "data" {
+		return "", &errors.VaultSecretMetadataNotSupportedError{ErrType: errors.VaultSecretMetadataNotSupportedErrorType, Engine: e.name, Path: path}
+	}
+	return fmt.Sprintf("%s/metadata/%s", parts[0], parts[2]), nil
+}
+
+func (e kvEngineV2) getMetadata(s *api.Secret) (*SecretMetadata, error) {
+	metadata := &SecretMetadata{CustomMetadata: make(map[string]string)}
+
+	if version, ok := s.Data["current_version"].(json.Number); ok {
+		v, err := version.Int64()
+		if err != nil {
+			return nil, err
+		}
+		metadata.Version = int(v)
+	}
+
+	if updatedTime, ok := s.Data["updated_time"].(string); ok && updatedTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, updatedTime)
+		if err != nil {
+			return nil, err
+		}
+		metadata.UpdatedTime = t
+	}
+
+	if customMetadata, ok := s.Data["custom_metadata"].(map[string]interface{}); ok {
+		for k, v := range customMetadata {
+			metadata.CustomMetadata[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return metadata, nil
+}
+
+// listPath turns a <mount>/data[/<path>] folder into <mount>/metadata[/<path>]
+func (e kvEngineV2) listPath(path string) (string, error) {
+	path = strings.Trim(path, "/")
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) == 2 && parts[1] == "data" {
+		return fmt.Sprintf("%s/metadata", parts[0]), nil
+	}
+	return e.metadataPath(path)
+}
+
 func newEngine(eng string) (engine, error) {
 	if eng == "" {
 		eng = kvEngineV2Name
@@ -1,8 +1,10 @@
 package backend
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/assert"
@@ -63,3 +65,74 @@ func TestGetDataKv2WithKv1Engine(t *testing.T) {
 	assert.NotNil(t, d)
 	assert.Equal(t, data, d)
 }
+
+func TestMetadataPathKv1(t *testing.T) {
+	engine, _ := newEngine("kv1")
+	_, err := engine.metadataPath("secret/foo")
+	assert.EqualError(t, err, fmt.Sprintf("[%s] vault engine %s keeps no metadata for %s", errors.VaultSecretMetadataNotSupportedErrorType, "kv1", "secret/foo"))
+}
+
+func TestMetadataPathKv2(t *testing.T) {
+	engine, _ := newEngine("kv2")
+	path, err := engine.metadataPath("secret/data/foo/bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret/metadata/foo/bar", path)
+
+	path, err = engine.metadataPath("/secret/data/foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret/metadata/foo", path)
+}
+
+func TestMetadataPathKv2InvalidPath(t *testing.T) {
+	engine, _ := newEngine("kv2")
+	_, err := engine.metadataPath("secret/foo")
+	assert.True(t, errors.IsVaultSecretMetadataNotSupported(err))
+}
+
+func TestGetMetadataKv2(t *testing.T) {
+	data := map[string]interface{}{
+		"current_version": json.Number("3"),
+		"updated_time":    "2018-09-25T08:35:15.504392904Z",
+		"custom_metadata": map[string]interface{}{
+			"owner": "team-a",
+		},
+	}
+	s := &api.Secret{Data: data}
+	engine, _ := newEngine("kv2")
+	metadata, err := engine.getMetadata(s)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, metadata.Version)
+	assert.Equal(t, time.Date(2018, 9, 25, 8, 35, 15, 504392904, time.UTC), metadata.UpdatedTime)
+	assert.Equal(t, map[string]string{"owner": "team-a"}, metadata.CustomMetadata)
+}
+
+func TestGetMetadataKv2NoCustomMetadata(t *testing.T) {
+	data := map[string]interface{}{
+		"current_version": json.Number("1"),
+		"custom_metadata": nil,
+	}
+	s := &api.Secret{Data: data}
+	engine, _ := newEngine("kv2")
+	metadata, err := engine.getMetadata(s)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, metadata.Version)
+	assert.Empty(t, metadata.CustomMetadata)
+}
+
+func TestListPathKv1(t *testing.T) {
+	engine, _ := newEngine("kv1")
+	path, err := engine.listPath("/secret/foo/")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret/foo", path)
+}
+
+func TestListPathKv2(t *testing.T) {
+	engine, _ := newEngine("kv2")
+	path, err := engine.listPath("secret/data/foo/")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret/metadata/foo", path)
+
+	path, err = engine.listPath("secret/data")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret/metadata", path)
+}
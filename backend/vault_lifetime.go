@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// leaseRenewFraction is the fraction of a lease TTL to let elapse before renewing it
+const leaseRenewFraction = 2.0 / 3.0
+
+// minLeaseRenewPeriod is the shortest time to wait between two renewal attempts
+var minLeaseRenewPeriod = time.Second
+
+// lease holds the lifetime details of a Vault token or a leased secret
+type lease struct {
+	ttl       time.Duration
+	renewable bool
+}
+
+// lifetimeWatcher keeps a lease alive, renewing it once two thirds of its TTL have elapsed,
+// until Vault refuses to extend it any further
+type lifetimeWatcher struct {
+	name string
+	// increment is the TTL asked for on every renewal, usually the TTL the lease was created with
+	increment time.Duration
+	renew     func(increment time.Duration) (*lease, error)
+	// maxTTLReached is called when a renewal is granted less than the requested increment
+	maxTTLReached func(ttl time.Duration)
+	// exhausted is called once the lease can not be renewed anymore, before it expires if possible
+	exhausted func()
+}
+
+func renewAfter(ttl time.Duration) time.Duration {
+	wait := time.Duration(float64(ttl) * leaseRenewFraction)
+	if wait < minLeaseRenewPeriod {
+		return minLeaseRenewPeriod
+	}
+	return wait
+}
+
+// watch blocks until the lease is exhausted or ctx is done, returning true in the former case
+func (w *lifetimeWatcher) watch(ctx context.Context, l *lease) bool {
+	expiration := time.Now().Add(l.ttl)
+	renewable := l.renewable
+
+	for {
+		select {
+		case <-time.After(renewAfter(time.Until(expiration))):
+		case <-ctx.Done():
+			return false
+		}
+
+		if !renewable {
+			logger.Infof("%s lease can not be renewed anymore, it expires at %s", w.name, expiration.Format(time.RFC3339))
+			w.exhausted()
+			return true
+		}
+
+		renewed, err := w.renew(w.increment)
+		if err != nil {
+			logger.Errorf("could not renew %s lease: %v", w.name, err)
+			if time.Until(expiration) <= minLeaseRenewPeriod {
+				w.exhausted()
+				return true
+			}
+			continue
+		}
+		logger.Debugf("%s lease renewed, new ttl: %v", w.name, renewed.ttl)
+
+		expiration = time.Now().Add(renewed.ttl)
+		renewable = renewed.renewable
+		if renewed.ttl < w.increment {
+			w.maxTTLReached(renewed.ttl)
+			renewable = false
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	if logger == nil {
+		logger = log.New()
+	}
+}
+
+func TestRenewAfter(t *testing.T) {
+	assert.Equal(t, 40*time.Second, renewAfter(60*time.Second))
+	assert.Equal(t, minLeaseRenewPeriod, renewAfter(0))
+}
+
+func TestLifetimeWatcherRenews(t *testing.T) {
+	minLeaseRenewPeriod = time.Millisecond
+	defer func() { minLeaseRenewPeriod = time.Second }()
+
+	renewals := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := &lifetimeWatcher{
+		name:      "test",
+		increment: 30 * time.Millisecond,
+		renew: func(increment time.Duration) (*lease, error) {
+			renewals++
+			if renewals == 3 {
+				cancel()
+			}
+			return &lease{ttl: increment, renewable: true}, nil
+		},
+		maxTTLReached: func(ttl time.Duration) { t.Error("max TTL must not be reached") },
+		exhausted:     func() { t.Error("lease must not be exhausted") },
+	}
+
+	assert.False(t, watcher.watch(ctx, &lease{ttl: 30 * time.Millisecond, renewable: true}))
+	assert.Equal(t, 3, renewals)
+}
+
+func TestLifetimeWatcherMaxTTLReached(t *testing.T) {
+	minLeaseRenewPeriod = time.Millisecond
+	defer func() { minLeaseRenewPeriod = time.Second }()
+
+	renewals := 0
+	maxTTLReached := false
+	exhausted := false
+	watcher := &lifetimeWatcher{
+		name:      "test",
+		increment: 30 * time.Millisecond,
+		renew: func(increment time.Duration) (*lease, error) {
+			renewals++
+			return &lease{ttl: 10 * time.Millisecond, renewable: true}, nil
+		},
+		maxTTLReached: func(ttl time.Duration) { maxTTLReached = true },
+		exhausted:     func() { exhausted = true },
+	}
+
+	assert.True(t, watcher.watch(context.Background(), &lease{ttl: 30 * time.Millisecond, renewable: true}))
+	assert.Equal(t, 1, renewals)
+	assert.True(t, maxTTLReached)
+	assert.True(t, exhausted)
+}
+
+func TestLifetimeWatcherNotRenewable(t *testing.T) {
+	minLeaseRenewPeriod = time.Millisecond
+	defer func() { minLeaseRenewPeriod = time.Second }()
+
+	exhausted := false
+	watcher := &lifetimeWatcher{
+		name:      "test",
+		increment: 30 * time.Millisecond,
+		renew: func(increment time.Duration) (*lease, error) {
+			t.Error("a not renewable lease must not be renewed")
+			return nil, errors.New("not renewable")
+		},
+		maxTTLReached: func(ttl time.Duration) {},
+		exhausted:     func() { exhausted = true },
+	}
+
+	assert.True(t, watcher.watch(context.Background(), &lease{ttl: 30 * time.Millisecond, renewable: false}))
+	assert.True(t, exhausted)
+}
+
+func TestLifetimeWatcherRenewErrors(t *testing.T) {
+	minLeaseRenewPeriod = time.Millisecond
+	defer func() { minLeaseRenewPeriod = time.Second }()
+
+	renewals := 0
+	exhausted := false
+	watcher := &lifetimeWatcher{
+		name:      "test",
+		increment: 30 * time.Millisecond,
+		renew: func(increment time.Duration) (*lease, error) {
+			renewals++
+			return nil, errors.New("some vault error")
+		},
+		maxTTLReached: func(ttl time.Duration) {},
+		exhausted:     func() { exhausted = true },
+	}
+
+	assert.True(t, watcher.watch(context.Background(), &lease{ttl: 30 * time.Millisecond, renewable: true}))
+	// Failed renewals are retried until the lease is about to expire
+	assert.True(t, renewals > 1)
+	assert.True(t, exhausted)
+}
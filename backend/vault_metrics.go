@@ -3,13 +3,16 @@ package backend
 import "github.com/prometheus/client_golang/prometheus"
 
 const (
-	vaultTokenExpired    = 1
-	vaultTokenNotExpired = 0
+	vaultTokenExpired          = 1
+	vaultTokenNotExpired       = 0
+	vaultTokenMaxTTLReached    = 1
+	vaultTokenMaxTTLNotReached = 0
 )
 
 var (
 	vaultLabelNames  = []string{"vault_address", "vault_engine", "vault_version", "vault_cluster_id", "vault_cluster_name"}
 	secretLabelNames = []string{"path", "key", "error"}
+	leaseLabelNames  = []string{"path", "error"}
 	errorLabelNames  = []string{"error"}
 
 	// Prometeheus metrics: https://prometheus.io
@@ -17,7 +20,13 @@ var (
 		Namespace: "secrets_manager",
 		Subsystem: "vault",
 		Name:      "token_expired",
-		Help:      "The state of the token: 1 = expired; 0 = still valid",
+		Help:      "The state of the token: 1 = expired or can not be renewed anymore; 0 = still valid",
+	}, vaultLabelNames)
+	tokenMaxTTLReached = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "vault",
+		Name:      "token_max_ttl_reached",
+		Help:      "Whether the token reached its max TTL: 1 = renewals can not extend it anymore; 0 = renewable",
 	}, vaultLabelNames)
 	tokenTTL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "secrets_manager",
@@ -37,6 +46,12 @@ var (
 		Name:      "token_renew_errors_count",
 		Help:      "Vault token renew-self errors counter",
 	}, append(vaultLabelNames, errorLabelNames...))
+	leaseRenewErrorsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "vault",
+		Name:      "lease_renew_errors_count",
+		Help:      "Vault secret lease renew errors counter",
+	}, append(vaultLabelNames, leaseLabelNames...))
 	secretReadErrorsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "secrets_manager",
 		Subsystem: "vault",
@@ -51,9 +66,11 @@ type vaultMetrics struct {
 
 func init() {
 	prometheus.MustRegister(tokenExpired)
+	prometheus.MustRegister(tokenMaxTTLReached)
 	prometheus.MustRegister(tokenTTL)
 	prometheus.MustRegister(tokenLookupErrorsCount)
 	prometheus.MustRegister(tokenRenewErrorsCount)
+	prometheus.MustRegister(leaseRenewErrorsCount)
 	prometheus.MustRegister(secretReadErrorsCount)
 }
 
@@ -82,6 +99,20 @@ func (vm *vaultMetrics) updateVaultTokenExpiredMetric(value int) {
 		vm.vaultLabels["vault_cluster_name"]).Set(float64(value))
 }
 
+func (vm *vaultMetrics) updateVaultTokenMaxTTLReachedMetric(value int) {
+	if value != vaultTokenMaxTTLReached && value != vaultTokenMaxTTLNotReached {
+		logger.Errorf("refusing to update secrets_manager_vault_token_max_ttl_reached metric with value %d. Allowed values are %d and %d", value, vaultTokenMaxTTLReached, vaultTokenMaxTTLNotReached)
+		return
+	}
+
+	tokenMaxTTLReached.WithLabelValues(
+		vm.vaultLabels["vault_addr"],
+		vm.vaultLabels["vault_engine"],
+		vm.vaultLabels["vault_version"],
+		vm.vaultLabels["vault_cluster_id"],
+		vm.vaultLabels["vault_cluster_name"]).Set(float64(value))
+}
+
 func (vm *vaultMetrics) updateVaultTokenTTLMetric(value int64) {
 	tokenTTL.WithLabelValues(
 		vm.vaultLabels["vault_addr"],
@@ -122,3 +153,14 @@ func (vm *vaultMetrics) updateVaultTokenRenewErrorsCountMetric(errorType string)
 		vm.vaultLabels["vault_cluster_name"],
 		errorType).Inc()
 }
+
+func (vm *vaultMetrics) updateVaultLeaseRenewErrorsCountMetric(path string, errorType string) {
+	leaseRenewErrorsCount.WithLabelValues(
+		vm.vaultLabels["vault_addr"],
+		vm.vaultLabels["vault_engine"],
+		vm.vaultLabels["vault_version"],
+		vm.vaultLabels["vault_cluster_id"],
+		vm.vaultLabels["vault_cluster_name"],
+		path,
+		errorType).Inc()
+}
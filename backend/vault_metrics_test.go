@@ -25,6 +25,15 @@ func TestUpdateTokenExpired(t *testing.T) {
 	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenExpired))
 }
 
+func TestUpdateTokenMaxTTLReached(t *testing.T) {
+	metrics := newVaultMetrics(fakeVaultAddress, fakeVaultVersion, fakeVaultEngine, fakeVaultClusterID, fakeVaultClusterName)
+	tokenMaxTTLReached.Reset()
+	metrics.updateVaultTokenMaxTTLReachedMetric(1)
+	metricTokenMaxTTLReached, _ := tokenMaxTTLReached.GetMetricWithLabelValues(fakeVaultAddress, fakeVaultEngine, fakeVaultVersion, fakeVaultClusterID, fakeVaultClusterName)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenMaxTTLReached))
+}
+
 func TestUpdateTokenTTL(t *testing.T) {
 	metrics := newVaultMetrics(fakeVaultAddress, fakeVaultVersion, fakeVaultEngine, fakeVaultClusterID, fakeVaultClusterName)
 	tokenTTL.Reset()
@@ -75,3 +84,14 @@ func TestUpdateReadSecretErrorsCount(t *testing.T) {
 
 	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretReadErrorsCount))
 }
+
+func TestUpdateLeaseRenewErrorsCount(t *testing.T) {
+	path := "/database/creds/role"
+
+	metrics := newVaultMetrics(fakeVaultAddress, fakeVaultVersion, fakeVaultEngine, fakeVaultClusterID, fakeVaultClusterName)
+	leaseRenewErrorsCount.Reset()
+	metrics.updateVaultLeaseRenewErrorsCountMetric(path, errors.UnknownErrorType)
+	metricLeaseRenewErrorsCount, _ := leaseRenewErrorsCount.GetMetricWithLabelValues(fakeVaultAddress, fakeVaultEngine, fakeVaultVersion, fakeVaultClusterID, fakeVaultClusterName, path, errors.UnknownErrorType)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricLeaseRenewErrorsCount))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -27,12 +28,21 @@ const (
 	defaultTokenTTL       = 40
 	defaultTokenRenewable = true
 	defaultRevokedToken   = false
+	fakeWrappedToken      = "fake-wrapped-token"
+	fakeWrappedTokenPath  = "auth/token/create"
+	fakeServiceAccountJWT = "fake-service-account-jwt"
 )
 
 type testConfig struct {
-	tokenTTL       int
-	tokenRenewable bool
-	tokenRevoked   bool
+	tokenTTL         int
+	tokenRenewable   bool
+	tokenRevoked     bool
+	wrappedTokenUsed bool
+	wrappedTokenPath string
+	// leasedSecretReads counts the reads of the leased secret
+	leasedSecretReads int
+	// sealedHealthChecks is the number of health checks to answer as sealed before unsealing
+	sealedHealthChecks int
 }
 
 var (
@@ -44,10 +54,14 @@ var (
 
 func v1SysHealth(w http.ResponseWriter, r *http.Request) {
 	var response interface{}
+	sealed := testCfg.sealedHealthChecks > 0
+	if sealed {
+		testCfg.sealedHealthChecks--
+	}
 	jsonData := fmt.Sprintf(`
 	{
 		"initialized": true,
-		"sealed": false,
+		"sealed": %t,
 		"standby": false,
 		"performance_standby": false,
 		"replication_performance_mode": "disabled",
@@ -56,7 +70,7 @@ func v1SysHealth(w http.ResponseWriter, r *http.Request) {
 		"version": "%s",
 		"cluster_name": "%s",
 		"cluster_id": "%s"
-	}`, vaultFakeVersion, vaultFakeClusterName, vaultFakeClusterID)
+	}`, sealed, vaultFakeVersion, vaultFakeClusterName, vaultFakeClusterID)
 
 	if err := json.Unmarshal([]byte(jsonData), &response); err != nil {
 		fmt.Printf("unable to unmarshal json %v", err)
@@ -147,6 +161,101 @@ func v1AuthTokenRenewSelf(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func v1SysWrappingLookup(w http.ResponseWriter, r *http.Request) {
+	var request map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request["token"] != fakeWrappedToken || testCfg.wrappedTokenUsed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":["wrapping token is not valid or does not exist"]}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `
+	{
+		"request_id": "481320f5-fdf8-885d-8050-65fa767fd19b",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": {
+			"creation_path": "%s",
+			"creation_time": "2019-01-21T17:35:58.79776585Z",
+			"creation_ttl": 60
+		},
+		"wrap_info": null,
+		"warnings": null,
+		"auth": null
+	}`, testCfg.wrappedTokenPath)
+}
+
+func v1SysWrappingUnwrap(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != fakeWrappedToken || testCfg.wrappedTokenUsed {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":["wrapping token is not valid or does not exist"]}`)
+		return
+	}
+	testCfg.wrappedTokenUsed = true
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `
+	{
+		"request_id": "d8ae3e67-91a0-2f7a-528b-522048f9dad3",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": null,
+		"wrap_info": null,
+		"warnings": null,
+		"auth": {
+			"client_token": "%s",
+			"accessor": "dc6aa861-3020-322c-8df5-4b08afa43a34",
+			"policies": [
+				"fake-policy"
+			],
+			"metadata": null,
+			"lease_duration": 1000,
+			"renewable": true
+		}
+	}`, fakeToken)
+}
+
+func v1AuthKubernetesLogin(w http.ResponseWriter, r *http.Request) {
+	var request map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request["jwt"] != fakeServiceAccountJWT {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `
+	{
+		"request_id": "a6a3d8b4-7cd1-4d3a-9c1b-d1e4c2f1b5d7",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": null,
+		"wrap_info": null,
+		"warnings": null,
+		"auth": {
+			"client_token": "%s",
+			"accessor": "dc6aa861-3020-322c-8df5-4b08afa43a34",
+			"policies": [
+				"fake-policy"
+			],
+			"metadata": {
+				"role": "%s"
+			},
+			"lease_duration": 1000,
+			"renewable": true
+		}
+	}`, fakeToken, request["role"])
+}
+
+func v1AuthTokenRevokeSelf(w http.ResponseWriter, r *http.Request) {
+	testCfg.tokenRevoked = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func v1SecretTestKv2(w http.ResponseWriter, r *http.Request) {
 	var response interface{}
 	jsonData := `
@@ -157,7 +266,8 @@ func v1SecretTestKv2(w http.ResponseWriter, r *http.Request) {
 		"lease_duration": 0,
 		"data": {
 			"data": {
-				"foo": "bar"
+				"foo": "bar",
+				"settings": {"debug": true}
 			},
 			"metadata": {
 				"created_time": "2018-09-25T08:35:15.504392904Z",
@@ -178,6 +288,98 @@ func v1SecretTestKv2(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func v1DatabaseCreds(w http.ResponseWriter, r *http.Request) {
+	testCfg.leasedSecretReads++
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `
+	{
+		"request_id": "5e2ff2e3-ad64-3d6e-8f58-a4cbd2d0c4cd",
+		"lease_id": "database/creds/role/2f6a614c-4aa2-7b19-24b9-ad944a8d4de6",
+		"renewable": true,
+		"lease_duration": 3600,
+		"data": {
+			"username": "v-role-fake-user",
+			"password": "fake-password"
+		},
+		"wrap_info": null,
+		"warnings": null,
+		"auth": null
+	}`)
+}
+
+func v1SecretMetadataTestKv2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `
+	{
+		"request_id": "0bd8e4fe-4a8a-2b18-2b4f-1e5a3bcb0d7f",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": {
+			"cas_required": false,
+			"created_time": "2018-09-25T08:35:15.504392904Z",
+			"current_version": 1,
+			"custom_metadata": {
+				"owner": "team-a"
+			},
+			"delete_version_after": "0s",
+			"max_versions": 0,
+			"oldest_version": 0,
+			"updated_time": "2018-09-25T08:35:15.504392904Z",
+			"versions": {
+				"1": {
+					"created_time": "2018-09-25T08:35:15.504392904Z",
+					"deletion_time": "",
+					"destroyed": false
+				}
+			}
+		},
+		"wrap_info": null,
+		"warnings": null,
+		"auth": null
+	}`)
+}
+
+func v1SecretMetadataListTeam(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `
+	{
+		"request_id": "5d3a1f6e-8c1b-4c0e-bd2a-0f4a3a3f9b51",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": {
+			"keys": ["db", "nested/"]
+		},
+		"wrap_info": null,
+		"warnings": null,
+		"auth": null
+	}`)
+}
+
+func v1SecretMetadataListTeamNested(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `
+	{
+		"request_id": "9f0c2a8e-2b7d-4d56-a1e4-6b8d3c2e7f10",
+		"lease_id": "",
+		"renewable": false,
+		"lease_duration": 0,
+		"data": {
+			"keys": ["api"]
+		},
+		"wrap_info": null,
+		"warnings": null,
+		"auth": null
+	}`)
+}
+
+func v1SecretMetadataListNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"errors": []}`)
+}
+
 func v1SecretTestKv1(w http.ResponseWriter, r *http.Request) {
 	var response interface{}
 	jsonData := `
@@ -230,6 +432,50 @@ func TestVaultClientInvalidCfg(t *testing.T) {
 	assert.Nil(t, client)
 }
 
+func TestVaultClientSealed(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.sealedHealthChecks = 1
+
+	client, err := vaultClient(nil, vaultCfg)
+
+	assert.Nil(t, client)
+	assert.True(t, errors.IsVaultNotReady(err))
+	assert.EqualError(t, err, fmt.Sprintf("[%s] vault is not ready: sealed", errors.VaultNotReadyErrorType))
+}
+
+func TestVaultClientWaitsForUnseal(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	StartupBackoff.Initial = time.Millisecond
+	defer func() { StartupBackoff.Initial = time.Second }()
+	testCfg.sealedHealthChecks = 3
+
+	cfg := vaultCfg
+	cfg.StartupTimeout = time.Second
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, 0, testCfg.sealedHealthChecks)
+}
+
+func TestVaultClientStartupTimeout(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	StartupBackoff.Initial = time.Millisecond
+	defer func() { StartupBackoff.Initial = time.Second }()
+	testCfg.sealedHealthChecks = 1000
+	defer func() { testCfg.sealedHealthChecks = 0 }()
+
+	cfg := vaultCfg
+	cfg.StartupTimeout = 20 * time.Millisecond
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, client)
+	assert.True(t, errors.IsVaultNotReady(err))
+}
+
 func TestGetToken(t *testing.T) {
 	client, err := vaultClient(nil, vaultCfg)
 	token, err := client.getToken()
@@ -250,93 +496,105 @@ func TestGetTokenTTL(t *testing.T) {
 	assert.Nil(t, err)
 }
 
-func TestShouldRenewToken(t *testing.T) {
+func TestTokenLease(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
 	mutex.Lock()
 	defer mutex.Unlock()
+	testCfg.tokenRenewable = true
+	testCfg.tokenRevoked = false
 	testCfg.tokenTTL = 600
-	client.maxTokenTTL = 6000
-	tokenExpired.Reset()
-	metricTokenExp, _ := tokenExpired.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName)
 
-	assert.True(t, client.shouldRenewToken(int64(testCfg.tokenTTL)))
-	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenExp))
+	tokenLease, increment, err := client.tokenLease()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 600*time.Second, tokenLease.ttl)
+	assert.True(t, tokenLease.renewable)
+	assert.Equal(t, 60*time.Second, increment)
 }
 
-func TestShouldNotRenewToken(t *testing.T) {
+func TestRenewToken(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
-	mutex.Lock()
-	defer mutex.Unlock()
-	testCfg.tokenTTL = 600
-	client.maxTokenTTL = 60
-
-	assert.False(t, client.shouldRenewToken(int64(testCfg.tokenTTL)))
+	tokenTTL.Reset()
 
-	tokenExpired.Reset()
-	metricTokenExp, _ := tokenExpired.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName)
+	tokenLease, err := client.renewToken(60 * time.Second)
+	metricTokenTTL, _ := tokenTTL.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName)
 
-	assert.Equal(t, 0.0, testutil.ToFloat64(metricTokenExp))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000*time.Second, tokenLease.ttl)
+	assert.True(t, tokenLease.renewable)
+	assert.Equal(t, 1000.0, testutil.ToFloat64(metricTokenTTL))
 }
 
-func TestRenewToken(t *testing.T) {
+func TestWatchTokenRevoked(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
 	mutex.Lock()
 	defer mutex.Unlock()
-	testCfg.tokenRenewable = true
-	testCfg.tokenTTL = 600
-	client.maxTokenTTL = 6000
+	testCfg.tokenRevoked = true
+	defer func() { testCfg.tokenRevoked = false }()
+	tokenLookupErrorsCount.Reset()
 
-	token, err := client.getToken()
-	err = client.renewToken(token)
+	assert.True(t, client.watchToken(context.Background()))
 
-	assert.Nil(t, err)
+	metricTokenLookupErrorsCount, _ := tokenLookupErrorsCount.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName, errors.UnknownErrorType)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenLookupErrorsCount))
 }
 
-func TestTokenNotRenewableError(t *testing.T) {
+func TestWatchTokenNotRenewable(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
 	mutex.Lock()
 	defer mutex.Unlock()
 	testCfg.tokenRenewable = false
-	testCfg.tokenTTL = 600
-	client.maxTokenTTL = 6000
-
-	token, err := client.getToken()
-
+	testCfg.tokenRevoked = false
+	testCfg.tokenTTL = 1
+	defer func() {
+		testCfg.tokenRenewable = defaultTokenRenewable
+		testCfg.tokenTTL = defaultTokenTTL
+	}()
+	tokenExpired.Reset()
 	tokenRenewErrorsCount.Reset()
-	err = client.renewToken(token)
 
-	metricTokenRenewErrorsCount, _ := tokenRenewErrorsCount.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName, errors.VaultTokenNotRenewableErrorType)
+	assert.True(t, client.watchToken(context.Background()))
 
+	metricTokenExpired, _ := tokenExpired.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenExpired))
+	metricTokenRenewErrorsCount, _ := tokenRenewErrorsCount.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName, errors.VaultTokenNotRenewableErrorType)
 	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenRenewErrorsCount))
-	assert.EqualError(t, err, fmt.Sprintf("[%s] vault token not renewable", errors.VaultTokenNotRenewableErrorType))
 }
 
-func TestRenewalLoopRevokedToken(t *testing.T) {
+func TestWatchTokenContextDone(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
 	mutex.Lock()
 	defer mutex.Unlock()
-	testCfg.tokenRevoked = true
-	tokenLookupErrorsCount.Reset()
-	client.renewalLoop()
-	metricTokenLookupErrorsCount, _ := tokenLookupErrorsCount.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName, errors.UnknownErrorType)
+	testCfg.tokenRenewable = true
+	testCfg.tokenRevoked = false
+	testCfg.tokenTTL = 600
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenLookupErrorsCount))
+	assert.False(t, client.watchToken(ctx))
 }
 
-func TestRenewalLoopNotRenewableToken(t *testing.T) {
-	client, _ := vaultClient(nil, vaultCfg)
+func TestReadLeasedSecret(t *testing.T) {
 	mutex.Lock()
 	defer mutex.Unlock()
-	testCfg.tokenRenewable = false
-	testCfg.tokenRevoked = false
-	testCfg.tokenTTL = 600
-	client.maxTokenTTL = 6000
+	testCfg.leasedSecretReads = 0
 
-	tokenRenewErrorsCount.Reset()
-	client.renewalLoop()
-	metricTokenRenewErrorsCount, _ := tokenRenewErrorsCount.GetMetricWithLabelValues(vaultCfg.VaultURL, vaultCfg.VaultEngine, vaultFakeVersion, vaultFakeClusterID, vaultFakeClusterName, errors.VaultTokenNotRenewableErrorType)
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv1"
+	client, _ := vaultClient(nil, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.ctx = ctx
 
-	assert.Equal(t, 1.0, testutil.ToFloat64(metricTokenRenewErrorsCount))
+	secretValue, err := client.ReadSecret("/database/creds/role", "username")
+	assert.Nil(t, err)
+	assert.Equal(t, "v-role-fake-user", secretValue)
+
+	// Leased secrets are kept alive instead of read again, as every read could generate new credentials
+	secretValue, err = client.ReadSecret("/database/creds/role", "username")
+	assert.Nil(t, err)
+	assert.Equal(t, "v-role-fake-user", secretValue)
+	assert.Equal(t, 1, testCfg.leasedSecretReads)
 }
 
 func TestReadSecretKv2(t *testing.T) {
@@ -346,6 +604,15 @@ func TestReadSecretKv2(t *testing.T) {
 	assert.Equal(t, "bar", secretValue)
 }
 
+func TestReadSecretKv2NonString(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	secretValue, err := client.ReadSecret("/secret/data/test", "settings")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"debug":true}`, secretValue)
+}
+
 func TestReadSecretKv1(t *testing.T) {
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -356,6 +623,78 @@ func TestReadSecretKv1(t *testing.T) {
 	assert.Equal(t, "bar", secretValue)
 }
 
+func TestReadSecretMetadata(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	metadata, err := client.ReadSecretMetadata("/secret/data/test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, metadata.Version)
+	assert.Equal(t, map[string]string{"owner": "team-a"}, metadata.CustomMetadata)
+	assert.Equal(t, 2018, metadata.UpdatedTime.Year())
+}
+
+func TestReadSecretMetadataNotFound(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	metadata, err := client.ReadSecretMetadata("/secret/data/not-found")
+
+	assert.Nil(t, metadata)
+	assert.NotNil(t, err)
+}
+
+func TestReadSecretMetadataKv1(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv1"
+	client, _ := vaultClient(nil, cfg)
+	metadata, err := client.ReadSecretMetadata("/secret/test")
+
+	assert.Nil(t, metadata)
+	assert.True(t, errors.IsVaultSecretMetadataNotSupported(err))
+}
+
+func TestReadSecretData(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	data, err := client.ReadSecretData("/secret/data/test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar", "settings": `{"debug":true}`}, data)
+}
+
+func TestReadSecretDataNotFound(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	data, err := client.ReadSecretData("/secret/data/not-found")
+
+	assert.Nil(t, data)
+	assert.NotNil(t, err)
+}
+
+func TestListSecrets(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	paths, err := client.ListSecrets("secret/data/team/")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"secret/data/team/db", "secret/data/team/nested/api"}, paths)
+}
+
+func TestListSecretsEmpty(t *testing.T) {
+	cfg := vaultCfg
+	cfg.VaultEngine = "kv2"
+	client, _ := vaultClient(nil, cfg)
+	paths, err := client.ListSecrets("secret/data/nothing")
+
+	assert.Nil(t, err)
+	assert.Empty(t, paths)
+}
+
 func TestSecretNotFound(t *testing.T) {
 	client, _ := vaultClient(nil, vaultCfg)
 	path := "/secret/data/test"
@@ -368,32 +707,165 @@ func TestSecretNotFound(t *testing.T) {
 	assert.EqualError(t, err, fmt.Sprintf("[%s] secret key %s not found at %s", errors.BackendSecretNotFoundErrorType, key, path))
 	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretReadErrorsCount))
 }
+func TestVaultClientWrappedToken(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = false
+	testCfg.wrappedTokenPath = fakeWrappedTokenPath
+
+	cfg := vaultCfg
+	cfg.VaultToken = ""
+	cfg.VaultWrappedToken = fakeWrappedToken
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, fakeToken, client.vclient.Token())
+	assert.True(t, testCfg.wrappedTokenUsed)
+}
+
+func TestVaultClientWrappedTokenFile(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = false
+	testCfg.wrappedTokenPath = fakeWrappedTokenPath
+
+	tokenFile, err := ioutil.TempFile("", "wrapped-token")
+	assert.Nil(t, err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString(fakeWrappedToken + "\n")
+	tokenFile.Close()
+
+	cfg := vaultCfg
+	cfg.VaultToken = ""
+	cfg.VaultWrappedTokenFile = tokenFile.Name()
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, fakeToken, client.vclient.Token())
+}
+
+func TestVaultClientWrappedTokenAlreadyUsed(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = true
+	testCfg.wrappedTokenPath = fakeWrappedTokenPath
+
+	cfg := vaultCfg
+	cfg.VaultWrappedToken = fakeWrappedToken
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, client)
+	assert.True(t, errors.IsVaultWrappedTokenInvalid(err))
+}
+
+func TestVaultClientWrappedTokenUnexpectedPath(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = false
+	testCfg.wrappedTokenPath = "auth/token/create/some-other-role"
+
+	cfg := vaultCfg
+	cfg.VaultWrappedToken = fakeWrappedToken
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	client, err := vaultClient(nil, cfg)
+
+	assert.Nil(t, client)
+	assert.EqualError(t, err, fmt.Sprintf("[%s] vault wrapped token was created at '%s', expected '%s'", errors.VaultWrappedTokenPathErrorType, testCfg.wrappedTokenPath, fakeWrappedTokenPath))
+	// A token created at an unexpected path must never be unwrapped
+	assert.False(t, testCfg.wrappedTokenUsed)
+}
+
+func TestCloseRevokesUnwrappedToken(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = false
+	testCfg.wrappedTokenPath = fakeWrappedTokenPath
+	testCfg.tokenRevoked = false
+	defer func() { testCfg.tokenRevoked = false }()
+
+	cfg := vaultCfg
+	cfg.VaultWrappedToken = fakeWrappedToken
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	cfg.VaultRevokeOnShutdown = true
+	client, _ := vaultClient(nil, cfg)
+	err := client.Close()
+
+	assert.Nil(t, err)
+	assert.True(t, testCfg.tokenRevoked)
+}
+
+func TestCloseDoesNotRevokeGivenToken(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.tokenRevoked = false
+
+	cfg := vaultCfg
+	cfg.VaultRevokeOnShutdown = true
+	client, _ := vaultClient(nil, cfg)
+	err := client.Close()
+
+	assert.Nil(t, err)
+	assert.False(t, testCfg.tokenRevoked)
+}
+
+func TestCloseWithoutRevokeOnShutdown(t *testing.T) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	testCfg.wrappedTokenUsed = false
+	testCfg.wrappedTokenPath = fakeWrappedTokenPath
+	testCfg.tokenRevoked = false
+
+	cfg := vaultCfg
+	cfg.VaultWrappedToken = fakeWrappedToken
+	cfg.VaultWrappedTokenPath = fakeWrappedTokenPath
+	client, _ := vaultClient(nil, cfg)
+	err := client.Close()
+
+	assert.Nil(t, err)
+	assert.False(t, testCfg.tokenRevoked)
+}
+
 func TestMain(m *testing.M) {
 	r := mux.NewRouter()
 	v1SysHandler := r.PathPrefix(fmt.Sprintf("/%s/sys", vaultAPIVersion)).Subrouter()
 	v1AuthHandler := r.PathPrefix(fmt.Sprintf("/%s/auth", vaultAPIVersion)).Subrouter()
 	v1SecretHandler := r.PathPrefix(fmt.Sprintf("/%s/secret", vaultAPIVersion)).Subrouter()
+	v1DatabaseHandler := r.PathPrefix(fmt.Sprintf("/%s/database", vaultAPIVersion)).Subrouter()
 
 	v1SysHandler.HandleFunc("/health", v1SysHealth).Methods("GET")
+	v1SysHandler.HandleFunc("/wrapping/lookup", v1SysWrappingLookup).Methods("PUT")
+	v1SysHandler.HandleFunc("/wrapping/unwrap", v1SysWrappingUnwrap).Methods("PUT")
 	v1AuthHandler.HandleFunc("/token/lookup-self", v1AuthTokenLookupSelf).Methods("GET")
 	v1AuthHandler.HandleFunc("/token/renew-self", v1AuthTokenRenewSelf).Methods("PUT")
+	v1AuthHandler.HandleFunc("/token/revoke-self", v1AuthTokenRevokeSelf).Methods("PUT")
+	v1AuthHandler.HandleFunc("/kubernetes/login", v1AuthKubernetesLogin).Methods("PUT")
+	v1SecretHandler.HandleFunc("/metadata/team", v1SecretMetadataListTeam).Methods("GET").Queries("list", "true")
+	v1SecretHandler.HandleFunc("/metadata/team/nested", v1SecretMetadataListTeamNested).Methods("GET").Queries("list", "true")
+	v1SecretHandler.HandleFunc("/metadata/nothing", v1SecretMetadataListNotFound).Methods("GET").Queries("list", "true")
 	v1SecretHandler.HandleFunc("/data/test", v1SecretTestKv2).Methods("GET")
+	v1SecretHandler.HandleFunc("/metadata/test", v1SecretMetadataTestKv2).Methods("GET")
 	v1SecretHandler.HandleFunc("/test", v1SecretTestKv1).Methods("GET")
+	v1DatabaseHandler.HandleFunc("/creds/role", v1DatabaseCreds).Methods("GET")
 
 	server = httptest.NewServer(r)
 	defer server.Close()
 
 	vaultCfg = Config{
-		VaultURL:                string(server.URL),
-		VaultToken:              fakeToken,
-		VaultTokenPollingPeriod: 1,
-		VaultEngine:             "kv2",
+		VaultURL:    string(server.URL),
+		VaultToken:  fakeToken,
+		VaultEngine: "kv2",
 	}
 
 	testCfg = &testConfig{
-		tokenRenewable: defaultTokenRenewable,
-		tokenTTL:       defaultTokenTTL,
-		tokenRevoked:   defaultRevokedToken,
+		tokenRenewable:   defaultTokenRenewable,
+		tokenTTL:         defaultTokenTTL,
+		tokenRevoked:     defaultRevokedToken,
+		wrappedTokenPath: fakeWrappedTokenPath,
 	}
 
 	os.Exit(m.Run())
@@ -4,14 +4,35 @@ import "fmt"
 
 // Error Types constants
 const (
-	UnknownErrorType                   = "UnknownError"
-	BackendNotImplementedErrorType     = "BackendNotImplementedError"
-	BackendSecretNotFoundErrorType     = "BackendSecretNotFoundError"
-	K8sSecretNotFoundErrorType         = "K8sSecretNotFoundError"
-	InvalidConfigmapNameErrorType      = "InvalidConfigmapNameError"
-	EncodingNotImplementedErrorType    = "EncodingNotImplementedError"
-	VaultEngineNotImplementedErrorType = "VaultEngineNotImplementedError"
-	VaultTokenNotRenewableErrorType    = "VaultTokenNotRenewableError"
+	UnknownErrorType                         = "UnknownError"
+	BackendNotImplementedErrorType           = "BackendNotImplementedError"
+	BackendSecretNotFoundErrorType           = "BackendSecretNotFoundError"
+	K8sSecretNotFoundErrorType               = "K8sSecretNotFoundError"
+	InvalidConfigmapNameErrorType            = "InvalidConfigmapNameError"
+	EncodingNotImplementedErrorType          = "EncodingNotImplementedError"
+	VaultEngineNotImplementedErrorType       = "VaultEngineNotImplementedError"
+	VaultTokenNotRenewableErrorType          = "VaultTokenNotRenewableError"
+	VaultWrappedTokenInvalidErrorType        = "VaultWrappedTokenInvalidError"
+	VaultWrappedTokenPathErrorType           = "VaultWrappedTokenPathError"
+	VaultAuthMethodNotImplementedErrorType   = "VaultAuthMethodNotImplementedError"
+	VaultNotReadyErrorType                   = "VaultNotReadyError"
+	VaultSecretMetadataNotSupportedErrorType = "VaultSecretMetadataNotSupportedError"
+	K8sSecretNotOwnedErrorType               = "K8sSecretNotOwnedError"
+	DecodingErrorType                        = "DecodingError"
+	InvalidTemplateErrorType                 = "InvalidTemplateError"
+	TemplateRenderErrorType                  = "TemplateRenderError"
+	InvalidDockerConfigErrorType             = "InvalidDockerConfigError"
+	KeystoreErrorType                        = "KeystoreError"
+	InvalidTLSSecretErrorType                = "InvalidTLSSecretError"
+	JSONPathErrorType                        = "JSONPathError"
+	InvalidSecretDefinitionErrorType         = "InvalidSecretDefinitionError"
+	InvalidConfigSourceErrorType             = "InvalidConfigSourceError"
+	InvalidPrunePolicyErrorType              = "InvalidPrunePolicyError"
+	InvalidSecretMetadataErrorType           = "InvalidSecretMetadataError"
+	K8sConfigMapNotFoundErrorType            = "K8sConfigMapNotFoundError"
+	K8sConfigMapNotOwnedErrorType            = "K8sConfigMapNotOwnedError"
+	InvalidChecksumKeyErrorType              = "InvalidChecksumKeyError"
+	InvalidNamespacePathPrefixesErrorType    = "InvalidNamespacePathPrefixesError"
 )
 
 // BackendNotImplementedError will be raised if the selected backend is not implemented
@@ -57,6 +78,153 @@ type VaultTokenNotRenewableError struct {
 	ErrType string
 }
 
+// VaultWrappedTokenInvalidError will be raised if the response-wrapping token is expired, unknown or was already used
+type VaultWrappedTokenInvalidError struct {
+	ErrType string
+	Reason  string
+}
+
+// VaultWrappedTokenPathError will be raised if the response-wrapping token was not created at the expected path
+type VaultWrappedTokenPathError struct {
+	ErrType      string
+	ExpectedPath string
+	CreationPath string
+}
+
+// VaultAuthMethodNotImplementedError will be raised if the selected Vault auth method is not implemented
+type VaultAuthMethodNotImplementedError struct {
+	ErrType    string
+	AuthMethod string
+}
+
+// VaultNotReadyError will be raised if Vault is not initialized, sealed or in standby
+type VaultNotReadyError struct {
+	ErrType string
+	Reason  string
+}
+
+// VaultSecretMetadataNotSupportedError will be raised if the selected engine keeps no metadata for the given secret path
+type VaultSecretMetadataNotSupportedError struct {
+	ErrType string
+	Engine  string
+	Path    string
+}
+
+// K8sSecretNotOwnedError will be raised if a secret exists but it was not created by secrets-manager
+type K8sSecretNotOwnedError struct {
+	ErrType   string
+	Name      string
+	Namespace string
+}
+
+// DecodingError will be raised if a value can not be decoded with its encoding
+type DecodingError struct {
+	ErrType  string
+	Encoding string
+	Reason   string
+}
+
+// InvalidTemplateError will be raised if the template of a secret definition data entry can not be parsed
+type InvalidTemplateError struct {
+	ErrType string
+	Name    string
+	Key     string
+	Reason  string
+}
+
+// TemplateRenderError will be raised if the template of a secret definition data entry fails to render
+type TemplateRenderError struct {
+	ErrType string
+	Name    string
+	Key     string
+	Reason  string
+}
+
+// InvalidDockerConfigError will be raised if a docker config secret is not in the format expected by the kubelet
+type InvalidDockerConfigError struct {
+	ErrType string
+	Name    string
+	Reason  string
+}
+
+// KeystoreError will be raised if a keystore can not be built or extracted for a secret definition data entry
+type KeystoreError struct {
+	ErrType string
+	Name    string
+	Key     string
+	Reason  string
+}
+
+// InvalidTLSSecretError will be raised if the certificate or the private key of a kubernetes.io/tls secret are not valid
+type InvalidTLSSecretError struct {
+	ErrType string
+	Name    string
+	Reason  string
+}
+
+// JSONPathError will be raised if the JSONPath selector of a datasource is invalid or selects no single value
+type JSONPathError struct {
+	ErrType  string
+	Path     string
+	Key      string
+	JSONPath string
+	Reason   string
+}
+
+// InvalidSecretDefinitionError will be raised if a SecretDefinition custom resource can not be used
+type InvalidSecretDefinitionError struct {
+	ErrType string
+	Name    string
+	Reason  string
+}
+
+// InvalidConfigSourceError will be raised if the source of the secret definitions is not supported
+type InvalidConfigSourceError struct {
+	ErrType string
+	Value   string
+}
+
+// InvalidPrunePolicyError will be raised if the prune policy is not one of none, report, orphan or delete
+type InvalidPrunePolicyError struct {
+	ErrType string
+	Value   string
+}
+
+// InvalidSecretMetadataError is returned when a label or annotation of a secret definition is not valid
+type InvalidSecretMetadataError struct {
+	ErrType string
+	Name    string
+	Key     string
+	Reason  string
+}
+
+// K8sConfigMapNotFoundError will be raised if a configmap is not found by its name in the given namespace
+type K8sConfigMapNotFoundError struct {
+	ErrType   string
+	Name      string
+	Namespace string
+}
+
+// K8sConfigMapNotOwnedError will be raised if a configmap exists but it was not created by secrets-manager
+type K8sConfigMapNotOwnedError struct {
+	ErrType   string
+	Name      string
+	Namespace string
+}
+
+// InvalidChecksumKeyError will be raised if the secret holding the key of the checksums of secrets has no key
+type InvalidChecksumKeyError struct {
+	ErrType   string
+	Name      string
+	Namespace string
+}
+
+// InvalidNamespacePathPrefixesError will be raised if the backend path prefixes of namespaces are not in the <namespace>=<prefix>[,<prefix>...][;...] format
+type InvalidNamespacePathPrefixesError struct {
+	ErrType string
+	Value   string
+}
+
 func getErrorType(err error) string {
 	switch err.(type) {
 	case *BackendNotImplementedError:
@@ -73,6 +241,48 @@ func getErrorType(err error) string {
 		return VaultEngineNotImplementedErrorType
 	case *VaultTokenNotRenewableError:
 		return VaultTokenNotRenewableErrorType
+	case *VaultWrappedTokenInvalidError:
+		return VaultWrappedTokenInvalidErrorType
+	case *VaultWrappedTokenPathError:
+		return VaultWrappedTokenPathErrorType
+	case *VaultAuthMethodNotImplementedError:
+		return VaultAuthMethodNotImplementedErrorType
+	case *VaultNotReadyError:
+		return VaultNotReadyErrorType
+	case *VaultSecretMetadataNotSupportedError:
+		return VaultSecretMetadataNotSupportedErrorType
+	case *K8sSecretNotOwnedError:
+		return K8sSecretNotOwnedErrorType
+	case *DecodingError:
+		return DecodingErrorType
+	case *InvalidTemplateError:
+		return InvalidTemplateErrorType
+	case *TemplateRenderError:
+		return TemplateRenderErrorType
+	case *InvalidDockerConfigError:
+		return InvalidDockerConfigErrorType
+	case *KeystoreError:
+		return KeystoreErrorType
+	case *InvalidTLSSecretError:
+		return InvalidTLSSecretErrorType
+	case *JSONPathError:
+		return JSONPathErrorType
+	case *InvalidSecretDefinitionError:
+		return InvalidSecretDefinitionErrorType
+	case *InvalidConfigSourceError:
+		return InvalidConfigSourceErrorType
+	case *InvalidPrunePolicyError:
+		return InvalidPrunePolicyErrorType
+	case *InvalidSecretMetadataError:
+		return InvalidSecretMetadataErrorType
+	case *K8sConfigMapNotFoundError:
+		return K8sConfigMapNotFoundErrorType
+	case *K8sConfigMapNotOwnedError:
+		return K8sConfigMapNotOwnedErrorType
+	case *InvalidChecksumKeyError:
+		return InvalidChecksumKeyErrorType
+	case *InvalidNamespacePathPrefixesError:
+		return InvalidNamespacePathPrefixesErrorType
 	default:
 		return UnknownErrorType
 	}
@@ -106,6 +316,90 @@ func (e VaultTokenNotRenewableError) Error() string {
 	return fmt.Sprintf("[%s] vault token not renewable", e.ErrType)
 }
 
+func (e VaultWrappedTokenInvalidError) Error() string {
+	return fmt.Sprintf("[%s] vault wrapped token is invalid, expired or already used: %s", e.ErrType, e.Reason)
+}
+
+func (e VaultWrappedTokenPathError) Error() string {
+	return fmt.Sprintf("[%s] vault wrapped token was created at '%s', expected '%s'", e.ErrType, e.CreationPath, e.ExpectedPath)
+}
+
+func (e VaultAuthMethodNotImplementedError) Error() string {
+	return fmt.Sprintf("[%s] vault auth method %s not supported", e.ErrType, e.AuthMethod)
+}
+
+func (e VaultNotReadyError) Error() string {
+	return fmt.Sprintf("[%s] vault is not ready: %s", e.ErrType, e.Reason)
+}
+
+func (e VaultSecretMetadataNotSupportedError) Error() string {
+	return fmt.Sprintf("[%s] vault engine %s keeps no metadata for %s", e.ErrType, e.Engine, e.Path)
+}
+
+func (e K8sSecretNotOwnedError) Error() string {
+	return fmt.Sprintf("[%s] secret '%s/%s' is not managed by secrets-manager", e.ErrType, e.Namespace, e.Name)
+}
+
+func (e DecodingError) Error() string {
+	return fmt.Sprintf("[%s] unable to decode %s data: %s", e.ErrType, e.Encoding, e.Reason)
+}
+
+func (e InvalidTemplateError) Error() string {
+	return fmt.Sprintf("[%s] invalid template for key %s of secret %s: %s", e.ErrType, e.Key, e.Name, e.Reason)
+}
+
+func (e TemplateRenderError) Error() string {
+	return fmt.Sprintf("[%s] unable to render template for key %s of secret %s: %s", e.ErrType, e.Key, e.Name, e.Reason)
+}
+
+func (e InvalidDockerConfigError) Error() string {
+	return fmt.Sprintf("[%s] invalid docker config for secret %s: %s", e.ErrType, e.Name, e.Reason)
+}
+
+func (e KeystoreError) Error() string {
+	return fmt.Sprintf("[%s] unable to convert keystore for key %s of secret %s: %s", e.ErrType, e.Key, e.Name, e.Reason)
+}
+
+func (e InvalidTLSSecretError) Error() string {
+	return fmt.Sprintf("[%s] invalid TLS secret %s: %s", e.ErrType, e.Name, e.Reason)
+}
+
+func (e JSONPathError) Error() string {
+	return fmt.Sprintf("[%s] unable to select %s from %s/%s: %s", e.ErrType, e.JSONPath, e.Path, e.Key, e.Reason)
+}
+
+func (e InvalidSecretDefinitionError) Error() string {
+	return fmt.Sprintf("[%s] invalid secret definition %s: %s", e.ErrType, e.Name, e.Reason)
+}
+
+func (e InvalidConfigSourceError) Error() string {
+	return fmt.Sprintf("[%s] secret definitions source %s not supported", e.ErrType, e.Value)
+}
+
+func (e InvalidPrunePolicyError) Error() string {
+	return fmt.Sprintf("[%s] prune policy %s not supported", e.ErrType, e.Value)
+}
+
+func (e InvalidSecretMetadataError) Error() string {
+	return fmt.Sprintf("[%s] invalid label or annotation %s of secret %s: %s", e.ErrType, e.Key, e.Name, e.Reason)
+}
+
+func (e K8sConfigMapNotFoundError) Error() string {
+	return fmt.Sprintf("[%s] configmap '%s/%s' not found", e.ErrType, e.Namespace, e.Name)
+}
+
+func (e K8sConfigMapNotOwnedError) Error() string {
+	return fmt.Sprintf("[%s] configmap '%s/%s' is not managed by secrets-manager", e.ErrType, e.Namespace, e.Name)
+}
+
+func (e InvalidChecksumKeyError) Error() string {
+	return fmt.Sprintf("[%s] secret %s/%s holding the checksum key has no key", e.ErrType, e.Namespace, e.Name)
+}
+
+func (e InvalidNamespacePathPrefixesError) Error() string {
+	return fmt.Sprintf("[%s] invalid namespace path prefixes %s, expected <namespace>=<prefix>[,<prefix>...][;...]", e.ErrType, e.Value)
+}
+
 // IsBackendNotImplemented returns true if the error is type of BackendNotImplementedError and false otherwise
 func IsBackendNotImplemented(err error) bool {
 	return getErrorType(err) == BackendNotImplementedErrorType
@@ -140,3 +434,108 @@ func IsVaultEngineNotImplemented(err error) bool {
 func IsVaultTokenNotRenewable(err error) bool {
 	return getErrorType(err) == VaultTokenNotRenewableErrorType
 }
+
+// IsVaultWrappedTokenInvalid returns true if the error is type of VaultWrappedTokenInvalidError and false otherwise
+func IsVaultWrappedTokenInvalid(err error) bool {
+	return getErrorType(err) == VaultWrappedTokenInvalidErrorType
+}
+
+// IsVaultWrappedTokenPath returns true if the error is type of VaultWrappedTokenPathError and false otherwise
+func IsVaultWrappedTokenPath(err error) bool {
+	return getErrorType(err) == VaultWrappedTokenPathErrorType
+}
+
+// IsVaultAuthMethodNotImplemented returns true if the error is type of VaultAuthMethodNotImplementedError and false otherwise
+func IsVaultAuthMethodNotImplemented(err error) bool {
+	return getErrorType(err) == VaultAuthMethodNotImplementedErrorType
+}
+
+// IsVaultNotReady returns true if the error is type of VaultNotReadyError and false otherwise
+func IsVaultNotReady(err error) bool {
+	return getErrorType(err) == VaultNotReadyErrorType
+}
+
+// IsVaultSecretMetadataNotSupported returns true if the error is type of VaultSecretMetadataNotSupportedError and false otherwise
+func IsVaultSecretMetadataNotSupported(err error) bool {
+	return getErrorType(err) == VaultSecretMetadataNotSupportedErrorType
+}
+
+// IsK8sSecretNotOwned returns true if the error is type of K8sSecretNotOwnedError and false otherwise
+func IsK8sSecretNotOwned(err error) bool {
+	return getErrorType(err) == K8sSecretNotOwnedErrorType
+}
+
+// IsDecoding returns true if the error is type of DecodingError and false otherwise
+func IsDecoding(err error) bool {
+	return getErrorType(err) == DecodingErrorType
+}
+
+// IsInvalidTemplate returns true if the error is type of InvalidTemplateError and false otherwise
+func IsInvalidTemplate(err error) bool {
+	return getErrorType(err) == InvalidTemplateErrorType
+}
+
+// IsTemplateRender returns true if the error is type of TemplateRenderError and false otherwise
+func IsTemplateRender(err error) bool {
+	return getErrorType(err) == TemplateRenderErrorType
+}
+
+// IsInvalidDockerConfig returns true if the error is type of InvalidDockerConfigError and false otherwise
+func IsInvalidDockerConfig(err error) bool {
+	return getErrorType(err) == InvalidDockerConfigErrorType
+}
+
+// IsKeystore returns true if the error is type of KeystoreError and false otherwise
+func IsKeystore(err error) bool {
+	return getErrorType(err) == KeystoreErrorType
+}
+
+// IsInvalidTLSSecret returns true if the error is type of InvalidTLSSecretError and false otherwise
+func IsInvalidTLSSecret(err error) bool {
+	return getErrorType(err) == InvalidTLSSecretErrorType
+}
+
+// IsJSONPath returns true if the error is type of JSONPathError and false otherwise
+func IsJSONPath(err error) bool {
+	return getErrorType(err) == JSONPathErrorType
+}
+
+// IsInvalidSecretDefinition returns true if the error is type of InvalidSecretDefinitionError and false otherwise
+func IsInvalidSecretDefinition(err error) bool {
+	return getErrorType(err) == InvalidSecretDefinitionErrorType
+}
+
+// IsInvalidConfigSource returns true if the error is type of InvalidConfigSourceError and false otherwise
+func IsInvalidConfigSource(err error) bool {
+	return getErrorType(err) == InvalidConfigSourceErrorType
+}
+
+// IsInvalidPrunePolicy returns true if the error is type of InvalidPrunePolicyError and false otherwise
+func IsInvalidPrunePolicy(err error) bool {
+	return getErrorType(err) == InvalidPrunePolicyErrorType
+}
+
+// IsInvalidSecretMetadata returns true if the error is type of InvalidSecretMetadataError and false otherwise
+func IsInvalidSecretMetadata(err error) bool {
+	return getErrorType(err) == InvalidSecretMetadataErrorType
+}
+
+// IsK8sConfigMapNotFound returns true if the error is type of K8sConfigMapNotFoundError and false otherwise
+func IsK8sConfigMapNotFound(err error) bool {
+	return getErrorType(err) == K8sConfigMapNotFoundErrorType
+}
+
+// IsK8sConfigMapNotOwned returns true if the error is type of K8sConfigMapNotOwnedError and false otherwise
+func IsK8sConfigMapNotOwned(err error) bool {
+	return getErrorType(err) == K8sConfigMapNotOwnedErrorType
+}
+
+// IsInvalidChecksumKey returns true if the error is type of InvalidChecksumKeyError and false otherwise
+func IsInvalidChecksumKey(err error) bool {
+	return getErrorType(err) == InvalidChecksumKeyErrorType
+}
+
+// IsInvalidNamespacePathPrefixes returns true if the error is type of InvalidNamespacePathPrefixesError and false otherwise
+func IsInvalidNamespacePathPrefixes(err error) bool {
+	return getErrorType(err) == InvalidNamespacePathPrefixesErrorType
+}
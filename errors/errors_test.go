@@ -23,6 +23,48 @@ func TestErrorString(t *testing.T) {
 	assert.EqualError(t, err6, fmt.Sprintf("[%s] vault engine %s not supported", err6.ErrType, err6.Engine))
 	err7 := &VaultTokenNotRenewableError{ErrType: VaultTokenNotRenewableErrorType}
 	assert.EqualError(t, err7, fmt.Sprintf("[%s] vault token not renewable", err7.ErrType))
+	err8 := &VaultWrappedTokenInvalidError{ErrType: VaultWrappedTokenInvalidErrorType, Reason: "foo"}
+	assert.EqualError(t, err8, fmt.Sprintf("[%s] vault wrapped token is invalid, expired or already used: %s", err8.ErrType, err8.Reason))
+	err9 := &VaultWrappedTokenPathError{ErrType: VaultWrappedTokenPathErrorType, ExpectedPath: "foo", CreationPath: "bar"}
+	assert.EqualError(t, err9, fmt.Sprintf("[%s] vault wrapped token was created at '%s', expected '%s'", err9.ErrType, err9.CreationPath, err9.ExpectedPath))
+	err10 := &VaultAuthMethodNotImplementedError{ErrType: VaultAuthMethodNotImplementedErrorType, AuthMethod: "foo"}
+	assert.EqualError(t, err10, fmt.Sprintf("[%s] vault auth method %s not supported", err10.ErrType, err10.AuthMethod))
+	err11 := &VaultNotReadyError{ErrType: VaultNotReadyErrorType, Reason: "sealed"}
+	assert.EqualError(t, err11, fmt.Sprintf("[%s] vault is not ready: %s", err11.ErrType, err11.Reason))
+	err12 := &VaultSecretMetadataNotSupportedError{ErrType: VaultSecretMetadataNotSupportedErrorType, Engine: "kv1", Path: "foo"}
+	assert.EqualError(t, err12, fmt.Sprintf("[%s] vault engine %s keeps no metadata for %s", err12.ErrType, err12.Engine, err12.Path))
+	err13 := &K8sSecretNotOwnedError{ErrType: K8sSecretNotOwnedErrorType, Name: "foo", Namespace: "bar"}
+	assert.EqualError(t, err13, fmt.Sprintf("[%s] secret '%s/%s' is not managed by secrets-manager", err13.ErrType, err13.Namespace, err13.Name))
+	err14 := &DecodingError{ErrType: DecodingErrorType, Encoding: "foo", Reason: "bar"}
+	assert.EqualError(t, err14, fmt.Sprintf("[%s] unable to decode %s data: %s", err14.ErrType, err14.Encoding, err14.Reason))
+	err15 := &InvalidTemplateError{ErrType: InvalidTemplateErrorType, Name: "foo", Key: "bar", Reason: "baz"}
+	assert.EqualError(t, err15, fmt.Sprintf("[%s] invalid template for key %s of secret %s: %s", err15.ErrType, err15.Key, err15.Name, err15.Reason))
+	err16 := &TemplateRenderError{ErrType: TemplateRenderErrorType, Name: "foo", Key: "bar", Reason: "baz"}
+	assert.EqualError(t, err16, fmt.Sprintf("[%s] unable to render template for key %s of secret %s: %s", err16.ErrType, err16.Key, err16.Name, err16.Reason))
+	err17 := &InvalidDockerConfigError{ErrType: InvalidDockerConfigErrorType, Name: "foo", Reason: "bar"}
+	assert.EqualError(t, err17, fmt.Sprintf("[%s] invalid docker config for secret %s: %s", err17.ErrType, err17.Name, err17.Reason))
+	err18 := &KeystoreError{ErrType: KeystoreErrorType, Name: "foo", Key: "keystore.p12", Reason: "bar"}
+	assert.EqualError(t, err18, fmt.Sprintf("[%s] unable to convert keystore for key %s of secret %s: %s", err18.ErrType, err18.Key, err18.Name, err18.Reason))
+	err19 := &InvalidTLSSecretError{ErrType: InvalidTLSSecretErrorType, Name: "foo", Reason: "bar"}
+	assert.EqualError(t, err19, fmt.Sprintf("[%s] invalid TLS secret %s: %s", err19.ErrType, err19.Name, err19.Reason))
+	err20 := &JSONPathError{ErrType: JSONPathErrorType, Path: "secret/data/gcp", Key: "sa.json", JSONPath: ".client_email", Reason: "bar"}
+	assert.EqualError(t, err20, fmt.Sprintf("[%s] unable to select %s from %s/%s: %s", err20.ErrType, err20.JSONPath, err20.Path, err20.Key, err20.Reason))
+	err21 := &InvalidSecretDefinitionError{ErrType: InvalidSecretDefinitionErrorType, Name: "ns/foo", Reason: "bar"}
+	assert.EqualError(t, err21, fmt.Sprintf("[%s] invalid secret definition %s: %s", err21.ErrType, err21.Name, err21.Reason))
+	err22 := &InvalidConfigSourceError{ErrType: InvalidConfigSourceErrorType, Value: "foo"}
+	assert.EqualError(t, err22, fmt.Sprintf("[%s] secret definitions source %s not supported", err22.ErrType, err22.Value))
+	err23 := &InvalidPrunePolicyError{ErrType: InvalidPrunePolicyErrorType, Value: "foo"}
+	assert.EqualError(t, err23, fmt.Sprintf("[%s] prune policy %s not supported", err23.ErrType, err23.Value))
+	err24 := &InvalidSecretMetadataError{ErrType: InvalidSecretMetadataErrorType, Name: "foo", Key: "team", Reason: "bar"}
+	assert.EqualError(t, err24, fmt.Sprintf("[%s] invalid label or annotation %s of secret %s: %s", err24.ErrType, err24.Key, err24.Name, err24.Reason))
+	err25 := &K8sConfigMapNotFoundError{ErrType: K8sConfigMapNotFoundErrorType, Name: "foo", Namespace: "bar"}
+	assert.EqualError(t, err25, fmt.Sprintf("[%s] configmap '%s/%s' not found", err25.ErrType, err25.Namespace, err25.Name))
+	err26 := &K8sConfigMapNotOwnedError{ErrType: K8sConfigMapNotOwnedErrorType, Name: "foo", Namespace: "bar"}
+	assert.EqualError(t, err26, fmt.Sprintf("[%s] configmap '%s/%s' is not managed by secrets-manager", err26.ErrType, err26.Namespace, err26.Name))
+	err27 := &InvalidChecksumKeyError{ErrType: InvalidChecksumKeyErrorType, Name: "foo", Namespace: "bar"}
+	assert.EqualError(t, err27, fmt.Sprintf("[%s] secret %s/%s holding the checksum key has no key", err27.ErrType, err27.Namespace, err27.Name))
+	err28 := &InvalidNamespacePathPrefixesError{ErrType: InvalidNamespacePathPrefixesErrorType, Value: "foo"}
+	assert.EqualError(t, err28, fmt.Sprintf("[%s] invalid namespace path prefixes %s, expected <namespace>=<prefix>[,<prefix>...][;...]", err28.ErrType, err28.Value))
 }
 
 func TestGetErrorType(t *testing.T) {
@@ -42,6 +84,48 @@ func TestGetErrorType(t *testing.T) {
 	assert.Equal(t, getErrorType(err7), VaultEngineNotImplementedErrorType)
 	err8 := &VaultTokenNotRenewableError{ErrType: VaultTokenNotRenewableErrorType}
 	assert.Equal(t, getErrorType(err8), VaultTokenNotRenewableErrorType)
+	err9 := &VaultWrappedTokenInvalidError{ErrType: VaultWrappedTokenInvalidErrorType}
+	assert.Equal(t, getErrorType(err9), VaultWrappedTokenInvalidErrorType)
+	err10 := &VaultWrappedTokenPathError{ErrType: VaultWrappedTokenPathErrorType}
+	assert.Equal(t, getErrorType(err10), VaultWrappedTokenPathErrorType)
+	err11 := &VaultAuthMethodNotImplementedError{ErrType: VaultAuthMethodNotImplementedErrorType}
+	assert.Equal(t, getErrorType(err11), VaultAuthMethodNotImplementedErrorType)
+	err12 := &VaultNotReadyError{ErrType: VaultNotReadyErrorType}
+	assert.Equal(t, getErrorType(err12), VaultNotReadyErrorType)
+	err13 := &VaultSecretMetadataNotSupportedError{ErrType: VaultSecretMetadataNotSupportedErrorType}
+	assert.Equal(t, getErrorType(err13), VaultSecretMetadataNotSupportedErrorType)
+	err14 := &K8sSecretNotOwnedError{ErrType: K8sSecretNotOwnedErrorType}
+	assert.Equal(t, getErrorType(err14), K8sSecretNotOwnedErrorType)
+	err15 := &DecodingError{ErrType: DecodingErrorType}
+	assert.Equal(t, getErrorType(err15), DecodingErrorType)
+	err16 := &InvalidTemplateError{ErrType: InvalidTemplateErrorType}
+	assert.Equal(t, getErrorType(err16), InvalidTemplateErrorType)
+	err17 := &TemplateRenderError{ErrType: TemplateRenderErrorType}
+	assert.Equal(t, getErrorType(err17), TemplateRenderErrorType)
+	err18 := &InvalidDockerConfigError{ErrType: InvalidDockerConfigErrorType}
+	assert.Equal(t, getErrorType(err18), InvalidDockerConfigErrorType)
+	err19 := &KeystoreError{ErrType: KeystoreErrorType}
+	assert.Equal(t, getErrorType(err19), KeystoreErrorType)
+	err20 := &InvalidTLSSecretError{ErrType: InvalidTLSSecretErrorType}
+	assert.Equal(t, getErrorType(err20), InvalidTLSSecretErrorType)
+	err21 := &JSONPathError{ErrType: JSONPathErrorType}
+	assert.Equal(t, getErrorType(err21), JSONPathErrorType)
+	err22 := &InvalidSecretDefinitionError{ErrType: InvalidSecretDefinitionErrorType}
+	assert.Equal(t, getErrorType(err22), InvalidSecretDefinitionErrorType)
+	err23 := &InvalidConfigSourceError{ErrType: InvalidConfigSourceErrorType}
+	assert.Equal(t, getErrorType(err23), InvalidConfigSourceErrorType)
+	err24 := &InvalidPrunePolicyError{ErrType: InvalidPrunePolicyErrorType}
+	assert.Equal(t, getErrorType(err24), InvalidPrunePolicyErrorType)
+	err25 := &InvalidSecretMetadataError{ErrType: InvalidSecretMetadataErrorType}
+	assert.Equal(t, getErrorType(err25), InvalidSecretMetadataErrorType)
+	err26 := &K8sConfigMapNotFoundError{ErrType: K8sConfigMapNotFoundErrorType}
+	assert.Equal(t, getErrorType(err26), K8sConfigMapNotFoundErrorType)
+	err27 := &K8sConfigMapNotOwnedError{ErrType: K8sConfigMapNotOwnedErrorType}
+	assert.Equal(t, getErrorType(err27), K8sConfigMapNotOwnedErrorType)
+	err28 := &InvalidChecksumKeyError{ErrType: InvalidChecksumKeyErrorType}
+	assert.Equal(t, getErrorType(err28), InvalidChecksumKeyErrorType)
+	err29 := &InvalidNamespacePathPrefixesError{ErrType: InvalidNamespacePathPrefixesErrorType}
+	assert.Equal(t, getErrorType(err29), InvalidNamespacePathPrefixesErrorType)
 }
 
 func TestIsBackendNotImplemented(t *testing.T) {
@@ -88,3 +172,150 @@ func TestIsVaultTokenNotRenewable(t *testing.T) {
 	err := &VaultTokenNotRenewableError{ErrType: VaultTokenNotRenewableErrorType}
 	assert.True(t, IsVaultTokenNotRenewable(err))
 }
+
+func TestIsVaultWrappedTokenInvalid(t *testing.T) {
+	err := &VaultWrappedTokenInvalidError{ErrType: VaultWrappedTokenInvalidErrorType}
+	assert.True(t, IsVaultWrappedTokenInvalid(err))
+	err2 := e.New("foo")
+	assert.False(t, IsVaultWrappedTokenInvalid(err2))
+}
+
+func TestIsVaultWrappedTokenPath(t *testing.T) {
+	err := &VaultWrappedTokenPathError{ErrType: VaultWrappedTokenPathErrorType}
+	assert.True(t, IsVaultWrappedTokenPath(err))
+	err2 := e.New("foo")
+	assert.False(t, IsVaultWrappedTokenPath(err2))
+}
+
+func TestIsVaultAuthMethodNotImplemented(t *testing.T) {
+	err := &VaultAuthMethodNotImplementedError{ErrType: VaultAuthMethodNotImplementedErrorType}
+	assert.True(t, IsVaultAuthMethodNotImplemented(err))
+	err2 := e.New("foo")
+	assert.False(t, IsVaultAuthMethodNotImplemented(err2))
+}
+
+func TestIsVaultNotReady(t *testing.T) {
+	err := &VaultNotReadyError{ErrType: VaultNotReadyErrorType}
+	assert.True(t, IsVaultNotReady(err))
+	err2 := e.New("foo")
+	assert.False(t, IsVaultNotReady(err2))
+}
+
+func TestIsVaultSecretMetadataNotSupported(t *testing.T) {
+	err := &VaultSecretMetadataNotSupportedError{ErrType: VaultSecretMetadataNotSupportedErrorType}
+	assert.True(t, IsVaultSecretMetadataNotSupported(err))
+	err2 := e.New("foo")
+	assert.False(t, IsVaultSecretMetadataNotSupported(err2))
+}
+
+func TestIsK8sSecretNotOwned(t *testing.T) {
+	err := &K8sSecretNotOwnedError{ErrType: K8sSecretNotOwnedErrorType}
+	assert.True(t, IsK8sSecretNotOwned(err))
+	err2 := e.New("foo")
+	assert.False(t, IsK8sSecretNotOwned(err2))
+}
+
+func TestIsDecoding(t *testing.T) {
+	err := &DecodingError{ErrType: DecodingErrorType}
+	assert.True(t, IsDecoding(err))
+	err2 := e.New("foo")
+	assert.False(t, IsDecoding(err2))
+}
+
+func TestIsInvalidTemplate(t *testing.T) {
+	err := &InvalidTemplateError{ErrType: InvalidTemplateErrorType}
+	assert.True(t, IsInvalidTemplate(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidTemplate(err2))
+}
+
+func TestIsTemplateRender(t *testing.T) {
+	err := &TemplateRenderError{ErrType: TemplateRenderErrorType}
+	assert.True(t, IsTemplateRender(err))
+	err2 := e.New("foo")
+	assert.False(t, IsTemplateRender(err2))
+}
+
+func TestIsInvalidDockerConfig(t *testing.T) {
+	err := &InvalidDockerConfigError{ErrType: InvalidDockerConfigErrorType}
+	assert.True(t, IsInvalidDockerConfig(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidDockerConfig(err2))
+}
+
+func TestIsKeystore(t *testing.T) {
+	err := &KeystoreError{ErrType: KeystoreErrorType}
+	assert.True(t, IsKeystore(err))
+	err2 := e.New("foo")
+	assert.False(t, IsKeystore(err2))
+}
+
+func TestIsInvalidTLSSecret(t *testing.T) {
+	err := &InvalidTLSSecretError{ErrType: InvalidTLSSecretErrorType}
+	assert.True(t, IsInvalidTLSSecret(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidTLSSecret(err2))
+}
+
+func TestIsJSONPath(t *testing.T) {
+	err := &JSONPathError{ErrType: JSONPathErrorType}
+	assert.True(t, IsJSONPath(err))
+	err2 := e.New("foo")
+	assert.False(t, IsJSONPath(err2))
+}
+
+func TestIsInvalidSecretDefinition(t *testing.T) {
+	err := &InvalidSecretDefinitionError{ErrType: InvalidSecretDefinitionErrorType}
+	assert.True(t, IsInvalidSecretDefinition(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidSecretDefinition(err2))
+}
+
+func TestIsInvalidConfigSource(t *testing.T) {
+	err := &InvalidConfigSourceError{ErrType: InvalidConfigSourceErrorType}
+	assert.True(t, IsInvalidConfigSource(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidConfigSource(err2))
+}
+
+func TestIsInvalidPrunePolicy(t *testing.T) {
+	err := &InvalidPrunePolicyError{ErrType: InvalidPrunePolicyErrorType}
+	assert.True(t, IsInvalidPrunePolicy(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidPrunePolicy(err2))
+}
+
+func TestIsInvalidSecretMetadata(t *testing.T) {
+	err := &InvalidSecretMetadataError{ErrType: InvalidSecretMetadataErrorType}
+	assert.True(t, IsInvalidSecretMetadata(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidSecretMetadata(err2))
+}
+
+func TestIsK8sConfigMapNotFound(t *testing.T) {
+	err := &K8sConfigMapNotFoundError{ErrType: K8sConfigMapNotFoundErrorType}
+	assert.True(t, IsK8sConfigMapNotFound(err))
+	err2 := e.New("foo")
+	assert.False(t, IsK8sConfigMapNotFound(err2))
+}
+
+func TestIsK8sConfigMapNotOwned(t *testing.T) {
+	err := &K8sConfigMapNotOwnedError{ErrType: K8sConfigMapNotOwnedErrorType}
+	assert.True(t, IsK8sConfigMapNotOwned(err))
+	err2 := e.New("foo")
+	assert.False(t, IsK8sConfigMapNotOwned(err2))
+}
+
+func TestIsInvalidChecksumKey(t *testing.T) {
+	err := &InvalidChecksumKeyError{ErrType: InvalidChecksumKeyErrorType}
+	assert.True(t, IsInvalidChecksumKey(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidChecksumKey(err2))
+}
+
+func TestIsInvalidNamespacePathPrefixes(t *testing.T) {
+	err := &InvalidNamespacePathPrefixesError{ErrType: InvalidNamespacePathPrefixesErrorType}
+	assert.True(t, IsInvalidNamespacePathPrefixes(err))
+	err2 := e.New("foo")
+	assert.False(t, IsInvalidNamespacePathPrefixes(err2))
+}
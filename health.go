@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// readiness is an HTTP handler reporting whether secrets-manager finished its startup
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) setReady() {
+	atomic.StoreInt32(&r.ready, 1)
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&r.ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// leadership is an HTTP handler reporting whether this replica is the leader syncing the secrets
+type leadership struct {
+	// terms is the number of leadership terms started and not stopped yet
+	terms int32
+}
+
+func (l *leadership) startLeading() {
+	atomic.AddInt32(&l.terms, 1)
+}
+
+func (l *leadership) stopLeading() {
+	atomic.AddInt32(&l.terms, -1)
+}
+
+func (l *leadership) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&l.terms) <= 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("follower"))
+		return
+	}
+	w.Write([]byte("leader"))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(handler http.Handler) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder
+}
+
+func TestReadiness(t *testing.T) {
+	ready := &readiness{}
+
+	response := serve(ready)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "not ready", response.Body.String())
+
+	ready.setReady()
+	response = serve(ready)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "ok", response.Body.String())
+}
+
+func TestLeadership(t *testing.T) {
+	leader := &leadership{}
+
+	response := serve(leader)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "follower", response.Body.String())
+
+	leader.startLeading()
+	response = serve(leader)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "leader", response.Body.String())
+
+	// A new term may start before the previous one is stopped
+	leader.startLeading()
+	leader.stopLeading()
+	assert.Equal(t, http.StatusOK, serve(leader).Code)
+
+	leader.stopLeading()
+	assert.Equal(t, http.StatusServiceUnavailable, serve(leader).Code)
+}
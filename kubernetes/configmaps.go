@@ -0,0 +1,188 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	smerrors "github.com/tuenti/secrets-manager/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ConfigMapNotOwnedReason is recorded when a configmap not managed by secrets-manager is not updated
+const ConfigMapNotOwnedReason = "ConfigMapNotOwned"
+
+// ConfigMap represents a K8s configmap object written by secrets-manager
+type ConfigMap struct {
+	Name      string
+	Namespace string
+	// UID is only read, never written
+	UID string
+	// Data holds the UTF-8 values
+	Data map[string]string
+	// BinaryData holds the values that are not valid UTF-8
+	BinaryData  map[string][]byte
+	Labels      map[string]string
+	Annotations map[string]string
+	// Adopt allows updating an existing configmap not labelled as managed by secrets-manager
+	Adopt bool
+}
+
+func configMapReference(configMap *corev1.ConfigMap) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		Kind:            "ConfigMap",
+		APIVersion:      "v1",
+		Name:            configMap.Name,
+		Namespace:       configMap.Namespace,
+		UID:             configMap.UID,
+		ResourceVersion: configMap.ResourceVersion,
+	}
+}
+
+// UpsertConfigMap creates or replaces a configmap. Existing configmaps not labelled as managed by secrets-manager
+// are only replaced if adopted
+func (k *client) UpsertConfigMap(configMap *ConfigMap) error {
+	k8sConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        configMap.Name,
+			Namespace:   configMap.Namespace,
+			Labels:      configMap.Labels,
+			Annotations: replacedKeysAnnotation(configMap.Labels, configMap.Annotations),
+		},
+		Data:       configMap.Data,
+		BinaryData: configMap.BinaryData,
+	}
+	configMaps := k.client.CoreV1().ConfigMaps(configMap.Namespace)
+	current, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
+
+	var written *corev1.ConfigMap
+	reason, message := SecretUpdatedReason, "ConfigMap updated by secrets-manager"
+	switch {
+	case err != nil && errors.IsNotFound(err):
+		logger.Debugf("creating configmap '%s/%s'", configMap.Namespace, configMap.Name)
+		reason, message = SecretCreatedReason, "ConfigMap created by secrets-manager"
+		written, err = configMaps.Create(k8sConfigMap)
+	case err != nil:
+		// Do not update a configmap whose owner can not be checked
+	case current.Labels[ManagedByLabel] != ManagedByValue && !configMap.Adopt:
+		configMapNotOwnedCount.WithLabelValues(configMap.Name, configMap.Namespace).Inc()
+		k.recordEvent(configMapReference(current), corev1.EventTypeWarning, ConfigMapNotOwnedReason,
+			fmt.Sprintf("ConfigMap not updated, it is not labelled %s=%s. Set adopt in its secret definition to overwrite it", ManagedByLabel, ManagedByValue))
+		return &smerrors.K8sConfigMapNotOwnedError{ErrType: smerrors.K8sConfigMapNotOwnedErrorType, Name: configMap.Name, Namespace: configMap.Namespace}
+	default:
+		if current.Labels[ManagedByLabel] != ManagedByValue {
+			reason, message = SecretAdoptedReason, "ConfigMap adopted by secrets-manager"
+		}
+		logger.Debugf("updating configmap '%s/%s'", configMap.Namespace, configMap.Name)
+		// The resourceVersion of current fails the update if the configmap changed meanwhile
+		k8sConfigMap.ResourceVersion = current.ResourceVersion
+		written, err = configMaps.Update(k8sConfigMap)
+	}
+	if err != nil {
+		configMapUpdateErrorCount.WithLabelValues(configMap.Name, configMap.Namespace).Inc()
+		return err
+	}
+	if written == nil {
+		written = k8sConfigMap
+	}
+	k.recordEvent(configMapReference(written), corev1.EventTypeNormal, reason, message)
+	return nil
+}
+
+// GetConfigMap returns the data, binary data, labels and annotations of a configmap
+func (k *client) GetConfigMap(namespace string, name string) (*ConfigMap, error) {
+	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		configMapReadErrorCount.WithLabelValues(name, namespace).Inc()
+		if errors.IsNotFound(err) {
+			return nil, &smerrors.K8sConfigMapNotFoundError{ErrType: smerrors.K8sConfigMapNotFoundErrorType, Name: name, Namespace: namespace}
+		}
+		return nil, err
+	}
+
+	return &ConfigMap{
+		Name:        configMap.Name,
+		Namespace:   configMap.Namespace,
+		UID:         string(configMap.UID),
+		Data:        configMap.Data,
+		BinaryData:  configMap.BinaryData,
+		Labels:      configMap.Labels,
+		Annotations: configMap.Annotations,
+	}, nil
+}
+
+// ListConfigMaps returns the configmaps of namespace, or of every namespace with metav1.NamespaceAll, with every
+// label of matchLabels
+func (k *client) ListConfigMaps(namespace string, matchLabels map[string]string) ([]ConfigMap, error) {
+	selector := labels.SelectorFromSet(matchLabels).String()
+	list, err := k.client.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		configMapListErrorCount.WithLabelValues(namespace).Inc()
+		return nil, err
+	}
+
+	configMaps := make([]ConfigMap, 0, len(list.Items))
+	for _, item := range list.Items {
+		configMaps = append(configMaps, ConfigMap{
+			Name:        item.Name,
+			Namespace:   item.Namespace,
+			UID:         string(item.UID),
+			Data:        item.Data,
+			BinaryData:  item.BinaryData,
+			Labels:      item.Labels,
+			Annotations: item.Annotations,
+		})
+	}
+	return configMaps, nil
+}
+
+// DeleteConfigMap deletes a configmap labelled as managed by secrets-manager. Configmaps not found are ignored
+func (k *client) DeleteConfigMap(namespace string, name string) error {
+	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		configMapDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+
+	if configMap.Labels[ManagedByLabel] != ManagedByValue {
+		configMapDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return &smerrors.K8sConfigMapNotOwnedError{ErrType: smerrors.K8sConfigMapNotOwnedErrorType, Name: name, Namespace: namespace}
+	}
+
+	logger.Debugf("deleting configmap '%s/%s'", namespace, name)
+	err = k.client.CoreV1().ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		configMapDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+	return nil
+}
+
+// OrphanConfigMap removes the label marking a configmap as managed by secrets-manager, so it is never updated nor
+// deleted again. Configmaps not labelled as managed by secrets-manager are left untouched
+func (k *client) OrphanConfigMap(namespace string, name string) error {
+	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		configMapUpdateErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+
+	if configMap.Labels[ManagedByLabel] != ManagedByValue {
+		return &smerrors.K8sConfigMapNotOwnedError{ErrType: smerrors.K8sConfigMapNotOwnedErrorType, Name: name, Namespace: namespace}
+	}
+
+	logger.Debugf("orphaning configmap '%s/%s'", namespace, name)
+	delete(configMap.Labels, ManagedByLabel)
+	if _, err = k.client.CoreV1().ConfigMaps(namespace).Update(configMap); err != nil {
+		configMapUpdateErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	smerrors "github.com/tuenti/secrets-manager/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func newFakeConfigMapTarget() *ConfigMap {
+	return &ConfigMap{
+		Name:       "endpoints",
+		Namespace:  "ns",
+		Data:       map[string]string{"url": "https://db.example.com"},
+		BinaryData: map[string][]byte{"blob": {0xff, 0xfe}},
+		Labels:     map[string]string{ManagedByLabel: ManagedByValue},
+	}
+}
+
+func TestUpsertConfigMapResourceVersion(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "endpoints", Namespace: "ns", ResourceVersion: "42", Labels: map[string]string{ManagedByLabel: ManagedByValue}},
+	})
+	k8s := New(clientSet, log.New())
+
+	// Updating a configmap fails if it changed since it was read
+	assert.Nil(t, k8s.UpsertConfigMap(newFakeConfigMapTarget()))
+	updated := false
+	for _, action := range clientSet.Actions() {
+		if update, ok := action.(clientgotesting.UpdateAction); ok && action.GetResource().Resource == "configmaps" {
+			assert.Equal(t, "42", update.GetObject().(*corev1.ConfigMap).ResourceVersion)
+			updated = true
+		}
+	}
+	assert.True(t, updated)
+}
+
+func TestUpsertConfigMap(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+
+	assert.Nil(t, k8s.UpsertConfigMap(newFakeConfigMapTarget()))
+	assert.Nil(t, k8s.UpsertConfigMap(newFakeConfigMapTarget()))
+
+	configMap, err := k8s.GetConfigMap("ns", "endpoints")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"url": "https://db.example.com"}, configMap.Data)
+	assert.Equal(t, map[string][]byte{"blob": {0xff, 0xfe}}, configMap.BinaryData)
+
+	events, _ := clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	var reasons []string
+	for _, event := range events.Items {
+		assert.Equal(t, "ConfigMap", event.InvolvedObject.Kind)
+		reasons = append(reasons, event.Reason)
+	}
+	assert.ElementsMatch(t, []string{SecretCreatedReason, SecretUpdatedReason}, reasons)
+}
+
+func TestUpsertConfigMapNotOwned(t *testing.T) {
+	configMapNotOwnedCount.Reset()
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "endpoints", Namespace: "ns"},
+		Data:       map[string]string{"url": "handmade"},
+	})
+	k8s := New(clientSet, log.New())
+
+	err := k8s.UpsertConfigMap(newFakeConfigMapTarget())
+	assert.True(t, smerrors.IsK8sConfigMapNotOwned(err))
+	configMap, _ := clientSet.CoreV1().ConfigMaps("ns").Get("endpoints", metav1.GetOptions{})
+	assert.Equal(t, "handmade", configMap.Data["url"])
+	metricConfigMapNotOwnedCount, _ := configMapNotOwnedCount.GetMetricWithLabelValues("endpoints", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricConfigMapNotOwnedCount))
+
+	adopted := newFakeConfigMapTarget()
+	adopted.Adopt = true
+	assert.Nil(t, k8s.UpsertConfigMap(adopted))
+	configMap, _ = clientSet.CoreV1().ConfigMaps("ns").Get("endpoints", metav1.GetOptions{})
+	assert.Equal(t, "https://db.example.com", configMap.Data["url"])
+}
+
+func TestGetConfigMapNotFound(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+
+	_, err := k8s.GetConfigMap("ns", "endpoints")
+	assert.True(t, smerrors.IsK8sConfigMapNotFound(err))
+}
+
+func TestListConfigMaps(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+	other := newFakeConfigMapTarget()
+	other.Namespace = "other"
+	assert.Nil(t, k8s.UpsertConfigMap(newFakeConfigMapTarget()))
+	assert.Nil(t, k8s.UpsertConfigMap(other))
+	clientSet.CoreV1().ConfigMaps("ns").Create(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "handmade", Namespace: "ns"}})
+
+	configMaps, err := k8s.ListConfigMaps(metav1.NamespaceAll, map[string]string{ManagedByLabel: ManagedByValue})
+
+	assert.Nil(t, err)
+	assert.Len(t, configMaps, 2)
+	for _, configMap := range configMaps {
+		assert.Equal(t, "endpoints", configMap.Name)
+		assert.Equal(t, "https://db.example.com", configMap.Data["url"])
+	}
+}
+
+func TestDeleteConfigMap(t *testing.T) {
+	configMapDeleteErrorCount.Reset()
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+	assert.Nil(t, k8s.UpsertConfigMap(newFakeConfigMapTarget()))
+
+	assert.Nil(t, k8s.DeleteConfigMap("ns", "endpoints"))
+
+	_, err := k8s.GetConfigMap("ns", "endpoints")
+	assert.True(t, smerrors.IsK8sConfigMapNotFound(err))
+	assert.Nil(t, k8s.DeleteConfigMap("ns", "endpoints"))
+	metricConfigMapDeleteErrorCount, _ := configMapDeleteErrorCount.GetMetricWithLabelValues("endpoints", "ns")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metricConfigMapDeleteErrorCount))
+}
+
+func TestDeleteConfigMapNotOwned(t *testing.T) {
+	configMapDeleteErrorCount.Reset()
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "endpoints", Namespace: "ns"}})
+	k8s := New(clientSet, log.New())
+
+	err := k8s.DeleteConfigMap("ns", "endpoints")
+
+	assert.True(t, smerrors.IsK8sConfigMapNotOwned(err))
+	_, err = k8s.GetConfigMap("ns", "endpoints")
+	assert.Nil(t, err)
+	metricConfigMapDeleteErrorCount, _ := configMapDeleteErrorCount.GetMetricWithLabelValues("endpoints", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricConfigMapDeleteErrorCount))
+}
+
+func TestOrphanConfigMap(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+	target := newFakeConfigMapTarget()
+	target.Labels["owner"] = "team-a"
+	assert.Nil(t, k8s.UpsertConfigMap(target))
+
+	assert.Nil(t, k8s.OrphanConfigMap("ns", "endpoints"))
+
+	configMap, _ := clientSet.CoreV1().ConfigMaps("ns").Get("endpoints", metav1.GetOptions{})
+	assert.Equal(t, map[string]string{"owner": "team-a"}, configMap.Labels)
+	assert.Equal(t, target.Data, configMap.Data)
+	assert.True(t, smerrors.IsK8sConfigMapNotOwned(k8s.OrphanConfigMap("ns", "endpoints")))
+	assert.Nil(t, k8s.OrphanConfigMap("ns", "missing"))
+}
+
+func TestRecordConfigMapEventFallback(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+
+	k8s.RecordConfigMapEvent("ns", "endpoints", &ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: "cm", Namespace: "config"},
+		corev1.EventTypeWarning, SyncFailedReason, "failed")
+
+	events, _ := clientSet.CoreV1().Events("config").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, "ConfigMap ns/endpoints: failed", events.Items[0].Message)
+}
@@ -0,0 +1,178 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// eventSource is the component of the events recorded by secrets-manager
+const eventSource = "secrets-manager"
+
+// Reasons of the events recorded on secrets
+const (
+	// SecretNotOwnedReason is recorded when a secret not managed by secrets-manager is not updated
+	SecretNotOwnedReason = "SecretNotOwned"
+	// SecretAdoptedReason is recorded when a secret not managed by secrets-manager is adopted
+	SecretAdoptedReason = "SecretAdopted"
+	// SecretCreatedReason is recorded when a secret is created
+	SecretCreatedReason = "Created"
+	// SecretUpdatedReason is recorded when a secret is updated
+	SecretUpdatedReason = "Updated"
+	// SyncFailedReason is recorded when a secret can not be written
+	SyncFailedReason = "SyncFailed"
+	// BackendReadFailedReason is recorded when the data of a secret can not be read from the backend
+	BackendReadFailedReason = "BackendReadFailed"
+)
+
+const (
+	// eventUpdateInterval is the minimum interval between two writes of a repeated event, which is only
+	// counted meanwhile
+	eventUpdateInterval = time.Minute
+	// maxRecordedEvents is the number of recorded events kept to deduplicate repeated ones
+	maxRecordedEvents = 4096
+)
+
+// ObjectReference identifies the object events about a secret are recorded on when the secret does not exist
+type ObjectReference struct {
+	Kind       string
+	APIVersion string
+	Name       string
+	Namespace  string
+	// UID of the object. Optional, events without it are not shown by kubectl describe
+	UID string
+}
+
+// recordedEvent is the last written state of an event, along with the repetitions not written yet
+type recordedEvent struct {
+	event     *corev1.Event
+	pending   int32
+	writtenAt time.Time
+}
+
+// eventRecorder deduplicates repeated events, increasing the count of the event already recorded
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events map[string]*recordedEvent
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{events: make(map[string]*recordedEvent)}
+}
+
+func secretReference(secret *corev1.Secret) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		Kind:            "Secret",
+		APIVersion:      "v1",
+		Name:            secret.Name,
+		Namespace:       secret.Namespace,
+		UID:             secret.UID,
+		ResourceVersion: secret.ResourceVersion,
+	}
+}
+
+// RecordSecretEvent records an event about the secret namespace/name, on the secret itself or, when it does not
+// exist, on fallback. Without fallback, events about secrets that do not exist are dropped
+func (k *client) RecordSecretEvent(namespace string, name string, fallback *ObjectReference, eventType string, reason string, message string) {
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err == nil {
+		k.recordEvent(secretReference(secret), eventType, reason, message)
+		return
+	}
+	k.recordFallbackEvent("Secret", namespace, name, err, fallback, eventType, reason, message)
+}
+
+// RecordConfigMapEvent records an event about the configmap namespace/name, on the configmap itself or, when it
+// does not exist, on fallback. Without fallback, events about configmaps that do not exist are dropped
+func (k *client) RecordConfigMapEvent(namespace string, name string, fallback *ObjectReference, eventType string, reason string, message string) {
+	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err == nil {
+		k.recordEvent(configMapReference(configMap), eventType, reason, message)
+		return
+	}
+	k.recordFallbackEvent("ConfigMap", namespace, name, err, fallback, eventType, reason, message)
+}
+
+// recordFallbackEvent records an event about the object kind namespace/name, which could not be read, on fallback
+func (k *client) recordFallbackEvent(kind string, namespace string, name string, err error, fallback *ObjectReference, eventType string, reason string, message string) {
+	if fallback == nil {
+		logger.Debugf("dropping %s event for %s '%s/%s': %v", reason, kind, namespace, name, err)
+		return
+	}
+	ref := corev1.ObjectReference{
+		Kind:       fallback.Kind,
+		APIVersion: fallback.APIVersion,
+		Name:       fallback.Name,
+		Namespace:  fallback.Namespace,
+		UID:        types.UID(fallback.UID),
+	}
+	k.recordEvent(ref, eventType, reason, fmt.Sprintf("%s %s/%s: %s", kind, namespace, name, message))
+}
+
+// recordEvent creates an event about the object, or counts it again if it was already recorded. Errors are only
+// logged, events are informative
+func (k *client) recordEvent(ref corev1.ObjectReference, eventType string, reason string, message string) {
+	// Events about cluster scoped objects go to the default namespace
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", ref.Kind, ref.Namespace, ref.Name, ref.UID, eventType, reason, message)
+
+	k.events.mutex.Lock()
+	defer k.events.mutex.Unlock()
+
+	now := time.Now()
+	if recorded, ok := k.events.events[key]; ok {
+		recorded.pending++
+		if now.Sub(recorded.writtenAt) < eventUpdateInterval {
+			return
+		}
+		event := recorded.event.DeepCopy()
+		event.Count += recorded.pending
+		event.LastTimestamp = metav1.NewTime(now)
+		updated, err := k.client.CoreV1().Events(namespace).Update(event)
+		if err == nil {
+			k.events.events[key] = &recordedEvent{event: updated, writtenAt: now}
+			return
+		}
+		if !errors.IsNotFound(err) {
+			logger.Warnf("unable to record %s event for %s '%s/%s': %v", reason, ref.Kind, ref.Namespace, ref.Name, err)
+			eventCreateErrorCount.WithLabelValues(reason, namespace).Inc()
+			return
+		}
+		// The event expired, record it again
+		delete(k.events.events, key)
+	}
+
+	timestamp := metav1.NewTime(now)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventSource},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+	created, err := k.client.CoreV1().Events(namespace).Create(event)
+	if err != nil {
+		logger.Warnf("unable to record %s event for %s '%s/%s': %v", reason, ref.Kind, ref.Namespace, ref.Name, err)
+		eventCreateErrorCount.WithLabelValues(reason, namespace).Inc()
+		return
+	}
+	if len(k.events.events) >= maxRecordedEvents {
+		k.events.events = make(map[string]*recordedEvent)
+	}
+	k.events.events[key] = &recordedEvent{event: created, writtenAt: now}
+}
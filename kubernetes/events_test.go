@@ -0,0 +1,212 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	secretsManagerErrors "github.com/tuenti/secrets-manager/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func newUnmanagedSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret-test", Namespace: "ns", Labels: map[string]string{"owner": "team-a"}},
+		Data:       map[string][]byte{"value": []byte("handmade")},
+	}
+}
+
+func TestUpsertSecretNotOwned(t *testing.T) {
+	secretNotOwnedCount.Reset()
+	client := fake.NewSimpleClientset(newUnmanagedSecret())
+	k8s := New(client, log.New())
+
+	err := k8s.UpsertSecret(NewFakeSecret("ns", "secret-test"))
+
+	assert.True(t, secretsManagerErrors.IsK8sSecretNotOwned(err))
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, []byte("handmade"), secret.Data["value"])
+	metricSecretNotOwnedCount, _ := secretNotOwnedCount.GetMetricWithLabelValues("secret-test", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretNotOwnedCount))
+
+	events, _ := client.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, SecretNotOwnedReason, events.Items[0].Reason)
+	assert.Equal(t, corev1.EventTypeWarning, events.Items[0].Type)
+	assert.Equal(t, "Secret", events.Items[0].InvolvedObject.Kind)
+	assert.Equal(t, "secret-test", events.Items[0].InvolvedObject.Name)
+	assert.Equal(t, eventSource, events.Items[0].Source.Component)
+}
+
+func TestUpsertSecretAdopt(t *testing.T) {
+	secretNotOwnedCount.Reset()
+	client := fake.NewSimpleClientset(newUnmanagedSecret())
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	k8sSecret.Adopt = true
+	err := k8s.UpsertSecret(k8sSecret)
+
+	assert.Nil(t, err)
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, k8sSecret.Data, secret.Data)
+	assert.Equal(t, ManagedByValue, secret.Labels[ManagedByLabel])
+	metricSecretNotOwnedCount, _ := secretNotOwnedCount.GetMetricWithLabelValues("secret-test", "ns")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretNotOwnedCount))
+
+	events, _ := client.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, SecretAdoptedReason, events.Items[0].Reason)
+	assert.Equal(t, corev1.EventTypeNormal, events.Items[0].Type)
+
+	// Once adopted, the secret is managed and only updated
+	k8sSecret.Adopt = false
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+	events, _ = client.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 2)
+	assert.ElementsMatch(t, []string{SecretAdoptedReason, SecretUpdatedReason}, eventReasons(events.Items))
+}
+
+func eventReasons(events []corev1.Event) []string {
+	reasons := make([]string, 0, len(events))
+	for _, event := range events {
+		reasons = append(reasons, event.Reason)
+	}
+	return reasons
+}
+
+func TestUpsertSecretEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+
+	events, _ := client.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.ElementsMatch(t, []string{SecretCreatedReason, SecretUpdatedReason}, eventReasons(events.Items))
+	for _, event := range events.Items {
+		assert.Equal(t, corev1.EventTypeNormal, event.Type)
+		assert.Equal(t, "secret-test", event.InvolvedObject.Name)
+	}
+}
+
+func TestRecordSecretEvent(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret-test", Namespace: "ns", UID: "1234"}})
+	k8s := New(client, log.New())
+
+	k8s.RecordSecretEvent("ns", "secret-test", nil, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+
+	events, _ := client.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, SyncFailedReason, events.Items[0].Reason)
+	assert.Equal(t, "forbidden", events.Items[0].Message)
+	assert.Equal(t, corev1.ObjectReference{Kind: "Secret", APIVersion: "v1", Name: "secret-test", Namespace: "ns", UID: "1234"}, events.Items[0].InvolvedObject)
+}
+
+func TestRecordSecretEventFallback(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+
+	// Without fallback, events about missing secrets are dropped
+	k8s.RecordSecretEvent("ns", "secret-test", nil, corev1.EventTypeWarning, BackendReadFailedReason, "not found")
+	events, _ := client.CoreV1().Events(metav1.NamespaceAll).List(metav1.ListOptions{})
+	assert.Empty(t, events.Items)
+
+	fallback := &ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: "config", Namespace: "kube-system"}
+	k8s.RecordSecretEvent("ns", "secret-test", fallback, corev1.EventTypeWarning, BackendReadFailedReason, "not found")
+	events, _ = client.CoreV1().Events("kube-system").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, "Secret ns/secret-test: not found", events.Items[0].Message)
+	assert.Equal(t, "config", events.Items[0].InvolvedObject.Name)
+
+	// Events about cluster scoped objects are recorded in the default namespace
+	fallback = &ObjectReference{Kind: "SecretDefinition", APIVersion: "secrets-manager.tuenti.io/v1alpha1", Name: "db", UID: "1234"}
+	k8s.RecordSecretEvent("ns", "secret-test", fallback, corev1.EventTypeWarning, BackendReadFailedReason, "not found")
+	events, _ = client.CoreV1().Events(metav1.NamespaceDefault).List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, "db", events.Items[0].InvolvedObject.Name)
+}
+
+func TestRecordEventDeduplicates(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New()).(*client)
+	ref := secretReference(newUnmanagedSecret())
+
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+
+	// Repetitions are only counted until eventUpdateInterval elapses
+	events, _ := clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, int32(1), events.Items[0].Count)
+
+	for _, recorded := range k8s.events.events {
+		recorded.writtenAt = recorded.writtenAt.Add(-eventUpdateInterval)
+	}
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+
+	events, _ = clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, int32(3), events.Items[0].Count)
+
+	// Other messages are other events
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "timeout")
+	events, _ = clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 2)
+}
+
+func TestRecordEventExpired(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New()).(*client)
+	ref := secretReference(newUnmanagedSecret())
+
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+	events, _ := clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	clientSet.CoreV1().Events("ns").Delete(events.Items[0].Name, &metav1.DeleteOptions{})
+	for _, recorded := range k8s.events.events {
+		recorded.writtenAt = recorded.writtenAt.Add(-eventUpdateInterval)
+	}
+
+	k8s.recordEvent(ref, corev1.EventTypeWarning, SyncFailedReason, "forbidden")
+
+	events, _ = clientSet.CoreV1().Events("ns").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, int32(1), events.Items[0].Count)
+}
+
+func TestUpsertSecretReadError(t *testing.T) {
+	client := fake.NewSimpleClientset(newUnmanagedSecret())
+	client.PrependReactor("get", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("some error")
+	})
+	k8s := New(client, log.New())
+
+	err := k8s.UpsertSecret(NewFakeSecret("ns", "secret-test"))
+
+	assert.NotNil(t, err)
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+}
+
+func TestRecordEventError(t *testing.T) {
+	eventCreateErrorCount.Reset()
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("create", "events", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	k8s := New(clientSet, log.New()).(*client)
+	k8s.recordEvent(secretReference(newUnmanagedSecret()), corev1.EventTypeWarning, SecretNotOwnedReason, "message")
+
+	metricEventCreateErrorCount, _ := eventCreateErrorCount.GetMetricWithLabelValues(SecretNotOwnedReason, "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricEventCreateErrorCount))
+}
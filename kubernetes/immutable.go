@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// immutablePatch sets the immutable field of a secret, which the secret type of this client does not have
+var immutablePatch = []byte(`{"immutable":true}`)
+
+// makeImmutable marks a secret just created as immutable. If it can not be marked, the secret is deleted so that
+// it is created again on the next sync instead of being left mutable
+func (k *client) makeImmutable(namespace string, name string) error {
+	_, err := k.client.CoreV1().Secrets(namespace).Patch(name, types.MergePatchType, immutablePatch)
+	if err == nil {
+		return nil
+	}
+	logger.Warnf("unable to mark secret '%s/%s' as immutable, deleting it: %v", namespace, name, err)
+	if deleteErr := k.client.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{}); deleteErr != nil {
+		secretDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		logger.Errorf("unable to delete secret '%s/%s' not marked as immutable: %v", namespace, name, deleteErr)
+	}
+	return err
+}
+
+// patchImmutable writes the data, labels and annotations of desired into the current immutable secret with a
+// merge patch, as updating the whole secret would unset its immutable field. The API server refuses to change
+// the data of secrets already immutable
+func (k *client) patchImmutable(current *corev1.Secret, desired *corev1.Secret) (*corev1.Secret, error) {
+	data := make(map[string]interface{}, len(desired.Data))
+	for k := range current.Data {
+		data[k] = nil
+	}
+	for k, v := range desired.Data {
+		data[k] = v
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      stringsPatch(current.Labels, desired.Labels),
+			"annotations": stringsPatch(current.Annotations, desired.Annotations),
+		},
+		"data":      data,
+		"immutable": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.client.CoreV1().Secrets(current.Namespace).Patch(current.Name, types.MergePatchType, patch)
+}
+
+// stringsPatch returns the merge patch replacing the current map with the desired one
+func stringsPatch(current map[string]string, desired map[string]string) map[string]interface{} {
+	patch := make(map[string]interface{}, len(current)+len(desired))
+	for k := range current {
+		patch[k] = nil
+	}
+	for k, v := range desired {
+		patch[k] = v
+	}
+	return patch
+}
@@ -0,0 +1,96 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+// secretPatches returns the patches of secrets sent to the fake client
+func secretPatches(clientSet *fake.Clientset) []clientgotesting.PatchAction {
+	patches := make([]clientgotesting.PatchAction, 0)
+	for _, action := range clientSet.Actions() {
+		if patch, ok := action.(clientgotesting.PatchAction); ok && patch.GetResource().Resource == "secrets" {
+			patches = append(patches, patch)
+		}
+	}
+	return patches
+}
+
+func TestUpsertSecretImmutable(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("patch", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	k8s := New(clientSet, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Immutable = true
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+
+	patches := secretPatches(clientSet)
+	assert.Len(t, patches, 1)
+	assert.Equal(t, "secret-test", patches[0].GetName())
+	assert.JSONEq(t, `{"immutable":true}`, string(patches[0].GetPatch()))
+}
+
+func TestUpsertSecretImmutableNotMarked(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("patch", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("patch forbidden")
+	})
+	k8s := New(clientSet, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Immutable = true
+	assert.NotNil(t, k8s.UpsertSecret(k8sSecret))
+
+	// The secret is deleted, to be created again on the next sync
+	_, err := clientSet.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestUpsertSecretImmutableUpdate(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-test",
+			Namespace: "ns",
+			Labels:    map[string]string{ManagedByLabel: ManagedByValue, "old": "x"},
+		},
+		Data: map[string][]byte{"value": []byte("v")},
+	})
+	clientSet.PrependReactor("patch", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	k8s := New(clientSet, log.New())
+
+	k8sSecret := &Secret{
+		Name:      "secret-test",
+		Namespace: "ns",
+		Labels:    map[string]string{ManagedByLabel: ManagedByValue},
+		Data:      map[string][]byte{"value": []byte("v")},
+		Immutable: true,
+	}
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+
+	// Immutable secrets are patched, an update would unset immutable
+	for _, action := range clientSet.Actions() {
+		_, updated := action.(clientgotesting.UpdateAction)
+		assert.False(t, updated && action.GetResource().Resource == "secrets")
+	}
+	patches := secretPatches(clientSet)
+	assert.Len(t, patches, 1)
+	var patch map[string]interface{}
+	assert.Nil(t, json.Unmarshal(patches[0].GetPatch(), &patch))
+	assert.Equal(t, true, patch["immutable"])
+	assert.Equal(t, map[string]interface{}{"value": "dg=="}, patch["data"])
+	assert.Equal(t, map[string]interface{}{ManagedByLabel: ManagedByValue, "old": nil}, patch["metadata"].(map[string]interface{})["labels"])
+}
@@ -1,10 +1,17 @@
 package kubernetes
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 
 	log "github.com/sirupsen/logrus"
 	smerrors "github.com/tuenti/secrets-manager/errors"
@@ -12,30 +19,85 @@ import (
 
 var logger *log.Logger
 
+const (
+	// ManagedByLabel is the label set on every secret written by secrets-manager
+	ManagedByLabel = "managedBy"
+	// ManagedByValue is the value of ManagedByLabel on every secret written by secrets-manager
+	ManagedByValue = "secrets-manager"
+)
+
 // Secret represents a K8s secret object
 type Secret struct {
-	Name      string
-	Namespace string
-	Data      map[string][]byte
-	Type      string
-	Labels    map[string]string
+	Name        string
+	Namespace   string
+	Data        map[string][]byte
+	Type        string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Adopt allows updating an existing secret not labelled as managed by secrets-manager
+	Adopt bool
+	// Merge only writes the data keys, labels and annotations of the secret into an existing one, preserving the
+	// other ones, instead of replacing it
+	Merge bool
+	// Immutable marks the secret as immutable when it is created
+	Immutable bool
+	// CreatedAt is the creation timestamp of secrets read from Kubernetes
+	CreatedAt time.Time
 }
 
 // Client provides a facade on the K8s API
 type Client interface {
 	UpsertSecret(secret *Secret) error
 	ReadSecret(namespace string, name string) (map[string][]byte, error)
+	GetSecret(namespace string, name string) (*Secret, error)
 	ReadConfigMap(name string, namespace string, key string) (string, error)
+	WatchConfigMap(name string, namespace string, onChange ChangeHandler, stopCh <-chan struct{})
+	DeleteSecret(namespace string, name string) error
+	ListSecrets(namespace string, matchLabels map[string]string) ([]Secret, error)
+	OrphanSecret(namespace string, name string) error
+	ListSecretDefinitions() ([]SecretDefinition, error)
+	UpdateSecretDefinitionStatus(definition *SecretDefinition, status *SecretDefinitionStatus) error
+	WatchSecretDefinitions(onChange ChangeHandler, stopCh <-chan struct{}) error
+	RecordSecretEvent(namespace string, name string, fallback *ObjectReference, eventType string, reason string, message string)
+	ListWorkloads(namespace string) ([]Workload, error)
+	AnnotatePodTemplate(workload Workload, key string, value string) error
+	WatchNamespaces(onChange ChangeHandler, stopCh <-chan struct{}) <-chan struct{}
+	ListNamespaces() ([]Namespace, error)
+	UpsertConfigMap(configMap *ConfigMap) error
+	GetConfigMap(namespace string, name string) (*ConfigMap, error)
+	ListConfigMaps(namespace string, matchLabels map[string]string) ([]ConfigMap, error)
+	DeleteConfigMap(namespace string, name string) error
+	OrphanConfigMap(namespace string, name string) error
+	RecordConfigMapEvent(namespace string, name string, fallback *ObjectReference, eventType string, reason string, message string)
+	RunLeaderElection(config LeaderElectionConfig, onStartedLeading func(stop <-chan struct{}), stopCh <-chan struct{}) error
 }
 
 type client struct {
-	client kubernetes.Interface
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	events  *eventRecorder
+	// namespaces is the informer caching the namespaces once they are watched
+	namespaces      cache.SharedInformer
+	namespacesMutex sync.Mutex
 }
 
 // New creates a K8s client
 func New(clientSet kubernetes.Interface, l *log.Logger) Client {
 	k := &client{
 		client: clientSet,
+		events: newEventRecorder(),
+	}
+	logger = l
+	return k
+}
+
+// NewWithDynamic creates a K8s client that can also read SecretDefinition custom resources through dynamicClient,
+// a client for SecretDefinitionGroupVersion
+func NewWithDynamic(clientSet kubernetes.Interface, dynamicClient dynamic.Interface, l *log.Logger) Client {
+	k := &client{
+		client:  clientSet,
+		dynamic: dynamicClient,
+		events:  newEventRecorder(),
 	}
 	logger = l
 	return k
@@ -45,25 +107,66 @@ func (k *client) UpsertSecret(secret *Secret) error {
 	k8sSecret := &corev1.Secret{
 		Type: corev1.SecretType(secret.Type),
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      secret.Name,
-			Labels:    secret.Labels,
-			Namespace: secret.Namespace,
+			Name:        secret.Name,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+			Namespace:   secret.Namespace,
 		},
 		Data: secret.Data,
 	}
-	_, err := k.client.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
+	if !secret.Merge {
+		k8sSecret.Annotations = replacedKeysAnnotation(secret.Labels, secret.Annotations)
+	}
+	current, err := k.client.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
 
-	if err != nil && errors.IsNotFound(err) {
+	var written *corev1.Secret
+	reason, message := SecretUpdatedReason, "Secret updated by secrets-manager"
+	switch {
+	case err != nil && errors.IsNotFound(err):
 		logger.Debugf("creating secret '%s/%s'", secret.Namespace, secret.Name)
-		_, err = k.client.CoreV1().Secrets(secret.Namespace).Create(k8sSecret)
-	} else {
+		reason, message = SecretCreatedReason, "Secret created by secrets-manager"
+		if secret.Merge {
+			k8sSecret = mergeSecret(&corev1.Secret{Type: k8sSecret.Type, ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: secret.Namespace}}, k8sSecret)
+		}
+		written, err = k.client.CoreV1().Secrets(secret.Namespace).Create(k8sSecret)
+		if err == nil && secret.Immutable {
+			err = k.makeImmutable(secret.Namespace, secret.Name)
+		}
+	case err != nil:
+		// Do not update a secret whose owner can not be checked
+	case current.Labels[ManagedByLabel] != ManagedByValue && !secret.Adopt:
+		secretNotOwnedCount.WithLabelValues(secret.Name, secret.Namespace).Inc()
+		k.recordEvent(secretReference(current), corev1.EventTypeWarning, SecretNotOwnedReason,
+			fmt.Sprintf("Secret not updated, it is not labelled %s=%s. Set adopt in its secret definition to overwrite it", ManagedByLabel, ManagedByValue))
+		return &smerrors.K8sSecretNotOwnedError{ErrType: smerrors.K8sSecretNotOwnedErrorType, Name: secret.Name, Namespace: secret.Namespace}
+	default:
+		if current.Labels[ManagedByLabel] != ManagedByValue {
+			reason, message = SecretAdoptedReason, "Secret adopted by secrets-manager"
+		}
+		// The resourceVersion of current fails the update if the secret changed meanwhile
+		k8sSecret.ResourceVersion = current.ResourceVersion
+		if secret.Merge {
+			k8sSecret = mergeSecret(current, k8sSecret)
+		}
 		logger.Debugf("updating secret '%s/%s'", secret.Namespace, secret.Name)
-		_, err = k.client.CoreV1().Secrets(secret.Namespace).Update(k8sSecret)
+		if secret.Immutable {
+			written, err = k.patchImmutable(current, k8sSecret)
+		} else {
+			written, err = k.client.CoreV1().Secrets(secret.Namespace).Update(k8sSecret)
+		}
 	}
 	if err != nil {
 		secretUpdateErrorCount.WithLabelValues(secret.Name, secret.Namespace).Inc()
+		return err
+	}
+	if reason == SecretAdoptedReason {
+		logger.Infof("secret '%s/%s' adopted", secret.Namespace, secret.Name)
 	}
-	return err
+	if written == nil {
+		written = k8sSecret
+	}
+	k.recordEvent(secretReference(written), corev1.EventTypeNormal, reason, message)
+	return nil
 }
 
 // ReadSecret returns a particular key in Kubernetes secrets object
@@ -83,6 +186,103 @@ func (k *client) ReadSecret(namespace string, name string) (map[string][]byte, e
 	return data, err
 }
 
+// GetSecret returns the data, type, labels and annotations of a secret
+func (k *client) GetSecret(namespace string, name string) (*Secret, error) {
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		secretReadErrorCount.WithLabelValues(name, namespace).Inc()
+		if errors.IsNotFound(err) {
+			return nil, &smerrors.K8sSecretNotFoundError{ErrType: smerrors.K8sSecretNotFoundErrorType, Name: name, Namespace: namespace}
+		}
+		return nil, err
+	}
+
+	return &Secret{
+		Name:        secret.Name,
+		Namespace:   secret.Namespace,
+		Data:        secret.Data,
+		Type:        string(secret.Type),
+		Labels:      secret.Labels,
+		Annotations: secret.Annotations,
+		CreatedAt:   secret.CreationTimestamp.Time,
+	}, nil
+}
+
+// DeleteSecret deletes a secret previously written by secrets-manager. Secrets not labelled as managed
+// by secrets-manager are left untouched
+func (k *client) DeleteSecret(namespace string, name string) error {
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		secretDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+
+	if secret.Labels[ManagedByLabel] != ManagedByValue {
+		secretDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return &smerrors.K8sSecretNotOwnedError{ErrType: smerrors.K8sSecretNotOwnedErrorType, Name: name, Namespace: namespace}
+	}
+
+	logger.Debugf("deleting secret '%s/%s'", namespace, name)
+	err = k.client.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		secretDeleteErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+	return nil
+}
+
+// ListSecrets returns the secrets of namespace, or of every namespace with metav1.NamespaceAll, with every label of matchLabels
+func (k *client) ListSecrets(namespace string, matchLabels map[string]string) ([]Secret, error) {
+	selector := labels.SelectorFromSet(matchLabels).String()
+	list, err := k.client.CoreV1().Secrets(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		secretListErrorCount.WithLabelValues(namespace).Inc()
+		return nil, err
+	}
+
+	secrets := make([]Secret, 0, len(list.Items))
+	for _, item := range list.Items {
+		secrets = append(secrets, Secret{
+			Name:        item.Name,
+			Namespace:   item.Namespace,
+			Data:        item.Data,
+			Type:        string(item.Type),
+			Labels:      item.Labels,
+			Annotations: item.Annotations,
+			CreatedAt:   item.CreationTimestamp.Time,
+		})
+	}
+	return secrets, nil
+}
+
+// OrphanSecret removes the label marking a secret as managed by secrets-manager, so it is never updated nor
+// deleted again. Secrets not labelled as managed by secrets-manager are left untouched
+func (k *client) OrphanSecret(namespace string, name string) error {
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		secretUpdateErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+
+	if secret.Labels[ManagedByLabel] != ManagedByValue {
+		return &smerrors.K8sSecretNotOwnedError{ErrType: smerrors.K8sSecretNotOwnedErrorType, Name: name, Namespace: namespace}
+	}
+
+	logger.Debugf("orphaning secret '%s/%s'", namespace, name)
+	delete(secret.Labels, ManagedByLabel)
+	if _, err = k.client.CoreV1().Secrets(namespace).Update(secret); err != nil {
+		secretUpdateErrorCount.WithLabelValues(name, namespace).Inc()
+		return err
+	}
+	return nil
+}
+
 func (k *client) ReadConfigMap(name string, namespace string, key string) (string, error) {
 	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
 	if err != nil {
@@ -39,6 +39,24 @@ func TestUpsertSecretDoesNotExist(t *testing.T) {
 	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretUpdateErrorCount))
 }
 
+func TestUpsertSecretResourceVersion(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret-test", Namespace: "ns", ResourceVersion: "42", Labels: map[string]string{ManagedByLabel: ManagedByValue}},
+	})
+	k8s := New(client, log.New())
+
+	// Replacing a secret fails if it changed since it was read
+	assert.Nil(t, k8s.UpsertSecret(NewFakeSecret("ns", "secret-test")))
+	updated := false
+	for _, action := range client.Actions() {
+		if update, ok := action.(clientgotesting.UpdateAction); ok && action.GetResource().Resource == "secrets" {
+			assert.Equal(t, "42", update.GetObject().(*corev1.Secret).ResourceVersion)
+			updated = true
+		}
+	}
+	assert.True(t, updated)
+}
+
 func TestUpsertSecretAlreadyExists(t *testing.T) {
 	secretUpdateErrorCount.Reset()
 	// Create the fake client.
@@ -52,6 +70,7 @@ func TestUpsertSecretAlreadyExists(t *testing.T) {
 	k8s := New(client, logger)
 
 	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue}
 
 	// Upsert twice, second must be an update
 	k8s.UpsertSecret(k8sSecret)
@@ -64,6 +83,66 @@ func TestUpsertSecretAlreadyExists(t *testing.T) {
 	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretUpdateErrorCount))
 }
 
+func TestUpsertSecretAnnotations(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Annotations = map[string]string{"foo": "bar"}
+
+	k8s.UpsertSecret(k8sSecret)
+
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, map[string]string{"foo": "bar", ManagedKeysAnnotation: `{"annotations":["foo"]}`}, secret.Annotations)
+}
+
+func TestDeleteSecret(t *testing.T) {
+	secretDeleteErrorCount.Reset()
+	client := fake.NewSimpleClientset()
+
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	k8s.UpsertSecret(k8sSecret)
+
+	err := k8s.DeleteSecret("ns", "secret-test")
+
+	assert.Nil(t, err)
+	_, err = client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.NotNil(t, err)
+	metricSecretDeleteErrorCount, _ := secretDeleteErrorCount.GetMetricWithLabelValues("secret-test", "ns")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretDeleteErrorCount))
+}
+
+func TestDeleteSecretNotFound(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	k8s := New(client, log.New())
+
+	err := k8s.DeleteSecret("ns", "secret-test")
+
+	assert.Nil(t, err)
+}
+
+func TestDeleteSecretNotOwned(t *testing.T) {
+	secretDeleteErrorCount.Reset()
+	client := fake.NewSimpleClientset()
+
+	k8s := New(client, log.New())
+
+	k8s.UpsertSecret(NewFakeSecret("ns", "secret-test"))
+
+	err := k8s.DeleteSecret("ns", "secret-test")
+
+	assert.True(t, secretsManagerErrors.IsK8sSecretNotOwned(err))
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.NotNil(t, secret)
+	metricSecretDeleteErrorCount, _ := secretDeleteErrorCount.GetMetricWithLabelValues("secret-test", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretDeleteErrorCount))
+}
+
 func TestReadConfigMap(t *testing.T) {
 
 	// Create the fake client.
@@ -149,3 +228,94 @@ func TestReadSecretError(t *testing.T) {
 	metricSecretReadErrorCount, _ := secretReadErrorCount.GetMetricWithLabelValues("secret-test", "ns")
 	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretReadErrorCount))
 }
+
+func TestGetSecret(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		Type: corev1.SecretTypeOpaque,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret-test",
+			Namespace:   "ns",
+			Labels:      map[string]string{"team": "payments"},
+			Annotations: map[string]string{"owner": "payments@example.com"},
+		},
+		Data: map[string][]byte{
+			"some-key": []byte("some-value"),
+		},
+	})
+	k8s := New(client, log.New())
+
+	secret, err := k8s.GetSecret("ns", "secret-test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Secret{
+		Name:        "secret-test",
+		Namespace:   "ns",
+		Type:        string(corev1.SecretTypeOpaque),
+		Data:        map[string][]byte{"some-key": []byte("some-value")},
+		Labels:      map[string]string{"team": "payments"},
+		Annotations: map[string]string{"owner": "payments@example.com"},
+	}, secret)
+}
+
+func TestGetSecretNotFound(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+
+	secret, err := k8s.GetSecret("ns", "secret-test")
+
+	assert.Nil(t, secret)
+	assert.True(t, secretsManagerErrors.IsK8sSecretNotFound(err))
+}
+
+func TestListSecrets(t *testing.T) {
+	managed := NewFakeSecret("ns", "managed")
+	managed.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	other := NewFakeSecret("other", "managed-too")
+	other.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+	k8s.UpsertSecret(managed)
+	k8s.UpsertSecret(other)
+	k8s.UpsertSecret(NewFakeSecret("ns", "unmanaged"))
+
+	secrets, err := k8s.ListSecrets(metav1.NamespaceAll, map[string]string{ManagedByLabel: ManagedByValue})
+
+	assert.Nil(t, err)
+	assert.Len(t, secrets, 2)
+	for _, secret := range secrets {
+		assert.Equal(t, ManagedByValue, secret.Labels[ManagedByLabel])
+	}
+
+	secrets, err = k8s.ListSecrets("ns", nil)
+	assert.Nil(t, err)
+	assert.Len(t, secrets, 2)
+}
+
+func TestOrphanSecret(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue, "owner": "team-a"}
+	k8s.UpsertSecret(k8sSecret)
+
+	err := k8s.OrphanSecret("ns", "secret-test")
+
+	assert.Nil(t, err)
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, map[string]string{"owner": "team-a"}, secret.Labels)
+	assert.Equal(t, k8sSecret.Data, secret.Data)
+}
+
+func TestOrphanSecretNotOwned(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+	k8s.UpsertSecret(NewFakeSecret("ns", "secret-test"))
+
+	err := k8s.OrphanSecret("ns", "secret-test")
+
+	assert.True(t, secretsManagerErrors.IsK8sSecretNotOwned(err))
+}
+
+func TestOrphanSecretNotFound(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+	assert.Nil(t, k8s.OrphanSecret("ns", "secret-test"))
+}
@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig configures the election of the replica syncing the secrets
+type LeaderElectionConfig struct {
+	// Namespace and Name of the configmap used as the lock
+	Namespace string
+	Name      string
+	// Identity of this replica in the lock, unique among the replicas
+	Identity string
+	// LeaseDuration is how long the followers wait before taking over a lease that is not renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing its lease before giving up the leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the time between two attempts to acquire or renew the lease
+	RetryPeriod time.Duration
+}
+
+// lockEventRecorder records the events of the leader election lock through the event recorder of the client
+type lockEventRecorder struct {
+	k *client
+}
+
+func (r *lockEventRecorder) Event(object runtime.Object, eventType string, reason string, message string) {
+	configMap, ok := object.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	r.k.recordEvent(configMapReference(configMap), eventType, reason, message)
+}
+
+func (r *lockEventRecorder) Eventf(object runtime.Object, eventType string, reason string, messageFmt string, args ...interface{}) {
+	r.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *lockEventRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventType string, reason string, messageFmt string, args ...interface{}) {
+	r.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// errLockStopped is returned by a stopped leader election lock
+var errLockStopped = fmt.Errorf("leader election stopped")
+
+// stoppableLock is a leader election lock that is neither acquired nor renewed anymore once stopped. The leader
+// elector of client-go can not be stopped, so reading a stopped lock blocks it forever instead
+type stoppableLock struct {
+	resourcelock.Interface
+	mutex   sync.Mutex
+	stopped bool
+}
+
+func (l *stoppableLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	l.mutex.Lock()
+	if l.stopped {
+		l.mutex.Unlock()
+		select {}
+	}
+	defer l.mutex.Unlock()
+	return l.Interface.Get()
+}
+
+func (l *stoppableLock) Create(record resourcelock.LeaderElectionRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.stopped {
+		return errLockStopped
+	}
+	return l.Interface.Create(record)
+}
+
+func (l *stoppableLock) Update(record resourcelock.LeaderElectionRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.stopped {
+		return errLockStopped
+	}
+	return l.Interface.Update(record)
+}
+
+// stop stops acquiring and renewing the lock
+func (l *stoppableLock) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.stopped = true
+}
+
+// RunLeaderElection campaigns for the leadership until stopCh is closed, calling onStartedLeading every time this
+// replica becomes the leader. The stop channel given to onStartedLeading is closed as soon as the leadership is lost
+// or stopCh is closed, and RunLeaderElection waits for onStartedLeading to return before returning itself. The lock
+// is a ConfigMapsResourceLock, the leader election record annotated on a configmap, not a coordination.k8s.io Lease,
+// which the client-go version in use does not support. The record is no longer renewed once stopCh is closed: the
+// other replicas take over once it is not renewed for LeaseDuration
+func (k *client) RunLeaderElection(config LeaderElectionConfig, onStartedLeading func(stop <-chan struct{}), stopCh <-chan struct{}) error {
+	// The callbacks of the leader elector may still run after RunLeaderElection returns
+	logger := logger
+	resourceLock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, config.Namespace, config.Name, k.client.CoreV1(),
+		resourcelock.ResourceLockConfig{Identity: config.Identity, EventRecorder: &lockEventRecorder{k: k}})
+	if err != nil {
+		return err
+	}
+	lock := &stoppableLock{Interface: resourceLock}
+
+	var mutex sync.Mutex
+	var leading sync.WaitGroup
+	stopped := false
+	isStopped := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return stopped
+	}
+	electionConfig := leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				// Do not start leading once stopCh is closed, RunLeaderElection may have returned already
+				mutex.Lock()
+				if stopped {
+					mutex.Unlock()
+					return
+				}
+				leading.Add(1)
+				mutex.Unlock()
+				defer leading.Done()
+
+				logger.Infof("became the leader of %s/%s as %s", config.Namespace, config.Name, config.Identity)
+				leader.Set(1)
+				leaderStop := make(chan struct{})
+				go func() {
+					select {
+					case <-stop:
+					case <-stopCh:
+					}
+					close(leaderStop)
+				}()
+				onStartedLeading(leaderStop)
+			},
+			OnStoppedLeading: func() {
+				if isStopped() {
+					return
+				}
+				logger.Warnf("lost the leadership of %s/%s", config.Namespace, config.Name)
+				leader.Set(0)
+			},
+			OnNewLeader: func(identity string) {
+				if isStopped() {
+					return
+				}
+				logger.Infof("%s is the leader of %s/%s", identity, config.Namespace, config.Name)
+			},
+		},
+	}
+	if _, err := leaderelection.NewLeaderElector(electionConfig); err != nil {
+		return err
+	}
+
+	for {
+		// A leader elector can not be run again once it lost the leadership
+		elector, _ := leaderelection.NewLeaderElector(electionConfig)
+		lost := make(chan struct{})
+		go func() {
+			defer close(lost)
+			elector.Run()
+		}()
+
+		select {
+		case <-lost:
+			logger.Infof("campaigning again for the leadership of %s/%s", config.Namespace, config.Name)
+		case <-stopCh:
+			mutex.Lock()
+			stopped = true
+			mutex.Unlock()
+			leading.Wait()
+			lock.stop()
+			leader.Set(0)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func newFakeLeaderElectionConfig(identity string, leaseDuration time.Duration) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		Namespace:     "ns",
+		Name:          "secrets-manager-leader",
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: leaseDuration / 2,
+		RetryPeriod:   50 * time.Millisecond,
+	}
+}
+
+func TestRunLeaderElection(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+
+	started := make(chan (<-chan struct{}), 1)
+	stopped := make(chan struct{})
+	stopCh := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		err := k8s.RunLeaderElection(newFakeLeaderElectionConfig("replica-1", time.Second), func(stop <-chan struct{}) {
+			started <- stop
+			<-stop
+		}, stopCh)
+		assert.Nil(t, err)
+	}()
+
+	var leaderStop <-chan struct{}
+	select {
+	case leaderStop = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership not acquired")
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(leader))
+	lock, err := clientSet.CoreV1().ConfigMaps("ns").Get("secrets-manager-leader", metav1.GetOptions{})
+	assert.Nil(t, err)
+	var record resourcelock.LeaderElectionRecord
+	assert.Nil(t, json.Unmarshal([]byte(lock.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]), &record))
+	assert.Equal(t, "replica-1", record.HolderIdentity)
+
+	// Stopping the leader election stops leading, and waits for it
+	close(stopCh)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("leader election not stopped")
+	}
+	_, open := <-leaderStop
+	assert.False(t, open)
+	assert.Equal(t, 0.0, testutil.ToFloat64(leader))
+}
+
+func TestRunLeaderElectionHandover(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	k8s := New(clientSet, log.New())
+
+	// Followers take over once the stopped leader no longer renews its lease
+	leaders := make(chan string, 2)
+	stopChs := map[string]chan struct{}{"replica-1": make(chan struct{}), "replica-2": make(chan struct{})}
+	for identity, stopCh := range stopChs {
+		go func(identity string, stopCh chan struct{}) {
+			k8s.RunLeaderElection(newFakeLeaderElectionConfig(identity, 500*time.Millisecond), func(stop <-chan struct{}) {
+				leaders <- identity
+				<-stop
+			}, stopCh)
+		}(identity, stopCh)
+	}
+
+	var first string
+	select {
+	case first = <-leaders:
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership not acquired")
+	}
+	close(stopChs[first])
+
+	select {
+	case second := <-leaders:
+		assert.NotEqual(t, first, second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership not handed over")
+	}
+	for identity, stopCh := range stopChs {
+		if identity != first {
+			close(stopCh)
+		}
+	}
+}
+
+func TestRunLeaderElectionFollower(t *testing.T) {
+	record, _ := json.Marshal(resourcelock.LeaderElectionRecord{
+		HolderIdentity:       "replica-2",
+		LeaseDurationSeconds: 60,
+		AcquireTime:          metav1.Now(),
+		RenewTime:            metav1.Now(),
+	})
+	clientSet := fake.NewSimpleClientset(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:        "secrets-manager-leader",
+		Namespace:   "ns",
+		Annotations: map[string]string{resourcelock.LeaderElectionRecordAnnotationKey: string(record)},
+	}})
+	k8s := New(clientSet, log.New())
+
+	started := make(chan struct{}, 1)
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		k8s.RunLeaderElection(newFakeLeaderElectionConfig("replica-1", time.Minute), func(stop <-chan struct{}) {
+			started <- struct{}{}
+		}, stopCh)
+	}()
+
+	// The lease of the leader is not taken over while it is renewed
+	select {
+	case <-started:
+		t.Fatal("leadership acquired while another replica holds it")
+	case <-time.After(500 * time.Millisecond):
+	}
+	assert.Equal(t, 0.0, testutil.ToFloat64(leader))
+	close(stopCh)
+	<-stopped
+}
+
+func TestRunLeaderElectionInvalidConfig(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+	config := newFakeLeaderElectionConfig("replica-1", time.Second)
+	config.RenewDeadline = 2 * time.Second
+
+	err := k8s.RunLeaderElection(config, func(stop <-chan struct{}) {}, make(chan struct{}))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,119 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ManagedKeysAnnotation lists, as JSON, the data keys, labels and annotations written by secrets-manager on the
+// secrets merged into, so that the ones no longer written are removed on the next merge. Secrets and configmaps
+// replaced as a whole record their labels and annotations, so that the removal of one is noticed as a change
+const ManagedKeysAnnotation = "secrets-manager.tuenti.io/managed-keys"
+
+// ManagedKeys are the data keys, labels and annotations of a secret written by secrets-manager
+type ManagedKeys struct {
+	Data        []string `json:"data,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// ManagedKeys returns the keys of the secret written by secrets-manager on its last merge, if any
+func (s *Secret) ManagedKeys() ManagedKeys {
+	return parseManagedKeys(s.Annotations)
+}
+
+func parseManagedKeys(annotations map[string]string) ManagedKeys {
+	managed := ManagedKeys{}
+	if value, ok := annotations[ManagedKeysAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &managed); err != nil {
+			logger.Warnf("ignoring invalid %s annotation: %v", ManagedKeysAnnotation, err)
+		}
+	}
+	return managed
+}
+
+func sortedKeys(keys map[string]bool) []string {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// mergeStrings sets the entries of desired into current, removing the previously managed ones no longer desired,
+// and returns the merged map with the keys now managed
+func mergeStrings(current map[string]string, desired map[string]string, previous []string) (map[string]string, []string) {
+	merged := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for _, k := range previous {
+		delete(merged, k)
+	}
+	managed := make(map[string]bool, len(desired))
+	for k, v := range desired {
+		merged[k] = v
+		managed[k] = true
+	}
+	return merged, sortedKeys(managed)
+}
+
+// metadataKeys returns the sorted keys of metadata, but the ManagedKeysAnnotation
+func metadataKeys(metadata map[string]string) []string {
+	keys := make(map[string]bool, len(metadata))
+	for k := range metadata {
+		if k != ManagedKeysAnnotation {
+			keys[k] = true
+		}
+	}
+	return sortedKeys(keys)
+}
+
+// replacedKeysAnnotation returns annotations along with the ManagedKeysAnnotation recording the keys of the labels
+// and annotations of a secret or configmap replaced as a whole
+func replacedKeysAnnotation(labels map[string]string, annotations map[string]string) map[string]string {
+	return managedKeysAnnotation(annotations, ManagedKeys{Labels: metadataKeys(labels), Annotations: metadataKeys(annotations)})
+}
+
+// managedKeysAnnotation returns annotations along with the ManagedKeysAnnotation for keys
+func managedKeysAnnotation(annotations map[string]string, keys ManagedKeys) map[string]string {
+	value, _ := json.Marshal(keys)
+	withKeys := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		withKeys[k] = v
+	}
+	withKeys[ManagedKeysAnnotation] = string(value)
+	return withKeys
+}
+
+// mergeSecret writes the data, labels and annotations of desired into a copy of current, preserving the ones
+// not written by secrets-manager and removing the ones it wrote before but not anymore. The type of current is kept,
+// it can not be updated
+func mergeSecret(current *corev1.Secret, desired *corev1.Secret) *corev1.Secret {
+	previous := parseManagedKeys(current.Annotations)
+	merged := current.DeepCopy()
+	keys := ManagedKeys{}
+
+	merged.Data = make(map[string][]byte, len(current.Data)+len(desired.Data))
+	for k, v := range current.Data {
+		merged.Data[k] = v
+	}
+	for _, k := range previous.Data {
+		delete(merged.Data, k)
+	}
+	data := make(map[string]bool, len(desired.Data))
+	for k, v := range desired.Data {
+		merged.Data[k] = v
+		data[k] = true
+	}
+	keys.Data = sortedKeys(data)
+
+	merged.Labels, keys.Labels = mergeStrings(current.Labels, desired.Labels, previous.Labels)
+	var annotations map[string]string
+	annotations, keys.Annotations = mergeStrings(current.Annotations, desired.Annotations, previous.Annotations)
+	merged.Annotations = managedKeysAnnotation(annotations, keys)
+	return merged
+}
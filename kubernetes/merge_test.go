@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMergeSecret(t *testing.T) {
+	current := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "db",
+			Namespace:       "ns",
+			ResourceVersion: "7",
+			Labels:          map[string]string{"team": "a", "old": "x", ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{
+				"foreign":             "kept",
+				"gone":                "x",
+				ManagedKeysAnnotation: `{"data":["password","removed"],"labels":["managedBy","old"],"annotations":["gone"]}`,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"password": []byte("0ld"), "removed": []byte("x"), "foreign": []byte("kept")},
+	}
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "db",
+			Namespace:   "ns",
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue, "env": "prod"},
+			Annotations: map[string]string{"description": "db"},
+		},
+		Data: map[string][]byte{"password": []byte("n3w"), "user": []byte("admin")},
+	}
+
+	merged := mergeSecret(current, desired)
+
+	assert.Equal(t, "7", merged.ResourceVersion)
+	assert.Equal(t, map[string][]byte{"password": []byte("n3w"), "user": []byte("admin"), "foreign": []byte("kept")}, merged.Data)
+	assert.Equal(t, map[string]string{"team": "a", "env": "prod", ManagedByLabel: ManagedByValue}, merged.Labels)
+	assert.Equal(t, map[string]string{
+		"foreign":             "kept",
+		"description":         "db",
+		ManagedKeysAnnotation: `{"data":["password","user"],"labels":["env","managedBy"],"annotations":["description"]}`,
+	}, merged.Annotations)
+	// current is left untouched
+	assert.Equal(t, []byte("x"), current.Data["removed"])
+}
+
+func TestUpsertSecretMerge(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret-test",
+			Namespace:   "ns",
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue, "owner": "team-a"},
+			Annotations: map[string]string{"reloader": "true"},
+		},
+		Data: map[string][]byte{"extra": []byte("added by hand")},
+	})
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Labels = map[string]string{ManagedByLabel: ManagedByValue}
+	k8sSecret.Merge = true
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, []byte("added by hand"), secret.Data["extra"])
+	for k, v := range k8sSecret.Data {
+		assert.Equal(t, v, secret.Data[k])
+	}
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+	assert.Equal(t, "true", secret.Annotations["reloader"])
+
+	written, _ := k8s.GetSecret("ns", "secret-test")
+	assert.Equal(t, []string{ManagedByLabel}, written.ManagedKeys().Labels)
+	assert.Len(t, written.ManagedKeys().Data, len(k8sSecret.Data))
+}
+
+func TestUpsertSecretMergeCreate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k8s := New(client, log.New())
+
+	k8sSecret := NewFakeSecret("ns", "secret-test")
+	k8sSecret.Merge = true
+	assert.Nil(t, k8s.UpsertSecret(k8sSecret))
+
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-test", metav1.GetOptions{})
+	assert.Equal(t, k8sSecret.Data, secret.Data)
+	assert.Contains(t, secret.Annotations, ManagedKeysAnnotation)
+}
+
+func TestManagedKeysInvalid(t *testing.T) {
+	logger = log.New()
+	secret := &Secret{Annotations: map[string]string{ManagedKeysAnnotation: "not json"}}
+	assert.Equal(t, ManagedKeys{}, secret.ManagedKeys())
+}
@@ -18,9 +18,106 @@ var (
 		Name:      "secret_update_error_count",
 		Help:      "Error count when updating (and also creating) a secret in Kubernetes",
 	}, []string{"name", "namespace"})
+	secretDeleteErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "secret_delete_error_count",
+		Help:      "Error count when deleting a secret in Kubernetes",
+	}, []string{"name", "namespace"})
+	secretListErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "secret_list_error_count",
+		Help:      "Error count when listing secrets in Kubernetes",
+	}, []string{"namespace"})
+	secretNotOwnedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "secret_not_owned_count",
+		Help:      "Count of updates refused because the secret in Kubernetes is not managed by secrets-manager",
+	}, []string{"name", "namespace"})
+	eventCreateErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "event_create_error_count",
+		Help:      "Error count when creating or updating an event in Kubernetes",
+	}, []string{"reason", "namespace"})
+	secretDefinitionStatusUpdateErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "secret_definition_status_update_error_count",
+		Help:      "Error count when updating the status of a SecretDefinition custom resource in Kubernetes",
+	}, []string{"name", "namespace"})
+	configMapUpdateErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "configmap_update_error_count",
+		Help:      "Error count when updating (and also creating) a configmap in Kubernetes",
+	}, []string{"name", "namespace"})
+	configMapReadErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "configmap_read_error_count",
+		Help:      "Error count when reading a configmap written by secrets-manager from Kubernetes",
+	}, []string{"name", "namespace"})
+	configMapDeleteErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "configmap_delete_error_count",
+		Help:      "Error count when deleting a configmap in Kubernetes",
+	}, []string{"name", "namespace"})
+	configMapListErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "configmap_list_error_count",
+		Help:      "Error count when listing configmaps in Kubernetes",
+	}, []string{"namespace"})
+	configMapNotOwnedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "configmap_not_owned_count",
+		Help:      "Count of updates refused because the configmap in Kubernetes is not managed by secrets-manager",
+	}, []string{"name", "namespace"})
+	namespaceListErrorCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "namespace_list_error_count",
+		Help:      "Error count when listing namespaces in Kubernetes",
+	})
+	workloadListErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "workload_list_error_count",
+		Help:      "Error count when listing Deployments, StatefulSets or DaemonSets in Kubernetes",
+	}, []string{"kind", "namespace"})
+	workloadPatchErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "k8s",
+		Name:      "workload_patch_error_count",
+		Help:      "Error count when patching the pod template of a Deployment, StatefulSet or DaemonSet in Kubernetes",
+	}, []string{"kind", "name", "namespace"})
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Name:      "leader",
+		Help:      "Whether or not this replica is the leader syncing the secrets: 1 = leader; 0 = follower",
+	})
 )
 
 func init() {
 	prometheus.MustRegister(secretReadErrorCount)
 	prometheus.MustRegister(secretUpdateErrorCount)
+	prometheus.MustRegister(secretDeleteErrorCount)
+	prometheus.MustRegister(secretListErrorCount)
+	prometheus.MustRegister(secretNotOwnedCount)
+	prometheus.MustRegister(eventCreateErrorCount)
+	prometheus.MustRegister(secretDefinitionStatusUpdateErrorCount)
+	prometheus.MustRegister(configMapUpdateErrorCount)
+	prometheus.MustRegister(configMapReadErrorCount)
+	prometheus.MustRegister(configMapDeleteErrorCount)
+	prometheus.MustRegister(configMapListErrorCount)
+	prometheus.MustRegister(configMapNotOwnedCount)
+	prometheus.MustRegister(namespaceListErrorCount)
+	prometheus.MustRegister(workloadListErrorCount)
+	prometheus.MustRegister(workloadPatchErrorCount)
+	prometheus.MustRegister(leader)
 }
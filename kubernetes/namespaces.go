@@ -0,0 +1,74 @@
+package kubernetes
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Namespace represents a K8s namespace
+type Namespace struct {
+	Name   string
+	Labels map[string]string
+	// Terminating is true once the namespace is being deleted
+	Terminating bool
+}
+
+// WatchNamespaces caches the namespaces of the cluster for ListNamespaces, calling onChange every time a namespace
+// is added, changed or deleted, until stopCh is closed. The returned channel is closed once the watch stopped
+func (k *client) WatchNamespaces(onChange ChangeHandler, stopCh <-chan struct{}) <-chan struct{} {
+	namespaces := k.client.CoreV1().Namespaces()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return namespaces.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			logger.Debugf("watching namespaces from resourceVersion %s", options.ResourceVersion)
+			return namespaces.Watch(options)
+		},
+	}
+	informer := cache.NewSharedInformer(lw, &corev1.Namespace{}, 0)
+	k.namespacesMutex.Lock()
+	k.namespaces = informer
+	k.namespacesMutex.Unlock()
+	return runInformer(informer, func(obj interface{}) bool { return true }, onChange, stopCh)
+}
+
+// ListNamespaces returns the namespaces of the cluster, from the cache of WatchNamespaces once it is synced
+func (k *client) ListNamespaces() ([]Namespace, error) {
+	k.namespacesMutex.Lock()
+	informer := k.namespaces
+	k.namespacesMutex.Unlock()
+
+	if informer != nil && informer.HasSynced() {
+		objects := informer.GetStore().List()
+		namespaces := make([]Namespace, 0, len(objects))
+		for _, obj := range objects {
+			if namespace, ok := obj.(*corev1.Namespace); ok {
+				namespaces = append(namespaces, newNamespace(namespace))
+			}
+		}
+		return namespaces, nil
+	}
+
+	list, err := k.client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		namespaceListErrorCount.Inc()
+		return nil, err
+	}
+	namespaces := make([]Namespace, 0, len(list.Items))
+	for i := range list.Items {
+		namespaces = append(namespaces, newNamespace(&list.Items[i]))
+	}
+	return namespaces, nil
+}
+
+func newNamespace(namespace *corev1.Namespace) Namespace {
+	return Namespace{
+		Name:        namespace.Name,
+		Labels:      namespace.Labels,
+		Terminating: namespace.Status.Phase == corev1.NamespaceTerminating || namespace.DeletionTimestamp != nil,
+	}
+}
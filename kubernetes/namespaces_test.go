@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newFakeNamespace(name string, labels map[string]string, phase corev1.NamespacePhase) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, ResourceVersion: name},
+		Status:     corev1.NamespaceStatus{Phase: phase},
+	}
+}
+
+func TestListNamespaces(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		newFakeNamespace("prod", map[string]string{"env": "prod"}, corev1.NamespaceActive),
+		newFakeNamespace("old", nil, corev1.NamespaceTerminating),
+	)
+	k8s := New(clientSet, log.New())
+
+	namespaces, err := k8s.ListNamespaces()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Namespace{
+		{Name: "prod", Labels: map[string]string{"env": "prod"}},
+		{Name: "old", Terminating: true},
+	}, namespaces)
+}
+
+func TestWatchNamespaces(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newFakeNamespace("prod", nil, corev1.NamespaceActive))
+	k8s := New(clientSet, log.New())
+
+	changes := make(chan string, 10)
+	stopCh := make(chan struct{})
+	var done <-chan struct{}
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+	done = k8s.WatchNamespaces(func(resourceVersion string) { changes <- resourceVersion }, stopCh)
+	waitForChange(t, changes, "prod")
+
+	clientSet.CoreV1().Namespaces().Create(newFakeNamespace("staging", map[string]string{"env": "staging"}, corev1.NamespaceActive))
+	waitForChange(t, changes, "staging")
+
+	// Namespaces are listed from the cache once synced
+	clientSet.ClearActions()
+	namespaces, err := k8s.ListNamespaces()
+	assert.Nil(t, err)
+	assert.Len(t, namespaces, 2)
+	assert.Empty(t, clientSet.Actions())
+}
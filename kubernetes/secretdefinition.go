@@ -0,0 +1,125 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// SecretDefinitionKind is the kind of the SecretDefinition custom resource
+	SecretDefinitionKind = "SecretDefinition"
+	// SecretDefinitionResource is the resource name of the SecretDefinition custom resource
+	SecretDefinitionResource = "secretdefinitions"
+
+	// ConditionTrue means the resource is in the condition
+	ConditionTrue = "True"
+	// ConditionFalse means the resource is not in the condition
+	ConditionFalse = "False"
+)
+
+// SecretDefinitionGroupVersion is the API group and version of the SecretDefinition custom resource
+var SecretDefinitionGroupVersion = schema.GroupVersion{Group: "secrets-manager.tuenti.io", Version: "v1alpha1"}
+
+// SecretDefinition represents a SecretDefinition custom resource, namespaced or cluster scoped
+type SecretDefinition struct {
+	Name       string
+	Namespace  string
+	UID        string
+	Generation int64
+	// Spec is the JSON encoded spec of the resource
+	Spec []byte
+}
+
+// SecretDefinitionStatus represents the status subresource of a SecretDefinition custom resource
+type SecretDefinitionStatus struct {
+	ObservedGeneration int64                       `json:"observedGeneration"`
+	LastSyncTime       string                      `json:"lastSyncTime,omitempty"`
+	SyncedNamespaces   []string                    `json:"syncedNamespaces"`
+	Conditions         []SecretDefinitionCondition `json:"conditions"`
+}
+
+// SecretDefinitionCondition represents a condition of a SecretDefinition custom resource
+type SecretDefinitionCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+func (k *client) secretDefinitions(namespace string, subresource string) (dynamic.ResourceInterface, error) {
+	if k.dynamic == nil {
+		return nil, fmt.Errorf("no client for %s custom resources", SecretDefinitionKind)
+	}
+	resource := &metav1.APIResource{
+		Name:       SecretDefinitionResource + subresource,
+		Namespaced: namespace != "",
+		Kind:       SecretDefinitionKind,
+	}
+	return k.dynamic.Resource(resource, namespace), nil
+}
+
+// ListSecretDefinitions returns the SecretDefinition custom resources of every namespace
+func (k *client) ListSecretDefinitions() ([]SecretDefinition, error) {
+	resources, err := k.secretDefinitions(metav1.NamespaceAll, "")
+	if err != nil {
+		return nil, err
+	}
+	obj, err := resources.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	list, ok := obj.(*unstructured.UnstructuredList)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s list type %T", SecretDefinitionKind, obj)
+	}
+
+	definitions := make([]SecretDefinition, 0, len(list.Items))
+	for _, item := range list.Items {
+		spec, _, err := unstructured.NestedFieldCopy(item.Object, "spec")
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, SecretDefinition{
+			Name:       item.GetName(),
+			Namespace:  item.GetNamespace(),
+			UID:        string(item.GetUID()),
+			Generation: item.GetGeneration(),
+			Spec:       data,
+		})
+	}
+	return definitions, nil
+}
+
+// UpdateSecretDefinitionStatus replaces the status of a SecretDefinition custom resource through its status subresource
+func (k *client) UpdateSecretDefinitionStatus(definition *SecretDefinition, status *SecretDefinitionStatus) error {
+	err := k.patchSecretDefinitionStatus(definition, status)
+	if err != nil {
+		secretDefinitionStatusUpdateErrorCount.WithLabelValues(definition.Name, definition.Namespace).Inc()
+	}
+	return err
+}
+
+func (k *client) patchSecretDefinitionStatus(definition *SecretDefinition, status *SecretDefinitionStatus) error {
+	resources, err := k.secretDefinitions(definition.Namespace, "/status")
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	logger.Debugf("updating status of %s '%s/%s'", SecretDefinitionKind, definition.Namespace, definition.Name)
+	_, err = resources.Patch(definition.Name, types.MergePatchType, patch)
+	return err
+}
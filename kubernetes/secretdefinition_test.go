@@ -0,0 +1,95 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func newFakeDynamicClient() *fakedynamic.FakeClient {
+	return &fakedynamic.FakeClient{GroupVersion: SecretDefinitionGroupVersion, Fake: &clientgotesting.Fake{}}
+}
+
+func TestListSecretDefinitions(t *testing.T) {
+	dynamicClient := newFakeDynamicClient()
+	dynamicClient.AddReactor("list", SecretDefinitionResource, func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		item := unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": SecretDefinitionGroupVersion.String(),
+			"kind":       SecretDefinitionKind,
+			"metadata":   map[string]interface{}{"name": "db", "namespace": "ns", "uid": "1234", "generation": int64(3)},
+			"spec": map[string]interface{}{
+				"type": "Opaque",
+				"data": map[string]interface{}{"password": map[string]interface{}{"path": "secret/data/db", "key": "password"}},
+			},
+		}}
+		return true, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{item}}, nil
+	})
+
+	k8s := NewWithDynamic(fake.NewSimpleClientset(), dynamicClient, log.New())
+	definitions, err := k8s.ListSecretDefinitions()
+
+	assert.Nil(t, err)
+	assert.Len(t, definitions, 1)
+	assert.Equal(t, "db", definitions[0].Name)
+	assert.Equal(t, "ns", definitions[0].Namespace)
+	assert.Equal(t, "1234", definitions[0].UID)
+	assert.Equal(t, int64(3), definitions[0].Generation)
+	assert.JSONEq(t, `{"type":"Opaque","data":{"password":{"path":"secret/data/db","key":"password"}}}`, string(definitions[0].Spec))
+}
+
+func TestListSecretDefinitionsWithoutDynamicClient(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+	_, err := k8s.ListSecretDefinitions()
+	assert.NotNil(t, err)
+}
+
+func TestUpdateSecretDefinitionStatus(t *testing.T) {
+	secretDefinitionStatusUpdateErrorCount.Reset()
+	dynamicClient := newFakeDynamicClient()
+	dynamicClient.AddReactor("patch", SecretDefinitionResource+"/status", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, &unstructured.Unstructured{}, nil
+	})
+
+	k8s := NewWithDynamic(fake.NewSimpleClientset(), dynamicClient, log.New())
+	status := &SecretDefinitionStatus{
+		ObservedGeneration: 3,
+		SyncedNamespaces:   []string{"ns"},
+		Conditions:         []SecretDefinitionCondition{{Type: "Synced", Status: ConditionTrue}},
+	}
+	err := k8s.UpdateSecretDefinitionStatus(&SecretDefinition{Name: "db", Namespace: "ns"}, status)
+	assert.Nil(t, err)
+
+	actions := dynamicClient.Actions()
+	assert.Len(t, actions, 1)
+	patch := actions[0].(clientgotesting.PatchAction)
+	assert.Equal(t, "db", patch.GetName())
+	assert.Equal(t, "ns", patch.GetNamespace())
+
+	var patched map[string]SecretDefinitionStatus
+	assert.Nil(t, json.Unmarshal(patch.GetPatch(), &patched))
+	assert.Equal(t, *status, patched["status"])
+}
+
+func TestUpdateSecretDefinitionStatusError(t *testing.T) {
+	secretDefinitionStatusUpdateErrorCount.Reset()
+	dynamicClient := newFakeDynamicClient()
+	dynamicClient.AddReactor("patch", SecretDefinitionResource+"/status", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	k8s := NewWithDynamic(fake.NewSimpleClientset(), dynamicClient, log.New())
+	err := k8s.UpdateSecretDefinitionStatus(&SecretDefinition{Name: "db", Namespace: "ns"}, &SecretDefinitionStatus{})
+	assert.NotNil(t, err)
+
+	metric, _ := secretDefinitionStatusUpdateErrorCount.GetMetricWithLabelValues("db", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric))
+}
@@ -0,0 +1,123 @@
+package kubernetes
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ChangeHandler is called with the resourceVersion of the last change seen by a watch
+type ChangeHandler func(resourceVersion string)
+
+// WatchConfigMap calls onChange every time the ConfigMap changes, until stopCh is closed
+func (k *client) WatchConfigMap(name string, namespace string, onChange ChangeHandler, stopCh <-chan struct{}) {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	configMaps := k.client.CoreV1().ConfigMaps(namespace)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return configMaps.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			logger.Debugf("watching configmap '%s/%s' from resourceVersion %s", namespace, name, options.ResourceVersion)
+			return configMaps.Watch(options)
+		},
+	}
+	informer := cache.NewSharedInformer(lw, &corev1.ConfigMap{}, 0)
+	runInformer(informer, func(obj interface{}) bool {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		return ok && configMap.Name == name
+	}, onChange, stopCh)
+}
+
+// WatchSecretDefinitions calls onChange every time any SecretDefinition custom resource changes, until stopCh is closed
+func (k *client) WatchSecretDefinitions(onChange ChangeHandler, stopCh <-chan struct{}) error {
+	resources, err := k.secretDefinitions(metav1.NamespaceAll, "")
+	if err != nil {
+		return err
+	}
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return resources.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			logger.Debugf("watching %s custom resources from resourceVersion %s", SecretDefinitionKind, options.ResourceVersion)
+			return resources.Watch(options)
+		},
+	}
+	informer := cache.NewSharedInformer(lw, &unstructured.Unstructured{}, 0)
+	runInformer(informer, func(obj interface{}) bool { return true }, onChange, stopCh)
+	return nil
+}
+
+// runInformer runs informer until stopCh is closed, calling onChange for the events of the objects accepted by
+// filter. The informer lists and watches again after a disconnect. Changes seen while onChange runs are
+// coalesced into a single call with the last resourceVersion seen. The returned channel is closed once the
+// informer and the calls to onChange have stopped
+func runInformer(informer cache.SharedInformer, filter func(obj interface{}) bool, onChange ChangeHandler, stopCh <-chan struct{}) <-chan struct{} {
+	var mutex sync.Mutex
+	var resourceVersion string
+	changed := make(chan struct{}, 1)
+
+	notify := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if !filter(obj) {
+			return
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			logger.Warnf("ignoring change of unexpected object %T: %v", obj, err)
+			return
+		}
+		mutex.Lock()
+		resourceVersion = accessor.GetResourceVersion()
+		mutex.Unlock()
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(oldObj, newObj interface{}) { notify(newObj) },
+		DeleteFunc: notify,
+	})
+
+	var running sync.WaitGroup
+	running.Add(2)
+	go func() {
+		defer running.Done()
+		informer.Run(stopCh)
+	}()
+	go func() {
+		defer running.Done()
+		for {
+			select {
+			case <-changed:
+				mutex.Lock()
+				lastResourceVersion := resourceVersion
+				mutex.Unlock()
+				onChange(lastResourceVersion)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	return done
+}
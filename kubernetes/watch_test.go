@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+const watchTimeout = 5 * time.Second
+
+// waitForChange waits for a change notified with the expected resourceVersion, skipping any other
+func waitForChange(t *testing.T, changes <-chan string, expected string) {
+	timeout := time.After(watchTimeout)
+	for {
+		select {
+		case resourceVersion := <-changes:
+			if resourceVersion == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no change with resourceVersion %s notified", expected)
+		}
+	}
+}
+
+func newFakeConfigMap(name string, resourceVersion string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: resourceVersion},
+		Data:       map[string]string{"config": "secretDefinitions: []"},
+	}
+}
+
+func TestWatchConfigMap(t *testing.T) {
+	client := fake.NewSimpleClientset(newFakeConfigMap("cm", "1"))
+	k8s := New(client, log.New())
+
+	changes := make(chan string, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	k8s.WatchConfigMap("cm", "default", func(resourceVersion string) { changes <- resourceVersion }, stopCh)
+
+	waitForChange(t, changes, "1")
+
+	client.CoreV1().ConfigMaps("default").Update(newFakeConfigMap("cm", "2"))
+	waitForChange(t, changes, "2")
+
+	// Other configmaps are ignored
+	client.CoreV1().ConfigMaps("default").Create(newFakeConfigMap("other", "3"))
+	client.CoreV1().ConfigMaps("default").Update(newFakeConfigMap("cm", "4"))
+	resourceVersion := <-changes
+	assert.Equal(t, "4", resourceVersion)
+}
+
+func TestWatchConfigMapReconnects(t *testing.T) {
+	client := fake.NewSimpleClientset(newFakeConfigMap("cm", "1"))
+	watchers := make(chan *watch.FakeWatcher, 10)
+	client.PrependWatchReactor("configmaps", func(action clientgotesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+	k8s := New(client, log.New())
+
+	changes := make(chan string, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	k8s.WatchConfigMap("cm", "default", func(resourceVersion string) { changes <- resourceVersion }, stopCh)
+	waitForChange(t, changes, "1")
+
+	// Close the first watch, as the API server does on timeouts
+	(<-watchers).Stop()
+
+	select {
+	case watcher := <-watchers:
+		watcher.Modify(newFakeConfigMap("cm", "2"))
+		waitForChange(t, changes, "2")
+	case <-time.After(watchTimeout):
+		t.Fatal("configmap not watched again after the watch was closed")
+	}
+}
+
+func newFakeSecretDefinition(name string, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": SecretDefinitionGroupVersion.String(),
+		"kind":       SecretDefinitionKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "ns", "resourceVersion": resourceVersion},
+	}}
+}
+
+func TestWatchSecretDefinitions(t *testing.T) {
+	dynamicClient := newFakeDynamicClient()
+	dynamicClient.AddReactor("list", SecretDefinitionResource, func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*newFakeSecretDefinition("db", "5")}}, nil
+	})
+	watcher := watch.NewFake()
+	dynamicClient.AddWatchReactor(SecretDefinitionResource, clientgotesting.DefaultWatchReactor(watcher, nil))
+	k8s := NewWithDynamic(fake.NewSimpleClientset(), dynamicClient, log.New())
+
+	changes := make(chan string, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	err := k8s.WatchSecretDefinitions(func(resourceVersion string) { changes <- resourceVersion }, stopCh)
+	assert.Nil(t, err)
+	waitForChange(t, changes, "5")
+
+	watcher.Add(newFakeSecretDefinition("cache", "6"))
+	waitForChange(t, changes, "6")
+
+	watcher.Delete(newFakeSecretDefinition("db", "7"))
+	waitForChange(t, changes, "7")
+}
+
+func TestWatchSecretDefinitionsWithoutDynamicClient(t *testing.T) {
+	k8s := New(fake.NewSimpleClientset(), log.New())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.NotNil(t, k8s.WatchSecretDefinitions(func(string) {}, stopCh))
+}
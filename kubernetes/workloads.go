@@ -0,0 +1,145 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Kinds of the workloads whose pods can be restarted by patching their pod template
+const (
+	DeploymentKind  = "Deployment"
+	StatefulSetKind = "StatefulSet"
+	DaemonSetKind   = "DaemonSet"
+)
+
+// Workload represents a Deployment, StatefulSet or DaemonSet
+type Workload struct {
+	Kind      string
+	Name      string
+	Namespace string
+	// Secrets are the names of the secrets its pods reference through env, envFrom or volumes
+	Secrets []string
+	// Annotations of its pod template
+	Annotations map[string]string
+}
+
+// ListWorkloads returns the Deployments, StatefulSets and DaemonSets of namespace
+func (k *client) ListWorkloads(namespace string) ([]Workload, error) {
+	apps := k.client.AppsV1()
+	workloads := make([]Workload, 0)
+
+	deployments, err := apps.Deployments(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		workloadListErrorCount.WithLabelValues(DeploymentKind, namespace).Inc()
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		workloads = append(workloads, newWorkload(DeploymentKind, d.ObjectMeta, d.Spec.Template))
+	}
+
+	statefulSets, err := apps.StatefulSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		workloadListErrorCount.WithLabelValues(StatefulSetKind, namespace).Inc()
+		return nil, err
+	}
+	for _, s := range statefulSets.Items {
+		workloads = append(workloads, newWorkload(StatefulSetKind, s.ObjectMeta, s.Spec.Template))
+	}
+
+	daemonSets, err := apps.DaemonSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		workloadListErrorCount.WithLabelValues(DaemonSetKind, namespace).Inc()
+		return nil, err
+	}
+	for _, d := range daemonSets.Items {
+		workloads = append(workloads, newWorkload(DaemonSetKind, d.ObjectMeta, d.Spec.Template))
+	}
+	return workloads, nil
+}
+
+// AnnotatePodTemplate sets an annotation on the pod template of the workload, rolling its pods when the
+// value changes
+func (k *client) AnnotatePodTemplate(workload Workload, key string, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{key: value},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("annotating pod template of %s '%s/%s' with %s=%s", workload.Kind, workload.Namespace, workload.Name, key, value)
+	apps := k.client.AppsV1()
+	switch workload.Kind {
+	case DeploymentKind:
+		_, err = apps.Deployments(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	case StatefulSetKind:
+		_, err = apps.StatefulSets(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	case DaemonSetKind:
+		_, err = apps.DaemonSets(workload.Namespace).Patch(workload.Name, types.StrategicMergePatchType, patch)
+	default:
+		logger.Warnf("unable to annotate pod template of unknown workload kind %s", workload.Kind)
+		return nil
+	}
+	if err != nil {
+		workloadPatchErrorCount.WithLabelValues(workload.Kind, workload.Name, workload.Namespace).Inc()
+		return err
+	}
+	return nil
+}
+
+func newWorkload(kind string, meta metav1.ObjectMeta, template corev1.PodTemplateSpec) Workload {
+	return Workload{
+		Kind:        kind,
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Secrets:     podSecrets(template.Spec),
+		Annotations: template.Annotations,
+	}
+}
+
+// podSecrets returns the sorted names of the secrets referenced by the env, envFrom or volumes of a pod
+func podSecrets(spec corev1.PodSpec) []string {
+	seen := make(map[string]bool)
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			seen[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					seen[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				seen[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				seen[envFrom.SecretRef.Name] = true
+			}
+		}
+	}
+
+	secrets := make([]string, 0, len(seen))
+	for name := range seen {
+		secrets = append(secrets, name)
+	}
+	sort.Strings(secrets)
+	return secrets
+}
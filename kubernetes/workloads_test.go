@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestListWorkloads(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"team": "payments"}},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{
+						EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "migrations"}}}},
+					}},
+					Containers: []corev1.Container{{
+						Env: []corev1.EnvVar{
+							{Name: "PLAIN", Value: "value"},
+							{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"}}},
+						},
+					}},
+				},
+			}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "ns"},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}}},
+					{Name: "all", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+						{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}},
+					}}}},
+				},
+			}}},
+		},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "ns"}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "other"}},
+	)
+	k8s := New(client, log.New())
+
+	workloads, err := k8s.ListWorkloads("ns")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Workload{
+		{Kind: DeploymentKind, Name: "web", Namespace: "ns", Secrets: []string{"db", "migrations"}, Annotations: map[string]string{"team": "payments"}},
+		{Kind: StatefulSetKind, Name: "cache", Namespace: "ns", Secrets: []string{"db", "tls"}},
+		{Kind: DaemonSetKind, Name: "agent", Namespace: "ns", Secrets: []string{}},
+	}, workloads)
+}
+
+func TestListWorkloadsError(t *testing.T) {
+	workloadListErrorCount.Reset()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "statefulsets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	k8s := New(client, log.New())
+
+	_, err := k8s.ListWorkloads("ns")
+
+	assert.NotNil(t, err)
+	metric, _ := workloadListErrorCount.GetMetricWithLabelValues(StatefulSetKind, "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric))
+}
+
+func TestAnnotatePodTemplate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("patch", "*", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	k8s := New(client, log.New())
+
+	for _, kind := range []string{DeploymentKind, StatefulSetKind, DaemonSetKind} {
+		err := k8s.AnnotatePodTemplate(Workload{Kind: kind, Name: "web", Namespace: "ns"}, "checksum", "abc")
+		assert.Nil(t, err)
+	}
+
+	actions := client.Actions()
+	assert.Len(t, actions, 3)
+	for i, resource := range []string{"deployments", "statefulsets", "daemonsets"} {
+		patch := actions[i].(clientgotesting.PatchAction)
+		assert.Equal(t, resource, patch.GetResource().Resource)
+		assert.Equal(t, "web", patch.GetName())
+		assert.Equal(t, "ns", patch.GetNamespace())
+
+		var patched map[string]interface{}
+		assert.Nil(t, json.Unmarshal(patch.GetPatch(), &patched))
+		assert.Equal(t, map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"annotations": map[string]interface{}{"checksum": "abc"},
+					},
+				},
+			},
+		}, patched)
+	}
+}
+
+func TestAnnotatePodTemplateError(t *testing.T) {
+	workloadPatchErrorCount.Reset()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("patch", "deployments", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	k8s := New(client, log.New())
+
+	err := k8s.AnnotatePodTemplate(Workload{Kind: DeploymentKind, Name: "web", Namespace: "ns"}, "checksum", "abc")
+
+	assert.NotNil(t, err)
+	metric, _ := workloadPatchErrorCount.GetMetricWithLabelValues(DeploymentKind, "web", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric))
+}
@@ -16,6 +16,7 @@ import (
 	k8s "github.com/tuenti/secrets-manager/kubernetes"
 	"github.com/tuenti/secrets-manager/secrets-manager"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -25,17 +26,49 @@ import (
 // To be filled from build ldflags
 var version string
 
-func newK8sClientSet() (*kubernetes.Clientset, error) {
+const httpShutdownTimeout = 5 * time.Second
+
+func newK8sClientSet() (*kubernetes.Clientset, *rest.Config, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Make sure the API server is reachable
+	if _, err = clientSet.Discovery().ServerVersion(); err != nil {
+		return nil, nil, err
 	}
-	return clientSet, nil
+	return clientSet, config, nil
+}
+
+// newSecretDefinitionClient builds a dynamic client for the SecretDefinition custom resources
+func newSecretDefinitionClient(config *rest.Config) (dynamic.Interface, error) {
+	definitionConfig := *config
+	definitionConfig.GroupVersion = &k8s.SecretDefinitionGroupVersion
+	definitionConfig.APIPath = "/apis"
+	return dynamic.NewClient(&definitionConfig)
+}
+
+// waitForK8sClientSet retries building the k8s client with newClientSet with the startup backoff until timeout elapses
+func waitForK8sClientSet(timeout time.Duration, newClientSet func() (*kubernetes.Clientset, *rest.Config, error), logger *log.Logger) (*kubernetes.Clientset, *rest.Config, error) {
+	var clientSet *kubernetes.Clientset
+	var config *rest.Config
+	err := backend.StartupBackoff.Retry(timeout, func() error {
+		var err error
+		clientSet, config, err = newClientSet()
+		return err
+	}, func(wait time.Duration, err error) {
+		logger.Warnf("kubernetes API is not reachable yet, retrying in %v: %v", wait, err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientSet, config, nil
 }
 
 func main() {
@@ -44,23 +77,51 @@ func main() {
 
 	backendCfg := backend.Config{}
 	secretsManagerCfg := secretsmanager.Config{}
+	leaderElectionCfg := k8s.LeaderElectionConfig{}
 	selectedBackend := flag.String("backend", "vault", "Selected backend. Only vault supported")
 	logLevel := flag.String("log.level", "warn", "Minimum log level")
 	logFormat := flag.String("log.format", "text", "Log format, one of text or json")
 	versionFlag := flag.Bool("version", false, "Display Secret Manager version")
 	addr := flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
 
+	flag.StringVar(&secretsManagerCfg.Source, "config.source", secretsmanager.ConfigMapSource, "Source of the secret definitions, one of configmap or crd")
+	flag.StringVar(&secretsManagerCfg.PrunePolicy, "config.prune-policy", secretsmanager.PruneNone, "What to do with the secrets and configmaps managed by secrets-manager that are no longer defined, one of none, report, orphan or delete")
+	flag.DurationVar(&secretsManagerCfg.PruneInterval, "config.prune-interval", 5*time.Minute, "Time between two looks for secrets no longer defined")
+	flag.DurationVar(&secretsManagerCfg.PruneGracePeriod, "config.prune-grace-period", time.Hour, "How long a secret must be no longer defined before it is orphaned or deleted")
+	flag.BoolVar(&secretsManagerCfg.PruneDryRun, "config.prune-dry-run", false, "Log the secrets that would be orphaned or deleted instead of doing it")
+	flag.StringVar(&secretsManagerCfg.ChecksumKeySecret, "config.checksum-key-secret", "secrets-manager-checksum-key", "Name of the secret holding the key of the checksums of the data of secrets, in the namespace of the config map. Created with a random key if it does not exist")
+	namespacePathPrefixes := flag.String("config.namespace-path-prefixes", "", "Backend path prefixes the namespaced SecretDefinition custom resources of every namespace can read (format: <namespace>=<prefix>[,<prefix>...][;<namespace>=...]). Unrestricted if empty")
 	flag.StringVar(&secretsManagerCfg.ConfigMap, "config.config-map", "secrets-manager-config", "Name of the config Map with Secrets Manager settings (format: [<namespace>/]<name>) ")
-	flag.DurationVar(&secretsManagerCfg.BackendScrapeInterval, "config.backend-timeout", 5*time.Second, "Backend connection timeout")
+	startupTimeout := flag.Duration("config.startup-timeout", 5*time.Minute, "Maximum time to wait for the backend and Kubernetes to be ready at startup")
+	flag.DurationVar(&backendCfg.BackendTimeout, "config.backend-timeout", 5*time.Second, "Backend connection timeout")
 	flag.DurationVar(&secretsManagerCfg.BackendScrapeInterval, "config.backend-scrape-interval", 15*time.Second, "Scraping secrets from backend interval")
-	flag.DurationVar(&secretsManagerCfg.ConfigMapRefreshInterval, "config.configmap-refresh-interval", 15*time.Second, "ConfigMap refresh interval")
+	flag.DurationVar(&secretsManagerCfg.FullResyncInterval, "config.full-resync-interval", 5*time.Minute, "Longest time to skip syncing a secret whose KV version 2 backend versions are unchanged. 0 syncs every secret on every scrape")
+
+	leaderElection := flag.Bool("leader-election.enabled", false, "Elect a leader among the replicas, only the leader syncs the secrets")
+	flag.StringVar(&leaderElectionCfg.Namespace, "leader-election.namespace", "default", "Namespace of the configmap used as the leader election lock")
+	flag.StringVar(&leaderElectionCfg.Name, "leader-election.name", "secrets-manager-leader", "Name of the configmap used as the leader election lock")
+	flag.StringVar(&leaderElectionCfg.Identity, "leader-election.identity", "", "Identity of this replica in the leader election lock. Defaults to the hostname")
+	flag.DurationVar(&leaderElectionCfg.LeaseDuration, "leader-election.lease-duration", 15*time.Second, "How long the followers wait before taking over a leadership that is not renewed")
+	flag.DurationVar(&leaderElectionCfg.RenewDeadline, "leader-election.renew-deadline", 10*time.Second, "How long the leader retries renewing its leadership before giving it up")
+	flag.DurationVar(&leaderElectionCfg.RetryPeriod, "leader-election.retry-period", 2*time.Second, "Time between two attempts to acquire or renew the leadership")
 
 	flag.StringVar(&backendCfg.VaultURL, "vault.url", "https://127.0.0.1:8200", "Vault address. VAULT_ADDR environment would take precedence.")
 	flag.StringVar(&backendCfg.VaultToken, "vault.token", "", "Vault token. VAULT_TOKEN environment would take precedence.")
-	flag.Int64Var(&backendCfg.VaultMaxTokenTTL, "vault.max-token-ttl", 300, "Max seconds to consider a token expired.")
-	flag.DurationVar(&backendCfg.VaultTokenPollingPeriod, "vault.token-polling-period", 15*time.Second, "Polling interval to check token expiration time.")
-	flag.IntVar(&backendCfg.VaultRenewTTLIncrement, "vault.renew-ttl-increment", 600, "TTL time for renewed token.")
 	flag.StringVar(&backendCfg.VaultEngine, "vault.engine", "kv2", "Vault secret engine. Only KV version 1 and 2 supported")
+	flag.StringVar(&backendCfg.VaultAuthMethod, "vault.auth-method", "", "Vault auth method to login with when the token can not be renewed anymore. Only kubernetes supported")
+	flag.StringVar(&backendCfg.VaultAuthRole, "vault.auth-role", "", "Role to login with through vault.auth-method.")
+	flag.StringVar(&backendCfg.VaultAuthMountPath, "vault.auth-mount-path", "", "Path vault.auth-method is mounted at. Defaults to the auth method name.")
+	flag.StringVar(&backendCfg.VaultWrappedToken, "vault.wrapped-token", "", "Vault response-wrapping token, unwrapped at startup to get the Vault token.")
+	flag.StringVar(&backendCfg.VaultWrappedTokenFile, "vault.wrapped-token-file", "", "File to read the Vault response-wrapping token from. It takes precedence over vault.wrapped-token.")
+	flag.StringVar(&backendCfg.VaultWrappedTokenPath, "vault.wrapped-token-creation-path", "auth/token/create", "Expected creation path of the Vault response-wrapping token.")
+	flag.BoolVar(&backendCfg.VaultRevokeOnShutdown, "vault.revoke-on-shutdown", false, "Revoke the Vault token on shutdown, if it was unwrapped or obtained through vault.auth-method.")
+
+	// Deprecated flags, token renewal is scheduled from the token TTL
+	flag.Int64("vault.max-token-ttl", 300, "Deprecated: ignored, the token is renewed once two thirds of its TTL have elapsed.")
+	flag.Duration("vault.token-polling-period", 15*time.Second, "Deprecated: ignored, the token is renewed once two thirds of its TTL have elapsed.")
+	flag.Int("vault.renew-ttl-increment", 600, "Deprecated: ignored, the token is renewed with the TTL it was created with.")
+	// Deprecated flags, the config source is watched instead of polled
+	flag.Duration("config.configmap-refresh-interval", 15*time.Second, "Deprecated: ignored, the config source is watched for changes.")
 	flag.Parse()
 
 	if *versionFlag {
@@ -98,16 +159,41 @@ func main() {
 		backendCfg.VaultToken = os.Getenv("VAULT_TOKEN")
 	}
 
+	pathPrefixes, err := secretsmanager.ParseNamespacePathPrefixes(*namespacePathPrefixes)
+	if err != nil {
+		logger.Errorf("could not parse the namespace path prefixes: %v", err)
+		os.Exit(1)
+	}
+	secretsManagerCfg.NamespacePathPrefixes = pathPrefixes
+	if secretsManagerCfg.Source == secretsmanager.CustomResourceSource && secretsManagerCfg.NamespacePathPrefixes == nil {
+		logger.Warnf("config.namespace-path-prefixes is not set, namespaced SecretDefinitions can read any backend path secrets-manager can read")
+	}
+
+	if *leaderElection && leaderElectionCfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logger.Errorf("could not get the hostname as leader election identity: %v", err)
+			os.Exit(1)
+		}
+		leaderElectionCfg.Identity = hostname
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Serve metrics while waiting for the backend and Kubernetes, reporting not ready until then
+	ready := &readiness{}
+	leader := &leadership{}
+	srv := startHttpServer(*addr, ready, leader, logger)
+
+	backendCfg.StartupTimeout = *startupTimeout
 	backendClient, err := backend.NewBackendClient(ctx, *selectedBackend, logger, backendCfg)
 	if err != nil {
 		logger.Errorf("could not build backend client: %v", err)
 		os.Exit(1)
 	}
 
-	clientSet, err := newK8sClientSet()
+	clientSet, k8sConfig, err := waitForK8sClientSet(*startupTimeout, newK8sClientSet, logger)
 
 	if err != nil {
 		logger.Errorf("could not build k8s client: %v", err)
@@ -115,6 +201,14 @@ func main() {
 	}
 
 	kubernetes := k8s.New(clientSet, logger)
+	if secretsManagerCfg.Source == secretsmanager.CustomResourceSource {
+		dynamicClient, err := newSecretDefinitionClient(k8sConfig)
+		if err != nil {
+			logger.Errorf("could not build k8s client for secret definitions: %v", err)
+			os.Exit(1)
+		}
+		kubernetes = k8s.NewWithDynamic(clientSet, dynamicClient, logger)
+	}
 	secretsManager, err := secretsmanager.New(ctx, secretsManagerCfg, kubernetes, *backendClient, logger)
 
 	if err != nil {
@@ -129,9 +223,32 @@ func main() {
 		syscall.SIGQUIT)
 
 	wg.Add(1)
-	go secretsManager.Start(ctx)
+	go func() {
+		defer wg.Done()
+		if !*leaderElection {
+			leader.startLeading()
+			secretsManager.Start(ctx)
+			return
+		}
 
-	srv := startHttpServer(*addr, logger)
+		// Followers keep the secret definitions loaded, only the leader syncs them
+		secretsManager.Watch(ctx)
+		err := kubernetes.RunLeaderElection(leaderElectionCfg, func(stop <-chan struct{}) {
+			leaderCtx, cancelLeader := context.WithCancel(ctx)
+			leader.startLeading()
+			go func() {
+				<-stop
+				leader.stopLeading()
+				cancelLeader()
+			}()
+			secretsManager.Sync(leaderCtx)
+		}, ctx.Done())
+		if err != nil {
+			logger.Errorf("could not run leader election: %v", err)
+			os.Exit(1)
+		}
+	}()
+	ready.setReady()
 
 	for {
 		select {
@@ -143,22 +260,34 @@ func main() {
 		break
 	}
 	wg.Wait()
+
+	if err := (*backendClient).Close(); err != nil {
+		logger.Errorf("could not close backend client: %v", err)
+	}
 }
 
 func shutdownHttpServer(srv *http.Server, logger *log.Logger) {
 	logger.Infof("[main] Stopping HTTP server")
 
-	if err := srv.Shutdown(nil); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
 		logger.Errorf("ListenAndServe(): %s", err)
 	} else {
 		logger.Infof("[main] Stopped HTTP server")
 	}
 }
 
-func startHttpServer(addr string, logger *log.Logger) *http.Server {
+func startHttpServer(addr string, ready *readiness, leader *leadership, logger *log.Logger) *http.Server {
 	srv := &http.Server{Addr: addr}
 
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/ready", ready)
+	http.Handle("/leader", leader)
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
 
 	go func() {
 		logger.Infof("Starting HTTP server listening on %v", addr)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/backend"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestWaitForK8sClientSet(t *testing.T) {
+	backend.StartupBackoff.Initial = time.Millisecond
+	defer func() { backend.StartupBackoff.Initial = time.Second }()
+
+	attempts := 0
+	expected := &kubernetes.Clientset{}
+	clientSet, config, err := waitForK8sClientSet(time.Second, func() (*kubernetes.Clientset, *rest.Config, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, nil, errors.New("connection refused")
+		}
+		return expected, &rest.Config{Host: "https://kubernetes"}, nil
+	}, log.New())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.True(t, clientSet == expected)
+	assert.Equal(t, "https://kubernetes", config.Host)
+}
+
+func TestWaitForK8sClientSetTimeout(t *testing.T) {
+	backend.StartupBackoff.Initial = time.Millisecond
+	defer func() { backend.StartupBackoff.Initial = time.Second }()
+
+	clientSet, config, err := waitForK8sClientSet(20*time.Millisecond, func() (*kubernetes.Clientset, *rest.Config, error) {
+		return nil, nil, errors.New("connection refused")
+	}, log.New())
+
+	assert.EqualError(t, err, "connection refused")
+	assert.Nil(t, clientSet)
+	assert.Nil(t, config)
+}
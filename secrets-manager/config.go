@@ -4,14 +4,32 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/tuenti/secrets-manager/errors"
 	"gopkg.in/yaml.v2"
 )
 
 // Config holds the general global Secret manager config
 type Config struct {
-	ConfigMapRefreshInterval time.Duration
-	BackendScrapeInterval    time.Duration
-	ConfigMap                string
+	BackendScrapeInterval time.Duration
+	// FullResyncInterval is the longest time a secret is left unsynced while its backend versions are unchanged
+	FullResyncInterval time.Duration
+	ConfigMap          string
+	// Source of the secret definitions, ConfigMapSource or CustomResourceSource
+	Source string
+	// PrunePolicy is applied to the secrets and configmaps no longer defined, one of PruneNone, PruneReport, PruneOrphan or PruneDelete
+	PrunePolicy string
+	// PruneInterval is the time between two looks for secrets no longer defined
+	PruneInterval time.Duration
+	// PruneGracePeriod is how long a secret must be no longer defined before it is orphaned or deleted
+	PruneGracePeriod time.Duration
+	// PruneDryRun logs the secrets that would be orphaned or deleted instead of doing it
+	PruneDryRun bool
+	// ChecksumKeySecret is the secret holding the key of the checksums of the data of secrets, in the namespace of
+	// the configmap. It is created with a random key if it does not exist
+	ChecksumKeySecret string
+	// NamespacePathPrefixes are the backend path prefixes the namespaced SecretDefinition custom resources of every
+	// namespace can read, see ParseNamespacePathPrefixes. Unrestricted if nil
+	NamespacePathPrefixes map[string][]string
 }
 
 // SecretDefinitions is a list of SecretDefinitions
@@ -19,15 +37,80 @@ type SecretDefinitions []SecretDefinition
 
 // SecretDefinition defines how to generate a secret in K8s from remote secrets backends
 type SecretDefinition struct {
-	// Name for the secret in K8s
+	// Name for the secret in K8s. With Mirror, the prefix of the name of every mirrored secret
 	Name string `yaml:"name"`
 	// Namespaces is the list of namespaces where the secret is going to be created
 	Namespaces []string `yaml:"namespaces"`
+	// NamespaceSelector adds the namespaces whose labels match it to Namespaces, including the ones created later.
+	// Optional
+	NamespaceSelector *NamespaceSelector `yaml:"namespaceSelector,omitempty"`
+	// ExcludeNamespaces are never written, even if listed in Namespaces or matched by NamespaceSelector. Optional
+	ExcludeNamespaces []string `yaml:"excludeNamespaces,omitempty"`
 	// Type is the type of K8s Secret ("Opaque", "kubernetes.io/tls", ...)
 	Type string `yaml:"type"`
+	// Kind of the object to write, Secret or ConfigMap for values that are not sensitive. ConfigMaps are pruned like
+	// secrets once no longer defined. Optional, Secret by default
+	Kind string `yaml:"kind,omitempty"`
 	// Data is a dictionary which keys are the name of each entry in the K8s Secret data and the value is
 	// the Datasource (from backend) for that entry
 	Data map[string]Datasource `yaml:"data"` //optional?
+	// BackendMetadata selects the backend custom metadata keys to copy into the K8s Secret. Optional
+	BackendMetadata BackendMetadata `yaml:"backendMetadata,omitempty"`
+	// Mirror creates a K8s Secret for every secret under a backend path, instead of using Data. Optional
+	Mirror *Mirror `yaml:"mirror,omitempty"`
+	// Registries are merged into the .dockerconfigjson entry of kubernetes.io/dockerconfigjson secrets. Optional
+	Registries []Registry `yaml:"registries,omitempty"`
+	// Adopt allows overwriting existing K8s Secrets not labelled as managed by secrets-manager. Optional
+	Adopt bool `yaml:"adopt,omitempty"`
+	// Labels to set on the K8s Secret. Values are templates over the .Name and .Namespace of the secret. Optional
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Annotations to set on the K8s Secret. Values are templates over the .Name and .Namespace of the secret. Optional
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// UpdateStrategy of existing secrets, replace or merge. Optional, replace by default
+	UpdateStrategy string `yaml:"updateStrategy,omitempty"`
+	// Immutable writes the secret as immutable generations named after the hash of their content, recording the
+	// current one in a pointer secret named Name. Optional
+	Immutable *Immutable `yaml:"immutable,omitempty"`
+	// RestartPolicy restarts the workloads consuming the secret when its data changes: none, auto or a list of
+	// workloads as kind/name. Optional, none by default
+	RestartPolicy RestartPolicy `yaml:"restartPolicy,omitempty"`
+
+	// resource is the custom resource the definition was loaded from, if any
+	resource *definitionResource
+	// owner is the key of the definition mirrored secrets and generations are derived from
+	owner string
+}
+
+// Registry represents the credentials to login into a container registry
+type Registry struct {
+	// URL of the registry, such as https://index.docker.io/v1/ or registry.example.com
+	URL string `yaml:"url"`
+	// Username to login with
+	Username Datasource `yaml:"username"`
+	// Password to login with
+	Password Datasource `yaml:"password"`
+	// Email of the user. Optional
+	Email string `yaml:"email,omitempty"`
+}
+
+// Mirror represents a backend path whose secrets are synced as one K8s Secret each
+type Mirror struct {
+	// Path to the backend folder to list recursively
+	Path string `yaml:"path"`
+	// Include is a list of glob patterns the secret path, relative to Path, must match. Optional, all secrets by default
+	Include []string `yaml:"include,omitempty"`
+	// Exclude is a list of glob patterns the secret path, relative to Path, must not match. Optional
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Encoding type for every key of the mirrored secrets. Optional
+	Encoding string `yaml:"encoding,omitempty"`
+}
+
+// BackendMetadata lists the custom metadata keys of the backend secrets to copy as labels or annotations
+type BackendMetadata struct {
+	// Labels is the list of custom metadata keys to copy as labels
+	Labels []string `yaml:"labels,omitempty"`
+	// Annotations is the list of custom metadata keys to copy as annotations
+	Annotations []string `yaml:"annotations,omitempty"`
 }
 
 // Datasource represents a reference to a secret in a backend (source of truth)
@@ -36,8 +119,126 @@ type Datasource struct {
 	Path string `yaml:"path"`
 	// Key in the secret in the backend
 	Key string `yaml:"key"`
-	// Encoding type for the secret. Only base64 supported. Optional
+	// Encoding of the secret in the backend: text, base64, base64url, base64raw, base32, hex or gunzip. Encodings
+	// can be chained with '|', decoding from left to right, as in base64|gunzip. Optional, text by default
 	Encoding string `yaml:"encoding,omitempty"`
+	// JSONPath selects a field of the decoded value, a JSON document, such as .client_email. Optional
+	JSONPath string `yaml:"jsonPath,omitempty"`
+	// Template renders the value from Datasources with text/template, instead of reading Path and Key. Optional
+	Template string `yaml:"template,omitempty"`
+	// Datasources are the backend secrets available to Template by name
+	Datasources map[string]Datasource `yaml:"datasources,omitempty"`
+	// Keystore builds the value as a PKCS#12 or JKS keystore from PEM datasources, instead of reading Path and Key. Optional
+	Keystore *Keystore `yaml:"keystore,omitempty"`
+	// FromPKCS12 extracts PEM material from the decoded value, a PKCS#12 keystore. Optional
+	FromPKCS12 *PKCS12Source `yaml:"fromPKCS12,omitempty"`
+}
+
+// Keystore represents a PKCS#12 or JKS keystore built from PEM encoded certificates and private key
+type Keystore struct {
+	// Format of the keystore, pkcs12 or jks
+	Format string `yaml:"format"`
+	// Certificate is the PEM encoded certificate, optionally followed by its chain
+	Certificate Datasource `yaml:"certificate"`
+	// Key is the PEM encoded private key of the certificate
+	Key Datasource `yaml:"key"`
+	// CA is the PEM encoded CA chain to add to the keystore. Optional
+	CA *Datasource `yaml:"ca,omitempty"`
+	// Password protecting the keystore
+	Password Datasource `yaml:"password"`
+	// Alias of the key entry in JKS keystores. Optional, mykey by default
+	Alias string `yaml:"alias,omitempty"`
+}
+
+// PKCS12Source represents the PEM material to extract from a PKCS#12 keystore
+type PKCS12Source struct {
+	// Password protecting the keystore. Optional, the keystore is not password protected by default
+	Password *Datasource `yaml:"password,omitempty"`
+	// Output is the PEM material to extract: certificate, key, ca or chain (the certificate followed by the CA chain)
+	Output string `yaml:"output"`
+}
+
+// validate checks that the kind, every template, keystore, JSONPath, registry, label, annotation, the restart
+// policy, the update strategy, the immutable options and the namespace selector of the secret definition are well
+// formed
+func (d SecretDefinition) validate() error {
+	if err := d.validateKind(); err != nil {
+		return err
+	}
+	if err := d.validateImmutable(); err != nil {
+		return err
+	}
+	if err := d.validateUpdateStrategy(); err != nil {
+		return err
+	}
+	if err := d.validateNamespaceSelector(); err != nil {
+		return err
+	}
+	if err := d.validateMetadata(); err != nil {
+		return err
+	}
+	if err := d.validateRestartPolicy(); err != nil {
+		return err
+	}
+	if err := d.validateRegistries(); err != nil {
+		return err
+	}
+	if err := d.validateKeystores(); err != nil {
+		return err
+	}
+	for _, v := range d.datasources() {
+		if v.JSONPath == "" {
+			continue
+		}
+		if _, err := parseJSONPath(v.JSONPath); err != nil {
+			return jsonPathError(v, err.Error())
+		}
+	}
+	for key, v := range d.Data {
+		if v.Template == "" {
+			if len(v.Datasources) > 0 {
+				return &errors.InvalidTemplateError{ErrType: errors.InvalidTemplateErrorType, Name: d.Name, Key: key, Reason: "datasources set without a template"}
+			}
+			continue
+		}
+		if _, err := parseTemplate(key, v.Template); err != nil {
+			return &errors.InvalidTemplateError{ErrType: errors.InvalidTemplateErrorType, Name: d.Name, Key: key, Reason: err.Error()}
+		}
+		for name, ds := range v.Datasources {
+			if !isPlainDatasource(ds) {
+				return &errors.InvalidTemplateError{ErrType: errors.InvalidTemplateErrorType, Name: d.Name, Key: key, Reason: fmt.Sprintf("datasource %s can not be a template or a keystore", name)}
+			}
+		}
+	}
+	return nil
+}
+
+// datasources returns every backend secret the secret definition reads, including the ones used by
+// templates and keystores
+func (d SecretDefinition) datasources() []Datasource {
+	datasources := make([]Datasource, 0, len(d.Data))
+	for _, v := range d.Data {
+		switch {
+		case v.Template != "":
+			for _, ds := range v.Datasources {
+				datasources = append(datasources, ds)
+			}
+		case v.Keystore != nil:
+			datasources = append(datasources, v.Keystore.Certificate, v.Keystore.Key, v.Keystore.Password)
+			if v.Keystore.CA != nil {
+				datasources = append(datasources, *v.Keystore.CA)
+			}
+		default:
+			datasources = append(datasources, v)
+			if v.FromPKCS12 != nil && v.FromPKCS12.Password != nil {
+				datasources = append(datasources, *v.FromPKCS12.Password)
+			}
+		}
+	}
+	for _, r := range d.Registries {
+		datasources = append(datasources, r.Username, r.Password)
+	}
+	return datasources
 }
 
 func parseSecretDefsFromYaml(configText string) (SecretDefinitions, error) {
@@ -48,5 +249,10 @@ func parseSecretDefsFromYaml(configText string) (SecretDefinitions, error) {
 		fmt.Printf("error: could'n unmarshal yaml %v\n", err)
 		return nil, err
 	}
+	for _, secretDef := range *secretDefs {
+		if err := secretDef.validate(); err != nil {
+			return nil, err
+		}
+	}
 	return *secretDefs, nil
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/errors"
 )
 
 func TestParseSecretDefsFromYaml(t *testing.T) {
@@ -39,6 +40,113 @@ func TestParseSecretDefsFromYaml(t *testing.T) {
 	assert.Len(t, secretDefs, 2)
 }
 
+func TestParseSecretDefsFromYamlBackendMetadata(t *testing.T) {
+	configText := `
+- name: supersecret1
+  type: Opaque
+  namespaces:
+  - default
+  data:
+    value1:
+      path: secret/data/pathtosecret1
+      key: value
+  backendMetadata:
+    labels:
+    - owner
+    annotations:
+    - description
+`
+
+	secretDefs, err := parseSecretDefsFromYaml(configText)
+
+	assert.Nil(t, err)
+	assert.Equal(t, BackendMetadata{Labels: []string{"owner"}, Annotations: []string{"description"}}, secretDefs[0].BackendMetadata)
+}
+
+func TestParseSecretDefsFromYamlMirror(t *testing.T) {
+	configText := `
+- name: team-a-
+  type: Opaque
+  namespaces:
+  - team-a
+  mirror:
+    path: secret/data/team-a
+    include:
+    - "apps/*"
+    exclude:
+    - "apps/legacy-*"
+`
+
+	secretDefs, err := parseSecretDefsFromYaml(configText)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Mirror{Path: "secret/data/team-a", Include: []string{"apps/*"}, Exclude: []string{"apps/legacy-*"}}, secretDefs[0].Mirror)
+}
+
+func TestParseSecretDefsFromYamlTemplate(t *testing.T) {
+	configText := `
+- name: db
+  type: Opaque
+  namespaces:
+  - default
+  data:
+    url:
+      template: "postgres://{{ .user }}@db.example.com"
+      datasources:
+        user:
+          path: secret/data/db
+          key: user
+`
+
+	secretDefs, err := parseSecretDefsFromYaml(configText)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Datasource{{Path: "secret/data/db", Key: "user"}}, secretDefs[0].datasources())
+}
+
+func TestParseSecretDefsFromYamlInvalidTemplate(t *testing.T) {
+	configText := `
+- name: db
+  type: Opaque
+  namespaces:
+  - default
+  data:
+    url:
+      template: "postgres://{{ .user @db.example.com"
+`
+
+	_, err := parseSecretDefsFromYaml(configText)
+
+	assert.EqualError(t, err, `[InvalidTemplateError] invalid template for key url of secret db: template: url:1: unexpected "@" in operand`)
+}
+
+func TestValidateDatasourcesWithoutTemplate(t *testing.T) {
+	secretDef := SecretDefinition{
+		Name: "db",
+		Data: map[string]Datasource{
+			"url": {
+				Datasources: map[string]Datasource{"user": {Path: "secret/data/db", Key: "user"}},
+			},
+		},
+	}
+
+	assert.True(t, errors.IsInvalidTemplate(secretDef.validate()))
+}
+
+func TestValidateNestedTemplate(t *testing.T) {
+	secretDef := SecretDefinition{
+		Name: "db",
+		Data: map[string]Datasource{
+			"url": {
+				Template:    "{{ .user }}",
+				Datasources: map[string]Datasource{"user": {Template: "{{ .other }}"}},
+			},
+		},
+	}
+
+	assert.True(t, errors.IsInvalidTemplate(secretDef.validate()))
+}
+
 func TestParseSecretDefsFromYamlInvalidYaml(t *testing.T) {
 	configText := `
 - something: that
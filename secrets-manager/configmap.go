@@ -0,0 +1,92 @@
+package secretsmanager
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+)
+
+// Kinds of the objects written for a secret definition
+const (
+	// SecretKind writes a Secret, the default
+	SecretKind = "Secret"
+	// ConfigMapKind writes a ConfigMap, for values that are not sensitive
+	ConfigMapKind = "ConfigMap"
+)
+
+// validateKind checks that the kind of the secret definition is Secret or ConfigMap, and that ConfigMaps do not
+// use options only meaningful for secrets
+func (d SecretDefinition) validateKind() error {
+	invalid := func(reason string) error {
+		return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: reason}
+	}
+	switch d.Kind {
+	case "", SecretKind:
+		return nil
+	case ConfigMapKind:
+	default:
+		return invalid(fmt.Sprintf("invalid kind %s, expected Secret or ConfigMap", d.Kind))
+	}
+	switch {
+	case d.Type != "":
+		return invalid("type can not be used with the ConfigMap kind")
+	case len(d.Registries) > 0:
+		return invalid("registries can not be used with the ConfigMap kind")
+	case d.Immutable != nil:
+		return invalid("immutable can not be used with the ConfigMap kind")
+	case d.Mirror != nil:
+		return invalid("mirror can not be used with the ConfigMap kind")
+	case d.UpdateStrategy == UpdateMerge:
+		return invalid("the merge updateStrategy can not be used with the ConfigMap kind")
+	case d.RestartPolicy.restarts():
+		return invalid("restartPolicy can not be used with the ConfigMap kind")
+	}
+	return nil
+}
+
+// isConfigMap returns true if the secret definition writes a ConfigMap instead of a Secret
+func (d SecretDefinition) isConfigMap() bool {
+	return d.Kind == ConfigMapKind
+}
+
+// configMapData splits data into the UTF-8 values and the binary ones
+func configMapData(data map[string][]byte) (map[string]string, map[string][]byte) {
+	text := make(map[string]string, len(data))
+	var binary map[string][]byte
+	for k, v := range data {
+		if utf8.Valid(v) {
+			text[k] = string(v)
+			continue
+		}
+		if binary == nil {
+			binary = make(map[string][]byte)
+		}
+		binary[k] = v
+	}
+	return text, binary
+}
+
+// getCurrentConfigMap returns the configmap namespace/name as a secret, with its UTF-8 and binary values as data
+func (s *SecretManager) getCurrentConfigMap(namespace string, name string) (*k8s.Secret, error) {
+	configMap, err := s.kubernetes.GetConfigMap(namespace, name)
+	if err != nil {
+		logger.Debugf("failed to read '%s/%s' configmap from kubernetes api: %v", namespace, name, err)
+		return nil, err
+	}
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range configMap.BinaryData {
+		data[k] = v
+	}
+	return &k8s.Secret{
+		Name:        configMap.Name,
+		Namespace:   configMap.Namespace,
+		Data:        data,
+		Labels:      configMap.Labels,
+		Annotations: configMap.Annotations,
+	}, nil
+}
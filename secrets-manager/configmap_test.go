@@ -0,0 +1,81 @@
+package secretsmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestParseKind(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml("- name: endpoints\n  kind: ConfigMap")
+	assert.Nil(t, err)
+	assert.True(t, defs[0].isConfigMap())
+
+	for _, definition := range []string{
+		"kind: Deployment",
+		"kind: ConfigMap\n  type: kubernetes.io/tls",
+		"kind: ConfigMap\n  immutable: {}",
+		"kind: ConfigMap\n  restartPolicy: auto",
+		"kind: ConfigMap\n  mirror: {path: secret/data/team}",
+	} {
+		_, err := parseSecretDefsFromYaml("- name: endpoints\n  " + definition)
+		assert.True(t, e.IsInvalidSecretDefinition(err), "expected invalid definition %s, got %v", definition, err)
+	}
+}
+
+func TestConfigMapData(t *testing.T) {
+	text, binary := configMapData(map[string][]byte{"url": []byte("https://db.example.com"), "blob": {0xff, 0xfe}})
+
+	assert.Equal(t, map[string]string{"url": "https://db.example.com"}, text)
+	assert.Equal(t, map[string][]byte{"blob": {0xff, 0xfe}}, binary)
+
+	_, binary = configMapData(map[string][]byte{"url": []byte("https://db.example.com")})
+	assert.Nil(t, binary)
+}
+
+func TestSyncStateConfigMap(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	logger := log.New()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/endpoints", "url", "https://db.example.com"},
+		{"secret/data/endpoints", "blob", base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe})},
+	})
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm"}, kubernetes.New(clientSet, logger), fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:       "endpoints",
+		Namespaces: []string{"ns1", "ns2"},
+		Kind:       ConfigMapKind,
+		Data: map[string]Datasource{
+			"url":  {Path: "secret/data/endpoints", Key: "url"},
+			"blob": {Path: "secret/data/endpoints", Key: "blob", Encoding: "base64"},
+		},
+	}
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	for _, namespace := range definition.Namespaces {
+		configMap, err := clientSet.CoreV1().ConfigMaps(namespace).Get("endpoints", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"url": "https://db.example.com"}, configMap.Data)
+		assert.Equal(t, map[string][]byte{"blob": {0xff, 0xfe}}, configMap.BinaryData)
+		assert.Equal(t, kubernetes.ManagedByValue, configMap.Labels[kubernetes.ManagedByLabel])
+	}
+	secrets, _ := clientSet.CoreV1().Secrets(metav1.NamespaceAll).List(metav1.ListOptions{})
+	assert.Empty(t, secrets.Items)
+
+	// ConfigMaps in sync are not updated again
+	clientSet.ClearActions()
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	for _, action := range clientSet.Actions() {
+		_, updated := action.(clientgotesting.UpdateAction)
+		assert.False(t, updated && action.GetResource().Resource == "configmaps")
+	}
+}
@@ -0,0 +1,124 @@
+package secretsmanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tuenti/secrets-manager/errors"
+)
+
+const (
+	dockerConfigJSONSecretType = "kubernetes.io/dockerconfigjson"
+	dockerConfigJSONKey        = ".dockerconfigjson"
+)
+
+// dockerConfigJSON is the content of a .dockerconfigjson entry, as read by the kubelet
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+	// IdentityToken and RegistryToken are the tokens of registries authenticating with OAuth2 instead of a password
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+func invalidDockerConfig(name string, reason string) error {
+	return &errors.InvalidDockerConfigError{ErrType: errors.InvalidDockerConfigErrorType, Name: name, Reason: reason}
+}
+
+// validateRegistries checks registries are only set on docker config secrets, once per URL and with credentials
+func (d SecretDefinition) validateRegistries() error {
+	if len(d.Registries) == 0 {
+		return nil
+	}
+	if d.Type != dockerConfigJSONSecretType {
+		return invalidDockerConfig(d.Name, fmt.Sprintf("registries are only supported by %s secrets", dockerConfigJSONSecretType))
+	}
+	urls := make(map[string]bool, len(d.Registries))
+	for _, r := range d.Registries {
+		if r.URL == "" {
+			return invalidDockerConfig(d.Name, "registry url is required")
+		}
+		if urls[r.URL] {
+			return invalidDockerConfig(d.Name, fmt.Sprintf("registry %s is defined more than once", r.URL))
+		}
+		urls[r.URL] = true
+		if r.Username.Path == "" || r.Password.Path == "" {
+			return invalidDockerConfig(d.Name, fmt.Sprintf("registry %s needs a username and a password", r.URL))
+		}
+	}
+	return nil
+}
+
+// getDockerConfigJSON merges the credentials of every registry of the secret into dockerConfig, which may be empty
+func (s *SecretManager) getDockerConfigJSON(secret SecretDefinition, dockerConfig []byte) ([]byte, error) {
+	config := dockerConfigJSON{}
+	if len(dockerConfig) > 0 {
+		if err := json.Unmarshal(dockerConfig, &config); err != nil {
+			return nil, invalidDockerConfig(secret.Name, err.Error())
+		}
+	}
+	if config.Auths == nil {
+		config.Auths = make(map[string]dockerConfigEntry, len(secret.Registries))
+	}
+
+	for _, r := range secret.Registries {
+		username, err := s.readDatasource(r.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := s.readDatasource(r.Password)
+		if err != nil {
+			return nil, err
+		}
+		config.Auths[r.URL] = dockerConfigEntry{
+			Username: string(username),
+			Password: string(password),
+			Email:    r.Email,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(string(username) + ":" + string(password))),
+		}
+	}
+	return json.Marshal(config)
+}
+
+// validateDockerConfigJSON checks that data can be used by the kubelet to pull images: every registry must
+// have an auth field holding base64 encoded <username>:<password> credentials, a username, or a token
+func validateDockerConfigJSON(name string, data []byte) error {
+	config := dockerConfigJSON{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return invalidDockerConfig(name, err.Error())
+	}
+	if len(config.Auths) == 0 {
+		return invalidDockerConfig(name, "no registry found in auths")
+	}
+	for url, entry := range config.Auths {
+		if url == "" {
+			return invalidDockerConfig(name, "empty registry url")
+		}
+		if entry.Auth == "" {
+			if entry.Username == "" && entry.IdentityToken == "" && entry.RegistryToken == "" {
+				return invalidDockerConfig(name, fmt.Sprintf("registry %s has no credentials", url))
+			}
+			continue
+		}
+		auth, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return invalidDockerConfig(name, fmt.Sprintf("auth of registry %s is not base64 encoded", url))
+		}
+		credentials := strings.SplitN(string(auth), ":", 2)
+		if len(credentials) != 2 || credentials[0] == "" {
+			return invalidDockerConfig(name, fmt.Sprintf("auth of registry %s is not in <username>:<password> format", url))
+		}
+		if entry.Username != "" && entry.Username != credentials[0] {
+			return invalidDockerConfig(name, fmt.Sprintf("auth of registry %s does not match its username", url))
+		}
+	}
+	return nil
+}
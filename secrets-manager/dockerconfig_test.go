@@ -0,0 +1,99 @@
+package secretsmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+)
+
+// dockerConfigSecrets are the backend secrets the registry credentials are read from
+var dockerConfigSecrets = []fakeBackendSecret{
+	{"secret/data/registry", "user", "robot"},
+	{"secret/data/registry", "password", "s3cr3t"},
+	{"secret/data/registry", "dockerconfig", `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"},"token.example.com":{"identitytoken":"eyJhbGciOi"}}}`},
+}
+
+func TestGetDesiredStateRegistries(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, dockerConfigSecrets)
+	defer stop()
+
+	data, err := secretManager.getDesiredState(SecretDefinition{
+		Name: "pull-secret",
+		Type: dockerConfigJSONSecretType,
+		Data: map[string]Datasource{
+			dockerConfigJSONKey: {Path: "secret/data/registry", Key: "dockerconfig"},
+		},
+		Registries: []Registry{
+			{
+				URL:      "https://index.docker.io/v1/",
+				Username: Datasource{Path: "secret/data/registry", Key: "user"},
+				Password: Datasource{Path: "secret/data/registry", Key: "password"},
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"auths":{"https://index.docker.io/v1/":{"username":"robot","password":"s3cr3t","auth":"cm9ib3Q6czNjcjN0"},"registry.example.com":{"auth":"dXNlcjpwYXNz"},"token.example.com":{"identitytoken":"eyJhbGciOi"}}}`, string(data[dockerConfigJSONKey]))
+}
+
+func TestGetDesiredStateInvalidDockerConfig(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, dockerConfigSecrets)
+	defer stop()
+
+	data, err := secretManager.getDesiredState(SecretDefinition{
+		Name: "pull-secret",
+		Type: dockerConfigJSONSecretType,
+		Data: map[string]Datasource{
+			dockerConfigJSONKey: {Path: "secret/data/registry", Key: "user"},
+		},
+	})
+
+	assert.Nil(t, data)
+	assert.True(t, e.IsInvalidDockerConfig(err))
+}
+
+func TestValidateDockerConfigJSON(t *testing.T) {
+	tests := []struct {
+		data  string
+		valid bool
+	}{
+		{`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`, true},
+		{`{"auths":{"registry.example.com":{"username":"user","password":"pass"}}}`, true},
+		{`{"auths":{"registry.example.com":{"identitytoken":"eyJhbGciOi"}}}`, true},
+		{`{"auths":{"registry.example.com":{"registrytoken":"eyJhbGciOi"}}}`, true},
+		{`{"auths":{}}`, false},
+		{`{"auths":{"registry.example.com":{}}}`, false},
+		{`{"auths":{"registry.example.com":{"auth":"not base64!"}}}`, false},
+		{`{"auths":{"registry.example.com":{"auth":"dXNlcnBhc3M="}}}`, false},
+		{`{"auths":{"registry.example.com":{"username":"other","auth":"dXNlcjpwYXNz"}}}`, false},
+		{`not json`, false},
+	}
+
+	for _, test := range tests {
+		err := validateDockerConfigJSON("pull-secret", []byte(test.data))
+		assert.Equal(t, test.valid, err == nil, test.data)
+	}
+}
+
+func TestValidateRegistries(t *testing.T) {
+	registry := Registry{
+		URL:      "registry.example.com",
+		Username: Datasource{Path: "secret/data/registry", Key: "user"},
+		Password: Datasource{Path: "secret/data/registry", Key: "password"},
+	}
+
+	valid := SecretDefinition{Name: "pull-secret", Type: dockerConfigJSONSecretType, Registries: []Registry{registry}}
+	assert.Nil(t, valid.validate())
+
+	wrongType := SecretDefinition{Name: "pull-secret", Type: "Opaque", Registries: []Registry{registry}}
+	assert.True(t, e.IsInvalidDockerConfig(wrongType.validate()))
+
+	duplicated := SecretDefinition{Name: "pull-secret", Type: dockerConfigJSONSecretType, Registries: []Registry{registry, registry}}
+	assert.True(t, e.IsInvalidDockerConfig(duplicated.validate()))
+
+	noPassword := registry
+	noPassword.Password = Datasource{}
+	missingCredentials := SecretDefinition{Name: "pull-secret", Type: dockerConfigJSONSecretType, Registries: []Registry{noPassword}}
+	assert.True(t, e.IsInvalidDockerConfig(missingCredentials.validate()))
+}
@@ -0,0 +1,47 @@
+package secretsmanager
+
+import (
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configReference returns the config object a secret definition was loaded from, the SecretDefinition custom
+// resource or the configmap
+func (s *SecretManager) configReference(secret SecretDefinition) *k8s.ObjectReference {
+	if secret.resource != nil {
+		return &k8s.ObjectReference{
+			Kind:       k8s.SecretDefinitionKind,
+			APIVersion: k8s.SecretDefinitionGroupVersion.String(),
+			Name:       secret.resource.name,
+			Namespace:  secret.resource.namespace,
+			UID:        secret.resource.uid,
+		}
+	}
+	return &k8s.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: s.configMapName, Namespace: s.configMapNamespace, UID: s.getConfigMapUID()}
+}
+
+// getConfigMapUID returns the UID of the configmap the secret definitions are loaded from, looked up the first time
+// it is needed after every load, or empty if the configmap can not be read
+func (s *SecretManager) getConfigMapUID() string {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	if s.configMapUID == "" {
+		configMap, err := s.kubernetes.GetConfigMap(s.configMapNamespace, s.configMapName)
+		if err != nil {
+			logger.Debugf("unable to get the UID of configmap '%s/%s': %v", s.configMapNamespace, s.configMapName, err)
+			return ""
+		}
+		s.configMapUID = configMap.UID
+	}
+	return s.configMapUID
+}
+
+// recordFailure records a warning event about the secret, or configmap, in namespace, on the config object of its
+// definition when it does not exist yet
+func (s *SecretManager) recordFailure(secret SecretDefinition, namespace string, reason string, err error) {
+	if secret.isConfigMap() {
+		s.kubernetes.RecordConfigMapEvent(namespace, secret.Name, s.configReference(secret), corev1.EventTypeWarning, reason, err.Error())
+		return
+	}
+	s.kubernetes.RecordSecretEvent(namespace, secret.Name, s.configReference(secret), corev1.EventTypeWarning, reason, err.Error())
+}
@@ -0,0 +1,87 @@
+package secretsmanager
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSyncStateRecordsEvents(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns1", Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+	}, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "config", UID: "1234"},
+	})
+	logger := log.New()
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "config/cm"}, kubernetes.New(clientSet, logger), newFakeBackend(nil), logger)
+
+	definition := SecretDefinition{
+		Name:       "db",
+		Namespaces: []string{"ns1", "ns2"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+	assert.NotNil(t, secretManager.syncState(context.Background(), definition))
+
+	// The existing secret gets the event
+	events, _ := clientSet.CoreV1().Events("ns1").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, kubernetes.BackendReadFailedReason, events.Items[0].Reason)
+	assert.Equal(t, corev1.EventTypeWarning, events.Items[0].Type)
+	assert.Equal(t, "db", events.Items[0].InvolvedObject.Name)
+
+	// The configmap gets the event of the secret not created yet
+	events, _ = clientSet.CoreV1().Events("config").List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, kubernetes.BackendReadFailedReason, events.Items[0].Reason)
+	assert.Equal(t, "ConfigMap", events.Items[0].InvolvedObject.Kind)
+	assert.Equal(t, "cm", events.Items[0].InvolvedObject.Name)
+	assert.Equal(t, types.UID("1234"), events.Items[0].InvolvedObject.UID)
+	assert.Contains(t, events.Items[0].Message, "Secret ns2/db")
+
+	// Repeated failures are deduplicated
+	assert.NotNil(t, secretManager.syncState(context.Background(), definition))
+	events, _ = clientSet.CoreV1().Events(metav1.NamespaceAll).List(metav1.ListOptions{})
+	assert.Len(t, events.Items, 2)
+}
+
+func TestConfigReference(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	secretManager, stop := newTestSecretManager(t, Config{ConfigMap: "config/cm"}, kubernetes.New(clientSet, log.New()), nil)
+	defer stop()
+
+	// The configmap can not be read
+	assert.Equal(t, &kubernetes.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: "cm", Namespace: "config"},
+		secretManager.configReference(SecretDefinition{Name: "db"}))
+
+	clientSet.CoreV1().ConfigMaps("config").Create(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "config", UID: "5678"}})
+	assert.Equal(t, &kubernetes.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: "cm", Namespace: "config", UID: "5678"},
+		secretManager.configReference(SecretDefinition{Name: "db"}))
+
+	// The UID is looked up again after the configmap is loaded
+	clientSet.CoreV1().ConfigMaps("config").Delete("cm", &metav1.DeleteOptions{})
+	clientSet.CoreV1().ConfigMaps("config").Create(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "config", UID: "9012"}})
+	assert.Equal(t, "5678", secretManager.configReference(SecretDefinition{Name: "db"}).UID)
+	secretManager.configMutex.Lock()
+	secretManager.loadResourceVersion("2")
+	secretManager.configMutex.Unlock()
+	assert.Equal(t, "9012", secretManager.configReference(SecretDefinition{Name: "db"}).UID)
+
+	secretDef := SecretDefinition{Name: "db", resource: &definitionResource{name: "db-resource", namespace: "ns", uid: "1234"}}
+	assert.Equal(t, &kubernetes.ObjectReference{
+		Kind:       kubernetes.SecretDefinitionKind,
+		APIVersion: "secrets-manager.tuenti.io/v1alpha1",
+		Name:       "db-resource",
+		Namespace:  "ns",
+		UID:        "1234",
+	}, secretManager.configReference(secretDef))
+}
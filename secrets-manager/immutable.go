@@ -0,0 +1,205 @@
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// generationOfLabel is the label of the generations of an immutable secret, with the name of the secret
+	generationOfLabel = "secrets-manager.tuenti.io/generation-of"
+	// defaultGenerations is the number of generations of an immutable secret kept by default
+	defaultGenerations = 3
+	// generationHashLength is the length of the content hash suffixed to the name of the generations
+	generationHashLength = 10
+)
+
+// Keys of the pointer secret, recording the current generation of an immutable secret
+const (
+	pointerSecretNameKey = "secretName"
+	pointerHashKey       = "hash"
+)
+
+// Immutable represents the generations of an immutable secret, named after the hash of their content
+type Immutable struct {
+	// Generations is the number of generations kept, including the current one. Optional, 3 by default
+	Generations int `yaml:"generations,omitempty"`
+	// Pin is the hash of the generation the pointer is pinned to, to roll back to it. Optional, the pointer names
+	// the generation of the current data by default
+	Pin string `yaml:"pin,omitempty"`
+}
+
+// generations returns the number of generations to keep
+func (i *Immutable) generations() int {
+	if i.Generations == 0 {
+		return defaultGenerations
+	}
+	return i.Generations
+}
+
+// validateImmutable checks that immutable secret definitions keep at least one generation, have a name usable
+// for their generations and do not use options rewriting or restarting an existing secret
+func (d SecretDefinition) validateImmutable() error {
+	if d.Immutable == nil {
+		return nil
+	}
+	invalid := func(reason string) error {
+		return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: reason}
+	}
+	switch {
+	case d.Immutable.Generations < 0:
+		return invalid(fmt.Sprintf("invalid immutable generations %d, expected a positive number", d.Immutable.Generations))
+	case d.Mirror != nil:
+		return invalid("immutable can not be used with mirror")
+	case d.UpdateStrategy == UpdateMerge:
+		return invalid("immutable can not be used with the merge updateStrategy")
+	case d.RestartPolicy.restarts():
+		return invalid("immutable can not be used with a restartPolicy, consumers roll when they reference a new generation")
+	case len(validation.IsValidLabelValue(d.Name)) > 0:
+		return invalid("the name of immutable secrets must be a valid label value, of at most 63 characters")
+	case d.Immutable.Pin != "" && !isGenerationHash(d.Immutable.Pin):
+		return invalid(fmt.Sprintf("invalid immutable pin '%s', expected the %d hexadecimal characters of the hash of a generation", d.Immutable.Pin, generationHashLength))
+	}
+	return nil
+}
+
+// isGenerationHash returns whether hash can be the hash of a generation
+func isGenerationHash(hash string) bool {
+	if len(hash) != generationHashLength {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// generationName returns the name of the generation of the immutable secret with data, hashed with the checksum key
+func generationName(key []byte, name string, data map[string][]byte) (string, string) {
+	hash := secretChecksum(key, data)[:generationHashLength]
+	return name + "-" + hash, hash
+}
+
+// syncImmutable writes the generation of the immutable secret for the desired state, then points the pointer
+// secret to it, or to the pinned generation, and deletes the oldest generations, returning the namespaces in sync
+// and the last error found, if any. It stops before the next write once ctx is done
+func (s *SecretManager) syncImmutable(ctx context.Context, secret SecretDefinition, desiredState map[string][]byte) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	key, err := s.getChecksumKey()
+	if err != nil {
+		logger.Errorf("unable to get the checksum key to name the generation of secret '%s': %v", secret.Name, err)
+		for _, namespace := range secret.Namespaces {
+			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.SyncFailedReason, err)
+		}
+		return nil, err
+	}
+	name, hash := generationName(key, secret.Name, desiredState)
+	generation := secret
+	generation.Name = name
+	generation.Labels = mergeMetadata(secret.Labels, map[string]string{generationOfLabel: secret.Name})
+	generation.owner = secret.key()
+	written, err := s.writeState(ctx, generation, desiredState)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// The pointer only moves in the namespaces where the generation was written, and where the pinned generation
+	// exists if it is pinned
+	pointed, pointedHash := name, hash
+	if secret.Immutable.Pin != "" {
+		pointed, pointedHash = secret.Name+"-"+secret.Immutable.Pin, secret.Immutable.Pin
+		if pointed != name {
+			var pinErr error
+			written, pinErr = s.pinnedNamespaces(secret, written, pointed)
+			if pinErr != nil {
+				err = pinErr
+			}
+		}
+	}
+	pointer := secret
+	pointer.Type = "Opaque"
+	pointer.Immutable = nil
+	pointer.Namespaces = written
+	synced, pointerErr := s.writeState(ctx, pointer, map[string][]byte{
+		pointerSecretNameKey: []byte(pointed),
+		pointerHashKey:       []byte(pointedHash),
+	})
+	if pointerErr != nil {
+		err = pointerErr
+	}
+	for _, namespace := range synced {
+		if ctx.Err() != nil {
+			return synced, ctx.Err()
+		}
+		s.pruneGenerations(secret, namespace, name, pointed)
+	}
+	return synced, err
+}
+
+// pinnedNamespaces returns the namespaces where the pinned generation of the immutable secret exists, recording a
+// failure in the other ones, along with the last error found, if any
+func (s *SecretManager) pinnedNamespaces(secret SecretDefinition, namespaces []string, pinned string) ([]string, error) {
+	var found []string
+	var err error
+	for _, namespace := range namespaces {
+		generation, getErr := s.kubernetes.GetSecret(namespace, pinned)
+		if getErr == nil && generation.Labels[generationOfLabel] != secret.Name {
+			getErr = fmt.Errorf("secret '%s/%s' is not a generation of secret '%s'", namespace, pinned, secret.Name)
+		}
+		if getErr != nil {
+			logger.Errorf("unable to pin secret '%s/%s' to generation '%s': %v", namespace, secret.Name, pinned, getErr)
+			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.SyncFailedReason, getErr)
+			err = getErr
+			continue
+		}
+		found = append(found, namespace)
+	}
+	return found, err
+}
+
+// pruneGenerations deletes the oldest generations of the immutable secret in namespace, keeping the current and
+// pointed ones along with the newest ones up to the number of generations of its definition
+func (s *SecretManager) pruneGenerations(secret SecretDefinition, namespace string, current string, pointed string) {
+	secrets, err := s.kubernetes.ListSecrets(namespace, map[string]string{k8s.ManagedByLabel: k8s.ManagedByValue, generationOfLabel: secret.Name})
+	if err != nil {
+		logger.Errorf("unable to list generations of secret '%s/%s': %v", namespace, secret.Name, err)
+		return
+	}
+	generations := make([]k8s.Secret, 0, len(secrets))
+	for _, generation := range secrets {
+		if generation.Name != current && generation.Name != pointed {
+			generations = append(generations, generation)
+		}
+	}
+	// Newest first
+	sort.Slice(generations, func(i, j int) bool {
+		if generations[i].CreatedAt.Equal(generations[j].CreatedAt) {
+			return generations[i].Name < generations[j].Name
+		}
+		return generations[i].CreatedAt.After(generations[j].CreatedAt)
+	})
+
+	for i, generation := range generations {
+		if i < secret.Immutable.generations()-1 {
+			continue
+		}
+		logger.Infof("deleting old generation '%s/%s' of secret '%s'", namespace, generation.Name, secret.Name)
+		if err := s.kubernetes.DeleteSecret(namespace, generation.Name); err != nil {
+			logger.Errorf("unable to delete old generation '%s/%s' of secret '%s': %v", namespace, generation.Name, secret.Name, err)
+			continue
+		}
+		secretGenerationDeletedCount.WithLabelValues(secret.Name, namespace).Inc()
+		s.deleteTLSNotAfter(namespace, generation.Name)
+	}
+}
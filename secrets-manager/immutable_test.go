@@ -0,0 +1,221 @@
+package secretsmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestParseImmutable(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml(`
+- name: db
+  immutable:
+    generations: 5
+- name: tls
+  immutable: {}
+- name: pinned
+  immutable:
+    pin: 0123456789
+`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, defs[0].Immutable.generations())
+	assert.Equal(t, defaultGenerations, defs[1].Immutable.generations())
+	assert.Equal(t, "0123456789", defs[2].Immutable.Pin)
+}
+
+func TestParseImmutableInvalid(t *testing.T) {
+	for _, definition := range []string{
+		"immutable: {generations: -1}",
+		"immutable: {}\n  updateStrategy: merge",
+		"immutable: {}\n  restartPolicy: auto",
+		"immutable: {}\n  mirror: {path: secret/data/team}",
+		"immutable: {pin: 01234}",
+		"immutable: {pin: 0123456789ABCDEF}",
+		"immutable: {pin: 012345678g}",
+	} {
+		_, err := parseSecretDefsFromYaml("- name: db\n  " + definition)
+		assert.True(t, e.IsInvalidSecretDefinition(err), "expected invalid definition %s, got %v", definition, err)
+	}
+
+	_, err := parseSecretDefsFromYaml("- name: " + strings.Repeat("a", 64) + "\n  immutable: {}")
+	assert.True(t, e.IsInvalidSecretDefinition(err))
+}
+
+func TestGenerationName(t *testing.T) {
+	name, hash := generationName(testChecksumKey, "db", map[string][]byte{"password": []byte("s3cr3t")})
+
+	assert.Len(t, hash, generationHashLength)
+	assert.Equal(t, "db-"+hash, name)
+	other, _ := generationName(testChecksumKey, "db", map[string][]byte{"password": []byte("changed")})
+	assert.NotEqual(t, name, other)
+	otherKey, _ := generationName([]byte("other-key"), "db", map[string][]byte{"password": []byte("s3cr3t")})
+	assert.NotEqual(t, name, otherKey)
+}
+
+// newImmutableSecretManager returns a secret manager reading the db password from the backend, whose patches
+// of secrets always succeed
+func newImmutableSecretManager(t *testing.T, password string, objects ...runtime.Object) (*SecretManager, *fake.Clientset, func()) {
+	clientSet := fake.NewSimpleClientset(objects...)
+	clientSet.PrependReactor("patch", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(clientSet, log.New()), []fakeBackendSecret{
+		{"secret/data/db", "password", password},
+	})
+	return secretManager, clientSet, stop
+}
+
+func newImmutableDefinition(generations int) SecretDefinition {
+	return SecretDefinition{
+		Name:       "db",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Immutable:  &Immutable{Generations: generations},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+}
+
+func newGeneration(name string, createdAt time.Time) *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "ns",
+		Labels:            map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue, generationOfLabel: "db"},
+		CreationTimestamp: metav1.NewTime(createdAt),
+	}}
+}
+
+func TestSyncStateImmutable(t *testing.T) {
+	secretManager, clientSet, stop := newImmutableSecretManager(t, "s3cr3t")
+	defer stop()
+
+	assert.Nil(t, secretManager.syncState(context.Background(), newImmutableDefinition(0)))
+
+	name, hash := generationName(testChecksumKey, "db", map[string][]byte{"password": []byte("s3cr3t")})
+	generation, err := clientSet.CoreV1().Secrets("ns").Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("s3cr3t"), generation.Data["password"])
+	assert.Equal(t, "db", generation.Labels[generationOfLabel])
+
+	pointer, err := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{pointerSecretNameKey: []byte(name), pointerHashKey: []byte(hash)}, pointer.Data)
+	assert.Empty(t, pointer.Labels[generationOfLabel])
+
+	// Only the generation is marked as immutable
+	var patched []string
+	for _, action := range clientSet.Actions() {
+		if patch, ok := action.(clientgotesting.PatchAction); ok {
+			patched = append(patched, patch.GetName())
+		}
+	}
+	assert.Equal(t, []string{name}, patched)
+}
+
+func TestSyncStateImmutableKeepsGenerations(t *testing.T) {
+	secretGenerationDeletedCount.Reset()
+	now := time.Now()
+	secretManager, clientSet, stop := newImmutableSecretManager(t, "n3w",
+		newGeneration("db-oldest", now.Add(-3*time.Hour)),
+		newGeneration("db-older", now.Add(-2*time.Hour)),
+		newGeneration("db-old", now.Add(-time.Hour)),
+	)
+	defer stop()
+
+	assert.Nil(t, secretManager.syncState(context.Background(), newImmutableDefinition(3)))
+
+	current, _ := generationName(testChecksumKey, "db", map[string][]byte{"password": []byte("n3w")})
+	list, _ := clientSet.CoreV1().Secrets("ns").List(metav1.ListOptions{})
+	var names []string
+	for _, secret := range list.Items {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"db", current, "db-old", "db-older"}, names)
+	metricSecretGenerationDeletedCount, _ := secretGenerationDeletedCount.GetMetricWithLabelValues("db", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretGenerationDeletedCount))
+}
+
+func TestPruneImmutableGenerations(t *testing.T) {
+	other := newGeneration("db-other", time.Now())
+	other.Labels[generationOfLabel] = "other"
+	secretManager, clientSet, stop := newImmutableSecretManager(t, "s3cr3t",
+		newGeneration("db-0123456789", time.Now()),
+		newGeneration("db", time.Now()),
+		other,
+	)
+	defer stop()
+	secretManager.prunePolicy = PruneDelete
+	secretManager.setSecretDefinitions(SecretDefinitions{newImmutableDefinition(0)}, nil)
+
+	secretManager.prune()
+
+	// Generations are deleted when they are too old, not by the prune policy, unlike the generations of
+	// secrets no longer defined
+	list, _ := clientSet.CoreV1().Secrets("ns").List(metav1.ListOptions{})
+	names := []string{}
+	for _, secret := range list.Items {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"db-0123456789", "db"}, names)
+}
+
+func TestSyncStateImmutablePinned(t *testing.T) {
+	now := time.Now()
+	secretManager, clientSet, stop := newImmutableSecretManager(t, "n3w",
+		newGeneration("db-0123456789", now.Add(-3*time.Hour)),
+		newGeneration("db-old", now.Add(-2*time.Hour)),
+		newGeneration("db-older", now.Add(-time.Hour)),
+	)
+	defer stop()
+	definition := newImmutableDefinition(2)
+	definition.Immutable.Pin = "0123456789"
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	// The generation of the current data is written, but the pointer stays on the pinned one, which is kept
+	current, _ := generationName(testChecksumKey, "db", map[string][]byte{"password": []byte("n3w")})
+	pointer, err := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{pointerSecretNameKey: []byte("db-0123456789"), pointerHashKey: []byte("0123456789")}, pointer.Data)
+	list, _ := clientSet.CoreV1().Secrets("ns").List(metav1.ListOptions{})
+	var names []string
+	for _, secret := range list.Items {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"db", current, "db-0123456789", "db-older"}, names)
+
+	// Syncing again does not move the pointer
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	pointer, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, []byte("db-0123456789"), pointer.Data[pointerSecretNameKey])
+}
+
+func TestSyncStateImmutablePinnedMissing(t *testing.T) {
+	secretManager, clientSet, stop := newImmutableSecretManager(t, "n3w")
+	defer stop()
+	definition := newImmutableDefinition(0)
+	definition.Immutable.Pin = "0123456789"
+
+	secretSyncErrorsCount.Reset()
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	// The pointer is not written to a generation that does not exist
+	_, err := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.NotNil(t, err)
+	metricSecretSyncErrorsCount, _ := secretSyncErrorsCount.GetMetricWithLabelValues("db", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricSecretSyncErrorsCount))
+}
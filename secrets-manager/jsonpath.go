@@ -0,0 +1,69 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/tuenti/secrets-manager/errors"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+func jsonPathError(v Datasource, reason string) error {
+	return &errors.JSONPathError{ErrType: errors.JSONPathErrorType, Path: v.Path, Key: v.Key, JSONPath: v.JSONPath, Reason: reason}
+}
+
+// parseJSONPath parses a selector such as .client_email, client_email or {.client_email} as a kubectl JSONPath template
+func parseJSONPath(selector string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(selector, "{") {
+		if !strings.HasPrefix(selector, ".") && !strings.HasPrefix(selector, "[") {
+			selector = "." + selector
+		}
+		selector = "{" + selector + "}"
+	}
+	j := jsonpath.New("jsonPath")
+	if err := j.Parse(selector); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// selectJSONPath returns the single value the JSONPath selector of the datasource selects from the JSON document
+// data. Strings are returned as is and any other value as JSON
+func selectJSONPath(v Datasource, data []byte) ([]byte, error) {
+	j, err := parseJSONPath(v.JSONPath)
+	if err != nil {
+		return nil, jsonPathError(v, err.Error())
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, jsonPathError(v, fmt.Sprintf("value is not a JSON document: %v", err))
+	}
+
+	results, err := j.FindResults(document)
+	if err != nil {
+		return nil, jsonPathError(v, err.Error())
+	}
+	var values []reflect.Value
+	for _, r := range results {
+		values = append(values, r...)
+	}
+	if len(values) != 1 {
+		return nil, jsonPathError(v, fmt.Sprintf("%d values selected instead of one", len(values)))
+	}
+
+	value := values[0].Interface()
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	selected, err := json.Marshal(value)
+	if err != nil {
+		return nil, jsonPathError(v, err.Error())
+	}
+	return selected, nil
+}
@@ -0,0 +1,74 @@
+package secretsmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+)
+
+const testServiceAccount = `{
+	"type": "service_account",
+	"client_email": "app@project.iam.gserviceaccount.com",
+	"project": {"id": 1234567890123456789, "labels": {"team": "a"}},
+	"scopes": ["read", "write"]
+}`
+
+func TestSelectJSONPath(t *testing.T) {
+	for selector, expected := range map[string]string{
+		".client_email":       "app@project.iam.gserviceaccount.com",
+		"client_email":        "app@project.iam.gserviceaccount.com",
+		"{.client_email}":     "app@project.iam.gserviceaccount.com",
+		".project.id":         "1234567890123456789",
+		".project.labels":     `{"team":"a"}`,
+		"project.labels.team": "a",
+		".scopes[1]":          "write",
+	} {
+		data, err := selectJSONPath(Datasource{Path: "secret/data/gcp", Key: "sa.json", JSONPath: selector}, []byte(testServiceAccount))
+		assert.Nil(t, err, selector)
+		assert.Equal(t, expected, string(data), selector)
+	}
+}
+
+func TestSelectJSONPathMissing(t *testing.T) {
+	_, err := selectJSONPath(Datasource{Path: "secret/data/gcp", Key: "sa.json", JSONPath: ".private_key"}, []byte(testServiceAccount))
+	assert.True(t, e.IsJSONPath(err))
+	assert.Contains(t, err.Error(), "unable to select .private_key from secret/data/gcp/sa.json")
+}
+
+func TestSelectJSONPathSeveralValues(t *testing.T) {
+	_, err := selectJSONPath(Datasource{JSONPath: ".scopes[*]"}, []byte(testServiceAccount))
+	assert.True(t, e.IsJSONPath(err))
+	assert.Contains(t, err.Error(), "2 values selected instead of one")
+}
+
+func TestSelectJSONPathNotJSON(t *testing.T) {
+	_, err := selectJSONPath(Datasource{JSONPath: ".client_email"}, []byte("client_email: foo"))
+	assert.True(t, e.IsJSONPath(err))
+}
+
+func TestGetDesiredStateJSONPath(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/gcp", "sa.json", "eyJjbGllbnRfZW1haWwiOiAiYXBwQHByb2plY3QuaWFtLmdzZXJ2aWNlYWNjb3VudC5jb20ifQ=="},
+	})
+	defer stop()
+
+	data, err := secretManager.getDesiredState(SecretDefinition{
+		Name: "gcp",
+		Data: map[string]Datasource{
+			"email": {Path: "secret/data/gcp", Key: "sa.json", Encoding: "base64", JSONPath: ".client_email"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "app@project.iam.gserviceaccount.com", string(data["email"]))
+}
+
+func TestValidateJSONPath(t *testing.T) {
+	secretDef := SecretDefinition{Name: "gcp", Data: map[string]Datasource{
+		"email": {Path: "secret/data/gcp", Key: "sa.json", JSONPath: ".client_email[unclosed"},
+	}}
+	assert.True(t, e.IsJSONPath(secretDef.validate()))
+
+	secretDef.Data["email"] = Datasource{Path: "secret/data/gcp", Key: "sa.json", JSONPath: ".client_email"}
+	assert.Nil(t, secretDef.validate())
+}
@@ -0,0 +1,402 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/tuenti/secrets-manager/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	pkcs12KeystoreFormat = "pkcs12"
+	jksKeystoreFormat    = "jks"
+	defaultKeystoreAlias = "mykey"
+
+	pkcs12CertificateOutput = "certificate"
+	pkcs12KeyOutput         = "key"
+	pkcs12CAOutput          = "ca"
+	pkcs12ChainOutput       = "chain"
+)
+
+const (
+	jksMagic          = 0xfeedfeed
+	jksVersion        = 2
+	jksPrivateKeyTag  = 1
+	jksCertType       = "X.509"
+	jksDigestWhitener = "Mighty Aphrodite"
+)
+
+// oidJavaKeyProtector identifies the proprietary algorithm JKS keystores protect private keys with
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+func keystoreError(secret SecretDefinition, key string, reason string) error {
+	return &errors.KeystoreError{ErrType: errors.KeystoreErrorType, Name: secret.Name, Key: key, Reason: reason}
+}
+
+// isPlainDatasource returns true if the datasource is read as is from the backend
+func isPlainDatasource(v Datasource) bool {
+	return v.Template == "" && v.Keystore == nil && v.FromPKCS12 == nil
+}
+
+// validateKeystores checks every keystore and PKCS#12 extraction of the secret definition is well formed
+func (d SecretDefinition) validateKeystores() error {
+	for key, v := range d.Data {
+		if v.Keystore != nil {
+			if v.Template != "" || v.FromPKCS12 != nil {
+				return keystoreError(d, key, "a keystore can not be combined with a template or fromPKCS12")
+			}
+			switch v.Keystore.Format {
+			case pkcs12KeystoreFormat, jksKeystoreFormat:
+			default:
+				return keystoreError(d, key, fmt.Sprintf("keystore format %s not supported", v.Keystore.Format))
+			}
+			if v.Keystore.Certificate.Path == "" || v.Keystore.Key.Path == "" || v.Keystore.Password.Path == "" {
+				return keystoreError(d, key, "a keystore needs a certificate, a key and a password")
+			}
+			datasources := []Datasource{v.Keystore.Certificate, v.Keystore.Key, v.Keystore.Password}
+			if v.Keystore.CA != nil {
+				datasources = append(datasources, *v.Keystore.CA)
+			}
+			for _, ds := range datasources {
+				if !isPlainDatasource(ds) {
+					return keystoreError(d, key, "keystore datasources can not be templates or keystores")
+				}
+			}
+		}
+		if v.FromPKCS12 != nil {
+			if v.Template != "" {
+				return keystoreError(d, key, "fromPKCS12 can not be combined with a template")
+			}
+			switch v.FromPKCS12.Output {
+			case pkcs12CertificateOutput, pkcs12KeyOutput, pkcs12CAOutput, pkcs12ChainOutput:
+			default:
+				return keystoreError(d, key, fmt.Sprintf("fromPKCS12 output %s not supported", v.FromPKCS12.Output))
+			}
+			if v.FromPKCS12.Password != nil && !isPlainDatasource(*v.FromPKCS12.Password) {
+				return keystoreError(d, key, "fromPKCS12 password can not be a template or a keystore")
+			}
+		}
+	}
+	return nil
+}
+
+// parsePEMCertificates returns every certificate found in data, in order
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// parsePEMPrivateKey returns the first PKCS#1, PKCS#8 or EC private key found in data
+func parsePEMPrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM encoded private key found")
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+}
+
+// keyMatchesCertificate returns true if the public key of the certificate is the one of the private key
+func keyMatchesCertificate(key crypto.Signer, cert *x509.Certificate) bool {
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return false
+	}
+	certPublic, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(public, certPublic)
+}
+
+// deterministicReader is a stream of bytes derived with HMAC-SHA256 from a secret key and some inputs.
+// Keystores are built with it instead of random salts and IVs, so the same PEM material always builds
+// the same keystore and the K8s Secret is only updated when the material changes. Salts and IVs only need
+// to be unpredictable and not shared by different material, not fresh on every encoding: keyed with the
+// private key, the stream can not be predicted without it, and changes with any of the inputs. Reusing it
+// for the same inputs only reveals that the keystore did not change, which the unchanged Secret shows anyway
+type deterministicReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func newDeterministicReader(key []byte, inputs ...[]byte) *deterministicReader {
+	mac := hmac.New(sha256.New, key)
+	for _, input := range inputs {
+		binary.Write(mac, binary.BigEndian, uint64(len(input)))
+		mac.Write(input)
+	}
+	return &deterministicReader{seed: mac.Sum(nil)}
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			mac := hmac.New(sha256.New, r.seed)
+			binary.Write(mac, binary.BigEndian, r.counter)
+			r.buf = mac.Sum(nil)
+			r.counter++
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// buildKeystore reads the PEM material of the keystore from the backend and builds it in the keystore format
+func (s *SecretManager) buildKeystore(secret SecretDefinition, key string, ks *Keystore) ([]byte, error) {
+	certPEM, err := s.readDatasource(ks.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := s.readDatasource(ks.Key)
+	if err != nil {
+		return nil, err
+	}
+	password, err := s.readDatasource(ks.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := parsePEMCertificates(certPEM)
+	if err != nil {
+		return nil, keystoreError(secret, key, fmt.Sprintf("invalid certificate: %v", err))
+	}
+	privateKey, err := parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, keystoreError(secret, key, fmt.Sprintf("invalid private key: %v", err))
+	}
+	if !keyMatchesCertificate(privateKey, certs[0]) {
+		return nil, keystoreError(secret, key, "private key does not match the certificate")
+	}
+	chain := certs[1:]
+	if ks.CA != nil {
+		caPEM, err := s.readDatasource(*ks.CA)
+		if err != nil {
+			return nil, err
+		}
+		caCerts, err := parsePEMCertificates(caPEM)
+		if err != nil {
+			return nil, keystoreError(secret, key, fmt.Sprintf("invalid CA: %v", err))
+		}
+		chain = append(chain, caCerts...)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, keystoreError(secret, key, err.Error())
+	}
+	alias := ks.Alias
+	if alias == "" {
+		alias = defaultKeystoreAlias
+	}
+	inputs := [][]byte{[]byte(ks.Format), []byte(alias), password, certs[0].Raw}
+	for _, cert := range chain {
+		inputs = append(inputs, cert.Raw)
+	}
+	rand := newDeterministicReader(keyDER, inputs...)
+
+	var data []byte
+	switch ks.Format {
+	case pkcs12KeystoreFormat:
+		data, err = pkcs12.Encode(rand, privateKey, certs[0], chain, string(password))
+	case jksKeystoreFormat:
+		data, err = encodeJKS(rand, alias, keyDER, certs[0], chain, string(password))
+	default:
+		err = fmt.Errorf("keystore format %s not supported", ks.Format)
+	}
+	if err != nil {
+		return nil, keystoreError(secret, key, err.Error())
+	}
+	return data, nil
+}
+
+// extractPKCS12 returns the output PEM material of the PKCS#12 keystore in data
+func (s *SecretManager) extractPKCS12(secret SecretDefinition, key string, data []byte, source *PKCS12Source) ([]byte, error) {
+	var password []byte
+	if source.Password != nil {
+		var err error
+		if password, err = s.readDatasource(*source.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	privateKey, cert, caCerts, err := pkcs12.DecodeChain(data, string(password))
+	if err != nil {
+		return nil, keystoreError(secret, key, err.Error())
+	}
+
+	var out bytes.Buffer
+	switch source.Output {
+	case pkcs12KeyOutput:
+		keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, keystoreError(secret, key, err.Error())
+		}
+		pem.Encode(&out, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	case pkcs12CertificateOutput:
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	case pkcs12CAOutput, pkcs12ChainOutput:
+		if source.Output == pkcs12ChainOutput {
+			pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		} else if len(caCerts) == 0 {
+			return nil, keystoreError(secret, key, "no CA certificate found")
+		}
+		for _, ca := range caCerts {
+			pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+		}
+	default:
+		return nil, keystoreError(secret, key, fmt.Sprintf("fromPKCS12 output %s not supported", source.Output))
+	}
+	return out.Bytes(), nil
+}
+
+// javaPassword returns the password as the UTF-16 big endian bytes Java keystores work with
+func javaPassword(password string) []byte {
+	chars := utf16.Encode([]rune(password))
+	out := make([]byte, 2*len(chars))
+	for i, c := range chars {
+		binary.BigEndian.PutUint16(out[2*i:], c)
+	}
+	return out
+}
+
+// protectJKSKey encrypts the PKCS#8 private key keyDER the way the JKS KeyProtector does: XORed with a
+// SHA-1 keystream seeded by the password and a random salt, followed by a SHA-1 integrity check
+func protectJKSKey(rand io.Reader, keyDER []byte, password []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(keyDER))
+	digest := salt
+	for i := 0; i < len(keyDER); i += sha1.Size {
+		h := sha1.New()
+		h.Write(password)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(keyDER); j++ {
+			encrypted[i+j] = keyDER[i+j] ^ digest[j]
+		}
+	}
+
+	check := sha1.New()
+	check.Write(password)
+	check.Write(keyDER)
+
+	protected := append(append(salt, encrypted...), check.Sum(nil)...)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJavaKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+func writeJKSUTF(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xffff {
+		return fmt.Errorf("string too long for a JKS keystore")
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+// encodeJKS builds a JKS keystore holding a single private key entry, with the certificate and its chain
+func encodeJKS(rand io.Reader, alias string, keyDER []byte, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) ([]byte, error) {
+	jksPassword := javaPassword(password)
+	protectedKey, err := protectJKSKey(rand, keyDER, jksPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&buf, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+
+	binary.Write(&buf, binary.BigEndian, uint32(jksPrivateKeyTag))
+	// Java lower cases aliases of JKS keystores
+	if err := writeJKSUTF(&buf, strings.ToLower(alias)); err != nil {
+		return nil, err
+	}
+	// The certificate start date is used as creation date, to keep the keystore deterministic
+	binary.Write(&buf, binary.BigEndian, uint64(certificate.NotBefore.UnixNano()/1e6))
+	binary.Write(&buf, binary.BigEndian, uint32(len(protectedKey)))
+	buf.Write(protectedKey)
+
+	chain := append([]*x509.Certificate{certificate}, caCerts...)
+	binary.Write(&buf, binary.BigEndian, uint32(len(chain)))
+	for _, cert := range chain {
+		writeJKSUTF(&buf, jksCertType)
+		binary.Write(&buf, binary.BigEndian, uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+
+	digest := sha1.New()
+	digest.Write(jksPassword)
+	digest.Write([]byte(jksDigestWhitener))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,355 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour).Truncate(time.Second),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func newTestKeystore(format string) *Keystore {
+	return &Keystore{
+		Format:      format,
+		Certificate: Datasource{Path: "secret/data/tls", Key: "crt"},
+		Key:         Datasource{Path: "secret/data/tls", Key: "key"},
+		CA:          &Datasource{Path: "secret/data/tls", Key: "ca"},
+		Password:    Datasource{Path: "secret/data/tls", Key: "password"},
+	}
+}
+
+// decodeJKS checks the integrity of a JKS keystore with a single private key entry, returning its alias,
+// PKCS#8 private key and certificate chain
+func decodeJKS(t *testing.T, data []byte, password string) (string, []byte, [][]byte) {
+	jksPassword := javaPassword(password)
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	h := sha1.New()
+	h.Write(jksPassword)
+	h.Write([]byte(jksDigestWhitener))
+	h.Write(body)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		t.Fatal("keystore digest does not match")
+	}
+
+	r := bytes.NewReader(body)
+	readUint32 := func() uint32 {
+		var v uint32
+		binary.Read(r, binary.BigEndian, &v)
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	readUTF := func() string {
+		var n uint16
+		binary.Read(r, binary.BigEndian, &n)
+		return string(readBytes(int(n)))
+	}
+
+	assert.Equal(t, uint32(jksMagic), readUint32())
+	assert.Equal(t, uint32(jksVersion), readUint32())
+	assert.Equal(t, uint32(1), readUint32())
+	assert.Equal(t, uint32(jksPrivateKeyTag), readUint32())
+	alias := readUTF()
+	var date uint64
+	binary.Read(r, binary.BigEndian, &date)
+
+	info := encryptedPrivateKeyInfo{}
+	if _, err := asn1.Unmarshal(readBytes(int(readUint32())), &info); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, oidJavaKeyProtector, info.Algorithm.Algorithm)
+	salt := info.EncryptedData[:sha1.Size]
+	encrypted := info.EncryptedData[sha1.Size : len(info.EncryptedData)-sha1.Size]
+	keyDER := make([]byte, len(encrypted))
+	stream := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New()
+		h.Write(jksPassword)
+		h.Write(stream)
+		stream = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			keyDER[i+j] = encrypted[i+j] ^ stream[j]
+		}
+	}
+	check := sha1.New()
+	check.Write(jksPassword)
+	check.Write(keyDER)
+	assert.Equal(t, info.EncryptedData[len(info.EncryptedData)-sha1.Size:], check.Sum(nil))
+
+	var chain [][]byte
+	for n := readUint32(); n > 0; n-- {
+		assert.Equal(t, jksCertType, readUTF())
+		chain = append(chain, readBytes(int(readUint32())))
+	}
+	assert.Equal(t, 0, r.Len())
+	return alias, keyDER, chain
+}
+
+func TestBuildKeystorePKCS12(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	leaf := newTestCertificate(t, "leaf", ca)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", leaf.certPEM},
+		{"secret/data/tls", "key", leaf.keyPEM},
+		{"secret/data/tls", "ca", ca.certPEM},
+		{"secret/data/tls", "password", "changeit"},
+	})
+	defer stop()
+	secret := SecretDefinition{Name: "java-tls", Data: map[string]Datasource{"keystore.p12": {Keystore: newTestKeystore(pkcs12KeystoreFormat)}}}
+
+	data, err := secretManager.getDesiredState(secret)
+	assert.Nil(t, err)
+
+	key, cert, caCerts, err := pkcs12.DecodeChain(data["keystore.p12"], "changeit")
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.key, key)
+	assert.Equal(t, leaf.cert.Raw, cert.Raw)
+	assert.Equal(t, 1, len(caCerts))
+	assert.Equal(t, ca.cert.Raw, caCerts[0].Raw)
+
+	// Building the same keystore again must not change the secret
+	again, err := secretManager.getDesiredState(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, data, again)
+}
+
+func TestBuildKeystoreJKS(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	leaf := newTestCertificate(t, "leaf", ca)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", leaf.certPEM},
+		{"secret/data/tls", "key", leaf.keyPEM},
+		{"secret/data/tls", "ca", ca.certPEM},
+		{"secret/data/tls", "password", "changeit"},
+	})
+	defer stop()
+	ks := newTestKeystore(jksKeystoreFormat)
+	ks.Alias = "Server"
+
+	data, err := secretManager.buildKeystore(SecretDefinition{Name: "java-tls"}, "keystore.jks", ks)
+	assert.Nil(t, err)
+
+	alias, keyDER, chain := decodeJKS(t, data, "changeit")
+	assert.Equal(t, "server", alias)
+	expectedKeyDER, _ := x509.MarshalPKCS8PrivateKey(leaf.key)
+	assert.Equal(t, expectedKeyDER, keyDER)
+	assert.Equal(t, [][]byte{leaf.cert.Raw, ca.cert.Raw}, chain)
+
+	again, err := secretManager.buildKeystore(SecretDefinition{Name: "java-tls"}, "keystore.jks", ks)
+	assert.Nil(t, err)
+	assert.Equal(t, data, again)
+}
+
+// TestEncodeJKSKeytool checks encodeJKS against testdata/keytool.jks, taken with its certificate and key from the
+// test data of the gocql driver, where keytool builds it from testdata/keytool.crt and testdata/keytool.key with:
+//
+//	keytool -importkeystore -srckeystore keytool.p12 -srcstoretype PKCS12 -srcstorepass cassandra \
+//		-srcalias cassandra -destkeystore keytool.jks -deststoretype JKS -deststorepass cassandra -destalias cassandra
+//
+// Fed the salt keytool used, encodeJKS must build the same keystore but for its creation date
+func TestEncodeJKSKeytool(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/keytool.jks")
+	assert.Nil(t, err)
+	certPEM, err := ioutil.ReadFile("testdata/keytool.crt")
+	assert.Nil(t, err)
+	keyPEM, err := ioutil.ReadFile("testdata/keytool.key")
+	assert.Nil(t, err)
+
+	alias, keyDER, chain := decodeJKS(t, golden, "cassandra")
+	assert.Equal(t, "cassandra", alias)
+	certs, err := parsePEMCertificates(certPEM)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{certs[0].Raw}, chain)
+	privateKey, err := parsePEMPrivateKey(keyPEM)
+	assert.Nil(t, err)
+	expectedKeyDER, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Equal(t, expectedKeyDER, keyDER)
+
+	// The entry starts after the header, the alias and the creation date, with the salt of the protected key
+	dateOffset := 4*4 + 2 + len(alias)
+	entryOffset := dateOffset + 8 + 4
+	info := encryptedPrivateKeyInfo{}
+	_, err = asn1.Unmarshal(golden[entryOffset:], &info)
+	assert.Nil(t, err)
+	salt := info.EncryptedData[:sha1.Size]
+
+	data, err := encodeJKS(bytes.NewReader(salt), "Cassandra", keyDER, certs[0], nil, "cassandra")
+	assert.Nil(t, err)
+	decodeJKS(t, data, "cassandra")
+	copy(data[dateOffset:dateOffset+8], golden[dateOffset:dateOffset+8])
+	assert.Equal(t, golden[:len(golden)-sha1.Size], data[:len(data)-sha1.Size])
+}
+
+func TestBuildKeystoreKeyMismatch(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+	other := newTestCertificate(t, "other", nil)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", leaf.certPEM},
+		{"secret/data/tls", "key", other.keyPEM},
+		{"secret/data/tls", "password", "changeit"},
+	})
+	defer stop()
+	ks := newTestKeystore(pkcs12KeystoreFormat)
+	ks.CA = nil
+
+	_, err := secretManager.buildKeystore(SecretDefinition{Name: "java-tls"}, "keystore.p12", ks)
+	assert.True(t, e.IsKeystore(err))
+	assert.Contains(t, err.Error(), "private key does not match the certificate")
+}
+
+func TestBuildKeystoreInvalidCertificate(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", "not a certificate"},
+		{"secret/data/tls", "key", leaf.keyPEM},
+		{"secret/data/tls", "password", "changeit"},
+	})
+	defer stop()
+	ks := newTestKeystore(jksKeystoreFormat)
+	ks.CA = nil
+
+	_, err := secretManager.buildKeystore(SecretDefinition{Name: "java-tls"}, "keystore.jks", ks)
+	assert.True(t, e.IsKeystore(err))
+}
+
+func TestExtractPKCS12(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	leaf := newTestCertificate(t, "leaf", ca)
+	p12, err := pkcs12.Encode(rand.Reader, leaf.key, leaf.cert, []*x509.Certificate{ca.cert}, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/p12", "keystore", base64.StdEncoding.EncodeToString(p12)},
+		{"secret/data/p12", "password", "s3cr3t"},
+	})
+	defer stop()
+	source := func(output string) Datasource {
+		return Datasource{
+			Path:       "secret/data/p12",
+			Key:        "keystore",
+			Encoding:   "base64",
+			FromPKCS12: &PKCS12Source{Password: &Datasource{Path: "secret/data/p12", Key: "password"}, Output: output},
+		}
+	}
+
+	data, err := secretManager.getDesiredState(SecretDefinition{
+		Name: "tls",
+		Data: map[string]Datasource{
+			"tls.crt": source(pkcs12CertificateOutput),
+			"tls.key": source(pkcs12KeyOutput),
+			"ca.crt":  source(pkcs12CAOutput),
+			"chain":   source(pkcs12ChainOutput),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.certPEM, string(data["tls.crt"]))
+	assert.Equal(t, ca.certPEM, string(data["ca.crt"]))
+	assert.Equal(t, leaf.certPEM+ca.certPEM, string(data["chain"]))
+	key, err := parsePEMPrivateKey(data["tls.key"])
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.key, key)
+}
+
+func TestExtractPKCS12WrongPassword(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+	p12, err := pkcs12.Encode(rand.Reader, leaf.key, leaf.cert, nil, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, nil)
+	defer stop()
+
+	_, err = secretManager.extractPKCS12(SecretDefinition{Name: "tls"}, "tls.crt", p12, &PKCS12Source{Output: pkcs12CertificateOutput})
+	assert.True(t, e.IsKeystore(err))
+
+	_, err = secretManager.extractPKCS12(SecretDefinition{Name: "tls"}, "ca.crt", p12, &PKCS12Source{Output: pkcs12CAOutput})
+	assert.True(t, e.IsKeystore(err))
+}
+
+func TestValidateKeystores(t *testing.T) {
+	invalidFormat := newTestKeystore("pem")
+	noPassword := newTestKeystore(jksKeystoreFormat)
+	noPassword.Password = Datasource{}
+	templatedKey := newTestKeystore(jksKeystoreFormat)
+	templatedKey.Key = Datasource{Template: "{{ .key }}"}
+
+	for _, v := range []Datasource{
+		{Keystore: invalidFormat},
+		{Keystore: noPassword},
+		{Keystore: templatedKey},
+		{Keystore: newTestKeystore(jksKeystoreFormat), Template: "{{ .foo }}"},
+		{Path: "secret/data/p12", Key: "keystore", FromPKCS12: &PKCS12Source{Output: "der"}},
+	} {
+		secretDef := SecretDefinition{Name: "java-tls", Data: map[string]Datasource{"keystore": v}}
+		assert.True(t, e.IsKeystore(secretDef.validate()))
+	}
+
+	secretDef := SecretDefinition{Name: "java-tls", Data: map[string]Datasource{
+		"keystore.jks": {Keystore: newTestKeystore(jksKeystoreFormat)},
+		"tls.crt":      {Path: "secret/data/p12", Key: "keystore", FromPKCS12: &PKCS12Source{Output: pkcs12ChainOutput}},
+	}}
+	assert.Nil(t, secretDef.validate())
+}
@@ -0,0 +1,133 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const lastUpdateLabel = "lastUpdate"
+
+// reservedAnnotationPrefix is the prefix of the labels and annotations written by secrets-manager itself
+const reservedAnnotationPrefix = "secrets-manager.tuenti.io/"
+
+// metadataTemplateValues are the values available to label and annotation templates
+type metadataTemplateValues struct {
+	// Name of the K8s Secret
+	Name string
+	// Namespace of the K8s Secret
+	Namespace string
+}
+
+func metadataError(d SecretDefinition, key string, reason string) error {
+	return &errors.InvalidSecretMetadataError{ErrType: errors.InvalidSecretMetadataErrorType, Name: d.Name, Key: key, Reason: reason}
+}
+
+// validateMetadata checks that the labels and annotations of the secret definition have valid keys, not
+// reserved by secrets-manager, and well formed templates
+func (d SecretDefinition) validateMetadata() error {
+	for key, value := range d.Labels {
+		if key == k8s.ManagedByLabel || key == lastUpdateLabel || strings.HasPrefix(key, reservedAnnotationPrefix) {
+			return metadataError(d, key, "label reserved by secrets-manager")
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return metadataError(d, key, strings.Join(errs, ", "))
+		}
+		if _, err := parseTemplate(key, value); err != nil {
+			return metadataError(d, key, err.Error())
+		}
+	}
+	for key, value := range d.Annotations {
+		if strings.HasPrefix(key, reservedAnnotationPrefix) {
+			return metadataError(d, key, "annotation reserved by secrets-manager")
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return metadataError(d, key, strings.Join(errs, ", "))
+		}
+		if _, err := parseTemplate(key, value); err != nil {
+			return metadataError(d, key, err.Error())
+		}
+	}
+	return nil
+}
+
+// renderMetadata renders the labels and annotations of the secret definition for the secret in namespace
+func (d SecretDefinition) renderMetadata(namespace string) (map[string]string, map[string]string, error) {
+	values := metadataTemplateValues{Name: d.Name, Namespace: namespace}
+	labels, err := renderMetadataTemplates(d, d.Labels, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, value := range labels {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, nil, metadataError(d, key, strings.Join(errs, ", "))
+		}
+	}
+	annotations, err := renderMetadataTemplates(d, d.Annotations, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return labels, annotations, nil
+}
+
+func renderMetadataTemplates(d SecretDefinition, templates map[string]string, values metadataTemplateValues) (map[string]string, error) {
+	rendered := make(map[string]string, len(templates))
+	for key, text := range templates {
+		tmpl, err := parseTemplate(key, text)
+		if err != nil {
+			return nil, metadataError(d, key, err.Error())
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, values); err != nil {
+			return nil, &errors.TemplateRenderError{ErrType: errors.TemplateRenderErrorType, Name: d.Name, Key: key, Reason: err.Error()}
+		}
+		rendered[key] = out.String()
+	}
+	return rendered, nil
+}
+
+// hasMetadata returns true if every key of expected is set to the same value in current
+func hasMetadata(current map[string]string, expected map[string]string) bool {
+	for key, value := range expected {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// hasManagedKeys returns true if the current secret records exactly the keys of labels and annotations, along with the
+// labels added to every secret, as the ones written by secrets-manager, so that a label or annotation no longer
+// desired is a change too
+func hasManagedKeys(current *k8s.Secret, labels map[string]string, annotations map[string]string) bool {
+	managed := current.ManagedKeys()
+	written := mergeMetadata(labels, map[string]string{k8s.ManagedByLabel: "", lastUpdateLabel: ""})
+	return sameKeys(managed.Labels, written) && sameKeys(managed.Annotations, annotations)
+}
+
+// sameKeys returns true if keys are exactly the keys of metadata
+func sameKeys(keys []string, metadata map[string]string) bool {
+	if len(keys) != len(metadata) {
+		return false
+	}
+	for _, k := range keys {
+		if _, ok := metadata[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeMetadata returns a new map with the entries of every map, later maps taking precedence
+func mergeMetadata(maps ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,164 @@
+package secretsmanager
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	valid := SecretDefinition{
+		Name:        "db",
+		Labels:      map[string]string{"app.example.com/team": "payments", "env": "{{ .Namespace }}"},
+		Annotations: map[string]string{"description": "Credentials of {{ .Name }} in {{ .Namespace }}"},
+	}
+	assert.Nil(t, valid.validateMetadata())
+
+	invalid := []SecretDefinition{
+		{Name: "db", Labels: map[string]string{"managedBy": "me"}},
+		{Name: "db", Labels: map[string]string{"lastUpdate": "now"}},
+		{Name: "db", Labels: map[string]string{"secrets-manager.tuenti.io/mirror": "other"}},
+		{Name: "db", Labels: map[string]string{"not a key": "value"}},
+		{Name: "db", Labels: map[string]string{"env": "{{ .Namespace"}},
+		{Name: "db", Annotations: map[string]string{"secrets-manager.tuenti.io/backend-versions": "{}"}},
+		{Name: "db", Annotations: map[string]string{"owner": "{{ end }}"}},
+	}
+	for _, d := range invalid {
+		err := d.validateMetadata()
+		assert.True(t, e.IsInvalidSecretMetadata(err), "expected invalid metadata for %v, got %v", d, err)
+	}
+}
+
+func TestRenderMetadata(t *testing.T) {
+	d := SecretDefinition{
+		Name:        "db",
+		Labels:      map[string]string{"team": "payments", "env": "{{ .Namespace }}"},
+		Annotations: map[string]string{"description": "Credentials of {{ .Name }} in {{ .Namespace }}"},
+	}
+
+	labels, annotations, err := d.renderMetadata("staging")
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "staging"}, labels)
+	assert.Equal(t, map[string]string{"description": "Credentials of db in staging"}, annotations)
+}
+
+func TestRenderMetadataInvalidLabelValue(t *testing.T) {
+	d := SecretDefinition{
+		Name:   "db",
+		Labels: map[string]string{"description": "{{ .Name }} in {{ .Namespace }}"},
+	}
+
+	_, _, err := d.renderMetadata("staging")
+
+	assert.True(t, e.IsInvalidSecretMetadata(err))
+}
+
+func TestRenderMetadataError(t *testing.T) {
+	d := SecretDefinition{
+		Name:   "db",
+		Labels: map[string]string{"env": "{{ .Cluster }}"},
+	}
+
+	_, _, err := d.renderMetadata("staging")
+
+	assert.True(t, e.IsTemplateRender(err))
+}
+
+func TestParseSecretDefsWithMetadata(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml(`
+- name: db
+  namespaces:
+  - ns
+  labels:
+    team: payments
+  annotations:
+    description: "{{ .Name }} credentials"
+  data:
+    password:
+      path: secret/data/db
+      key: password
+`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "payments"}, defs[0].Labels)
+	assert.Equal(t, map[string]string{"description": "{{ .Name }} credentials"}, defs[0].Annotations)
+
+	_, err = parseSecretDefsFromYaml(`
+- name: db
+  labels:
+    managedBy: me
+`)
+	assert.True(t, e.IsInvalidSecretMetadata(err))
+}
+
+func TestSyncStateMetadata(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "db",
+			Namespace:   "ns",
+			Labels:      map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue, "team": "payments", "env": "ns"},
+			Annotations: withManagedKeys(map[string]string{"team": "", "env": ""}, map[string]string{"description": "db in ns"}),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"password": []byte("s3cr3t")},
+	})
+	logger := log.New()
+	k8s := kubernetes.New(clientSet, logger)
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/db", "password", "s3cr3t"},
+	})
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm"}, k8s, fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:        "db",
+		Namespaces:  []string{"ns"},
+		Type:        "Opaque",
+		Labels:      map[string]string{"team": "payments", "env": "{{ .Namespace }}"},
+		Annotations: map[string]string{"description": "{{ .Name }} in {{ .Namespace }}"},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+
+	// Data, labels and annotations in sync, nothing to update
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	assert.Len(t, clientSet.Actions(), 1)
+
+	// A label drifts
+	secret, _ := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	secret.Labels["team"] = "platform"
+	clientSet.CoreV1().Secrets("ns").Update(secret)
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	secret, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, "payments", secret.Labels["team"])
+	assert.Equal(t, "ns", secret.Labels["env"])
+	assert.Equal(t, kubernetes.ManagedByValue, secret.Labels[kubernetes.ManagedByLabel])
+	assert.Contains(t, secret.Labels, lastUpdateLabel)
+	assert.Equal(t, withManagedKeys(map[string]string{"team": "", "env": ""}, map[string]string{"description": "db in ns"}), secret.Annotations)
+
+	// A label and an annotation are removed from the definition
+	definition.Labels = map[string]string{"team": "payments"}
+	definition.Annotations = nil
+	clientSet.ClearActions()
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	updated := false
+	for _, action := range clientSet.Actions() {
+		updated = updated || action.GetVerb() == "update"
+	}
+	assert.True(t, updated)
+	secret, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, "payments", secret.Labels["team"])
+	assert.NotContains(t, secret.Labels, "env")
+	assert.Equal(t, withManagedKeys(map[string]string{"team": ""}, nil), secret.Annotations)
+}
@@ -19,9 +19,80 @@ var (
 		Name:      "last_updated",
 		Help:      "The last update timestamp as a Unix time (the number of seconds elapsed since January 1, 1970 UTC)",
 	}, []string{"name", "namespace"})
+
+	secretTLSValidationErrorsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "secret",
+		Name:      "tls_validation_errors_count",
+		Help:      "Counter of kubernetes.io/tls secrets not written because of an invalid certificate or private key",
+	}, []string{"name", "namespace"})
+
+	secretTLSNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "secret",
+		Name:      "tls_not_after",
+		Help:      "The expiration timestamp of the certificate of kubernetes.io/tls secrets as a Unix time",
+	}, []string{"name", "namespace"})
+
+	secretPruneCandidateSince = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "secret",
+		Name:      "prune_candidate_since",
+		Help:      "The time a secret managed by secrets-manager was first found no longer defined, as a Unix time",
+	}, []string{"name", "namespace"})
+
+	secretPrunedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "secret",
+		Name:      "pruned_count",
+		Help:      "Counter of secrets no longer defined that were orphaned or deleted",
+	}, []string{"namespace", "policy"})
+
+	configMapPruneCandidateSince = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "configmap",
+		Name:      "prune_candidate_since",
+		Help:      "The time a configmap managed by secrets-manager was first found no longer defined, as a Unix time",
+	}, []string{"name", "namespace"})
+
+	configMapPrunedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "configmap",
+		Name:      "pruned_count",
+		Help:      "Counter of configmaps no longer defined that were orphaned or deleted",
+	}, []string{"namespace", "policy"})
+
+	configResourceVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "config",
+		Name:      "resource_version",
+		Help:      "The resourceVersion of the config source the secret definitions were last loaded at",
+	}, []string{"source"})
+
+	workloadRestartCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "workload",
+		Name:      "restart_count",
+		Help:      "Counter of Deployments, StatefulSets and DaemonSets restarted because a secret they consume changed",
+	}, []string{"kind", "namespace"})
+	secretGenerationDeletedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "secrets_manager",
+		Subsystem: "secret",
+		Name:      "generation_deleted_count",
+		Help:      "Counter of old generations of immutable secrets deleted",
+	}, []string{"name", "namespace"})
 )
 
 func init() {
 	prometheus.MustRegister(secretSyncErrorsCount)
 	prometheus.MustRegister(secretLastUpdated)
+	prometheus.MustRegister(secretTLSValidationErrorsCount)
+	prometheus.MustRegister(secretTLSNotAfter)
+	prometheus.MustRegister(secretPruneCandidateSince)
+	prometheus.MustRegister(secretPrunedCount)
+	prometheus.MustRegister(configMapPruneCandidateSince)
+	prometheus.MustRegister(configMapPrunedCount)
+	prometheus.MustRegister(configResourceVersion)
+	prometheus.MustRegister(workloadRestartCount)
+	prometheus.MustRegister(secretGenerationDeletedCount)
 }
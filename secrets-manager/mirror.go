@@ -0,0 +1,245 @@
+package secretsmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tuenti/secrets-manager/backend"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// mirrorLabel is the label of the secrets written by a mirror definition, with the mirrorID of the definition
+const mirrorLabel = reservedAnnotationPrefix + "mirror"
+
+var invalidSecretNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// mirrorSecretName builds a DNS-1123 subdomain from a secret path relative to a mirror, prefixed with prefix.
+// It returns false if no valid name can be built
+func mirrorSecretName(prefix string, relativePath string) (string, bool) {
+	name := invalidSecretNameChars.ReplaceAllString(strings.ToLower(prefix+relativePath), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength], "-.")
+	}
+	return name, len(validation.IsDNS1123Subdomain(name)) == 0
+}
+
+// mirrorID identifies a mirror definition in the mirrorLabel of the secrets it writes. Mirror names are prefixes,
+// often ending with '-', and custom resources are namespaced, so neither always make a valid label value
+func mirrorID(secret SecretDefinition) string {
+	sum := sha256.Sum256([]byte(secret.key()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// matchesMirror returns true if the secret at relativePath is included and not excluded by the mirror globs
+func matchesMirror(mirror *Mirror, relativePath string) bool {
+	included := len(mirror.Include) == 0
+	for _, pattern := range mirror.Include {
+		if matched, err := path.Match(pattern, relativePath); err != nil {
+			logger.Warnf("invalid mirror include pattern '%s': %v", pattern, err)
+		} else if matched {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range mirror.Exclude {
+		if matched, err := path.Match(pattern, relativePath); err != nil {
+			logger.Warnf("invalid mirror exclude pattern '%s': %v", pattern, err)
+		} else if matched {
+			return false
+		}
+	}
+	return true
+}
+
+// getMirrorSecrets lists the backend secrets under the mirror path, returning their paths by K8s Secret name
+func (s *SecretManager) getMirrorSecrets(secret SecretDefinition) (map[string]string, error) {
+	mirrorPath := strings.Trim(secret.Mirror.Path, "/")
+	paths, err := s.backend.ListSecrets(mirrorPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	secrets := make(map[string]string, len(paths))
+	for _, p := range paths {
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(p, mirrorPath), "/")
+		if !matchesMirror(secret.Mirror, relativePath) {
+			continue
+		}
+		name, ok := mirrorSecretName(secret.Name, relativePath)
+		if !ok {
+			logger.Warnf("skipping mirrored secret '%s': '%s' is not a valid secret name", p, name)
+			continue
+		}
+		if other, ok := secrets[name]; ok {
+			logger.Warnf("skipping mirrored secret '%s': secret name '%s' already used by '%s'", p, name, other)
+			continue
+		}
+		secrets[name] = p
+	}
+	return secrets, nil
+}
+
+// getMirrorDesiredState reads every key of the backend secret at secretPath, returning the definition of
+// the secret it is mirrored to along with its desired state
+func (s *SecretManager) getMirrorDesiredState(secret SecretDefinition, name string, secretPath string, decoder backend.Decoder) (SecretDefinition, map[string][]byte, error) {
+	mirrored := SecretDefinition{
+		Name:            name,
+		Namespaces:      secret.Namespaces,
+		Type:            secret.Type,
+		BackendMetadata: secret.BackendMetadata,
+		Adopt:           secret.Adopt,
+		Labels:          mergeMetadata(secret.Labels, map[string]string{mirrorLabel: mirrorID(secret)}),
+		owner:           secret.key(),
+		Annotations:     secret.Annotations,
+		RestartPolicy:   secret.RestartPolicy,
+		UpdateStrategy:  secret.UpdateStrategy,
+		resource:        secret.resource,
+		Data:            make(map[string]Datasource),
+	}
+
+	data, err := s.backend.ReadSecretData(secretPath)
+	if err != nil {
+		logger.Errorf("unable to read secret '%s' from backend: %v", secretPath, err)
+		return mirrored, nil, err
+	}
+
+	desiredState := make(map[string][]byte, len(data))
+	for k, v := range data {
+		mirrored.Data[k] = Datasource{Path: secretPath, Key: k, Encoding: secret.Mirror.Encoding}
+		desiredState[k], err = decoder.DecodeString(v)
+		if err != nil {
+			logger.Errorf("unable to decode %s data for '%s/%s': %v", secret.Mirror.Encoding, secretPath, k, err)
+			return mirrored, nil, err
+		}
+	}
+	if err := checkTLSSecret(mirrored, desiredState); err != nil {
+		logger.Errorf("refusing to write secret '%s': %v", name, err)
+		return mirrored, nil, err
+	}
+	return mirrored, desiredState, nil
+}
+
+// syncMirror syncs every backend secret under the mirror path, deleting the secrets labelled as written by the
+// mirror for backend secrets that are gone. It stops before the next write once ctx is done
+func (s *SecretManager) syncMirror(ctx context.Context, secret SecretDefinition) error {
+	decoder, err := backend.NewDecoder(secret.Mirror.Encoding)
+	if err != nil {
+		logger.Errorf("refusing to use encoding %s: %v", secret.Mirror.Encoding, err)
+		for _, namespace := range secret.Namespaces {
+			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.BackendReadFailedReason, err)
+		}
+		s.reportStatus(secret, syncResult{backendErr: err})
+		return err
+	}
+
+	mirrorSecrets, err := s.getMirrorSecrets(secret)
+	if err != nil {
+		logger.Errorf("unable to list secrets under '%s' for mirror '%s': %v", secret.Mirror.Path, secret.Name, err)
+		for _, namespace := range secret.Namespaces {
+			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.BackendReadFailedReason, err)
+		}
+		s.reportStatus(secret, syncResult{backendErr: err})
+		return err
+	}
+
+	// A namespace is synced when every mirrored secret is synced in it
+	result := syncResult{}
+	failed := make(map[string]bool)
+	for name, secretPath := range mirrorSecrets {
+		mirrored, desiredState, err := s.getMirrorDesiredState(secret, name, secretPath, decoder)
+		if err != nil {
+			logger.Errorf("unable to get desired state for secret '%s' : %v", name, err)
+			for _, namespace := range secret.Namespaces {
+				secretSyncErrorsCount.WithLabelValues(name, namespace).Inc()
+				s.recordFailure(mirrored, namespace, k8s.BackendReadFailedReason, err)
+				failed[namespace] = true
+			}
+			result.backendErr = err
+			continue
+		}
+		synced, err := s.writeState(ctx, mirrored, desiredState)
+		if ctx.Err() != nil {
+			logger.Infof("sync of mirror '%s' cancelled", secret.Name)
+			return ctx.Err()
+		}
+		if err != nil {
+			result.kubernetesErr = err
+			writes := make(map[string]bool, len(synced))
+			for _, namespace := range synced {
+				writes[namespace] = true
+			}
+			for _, namespace := range secret.Namespaces {
+				failed[namespace] = failed[namespace] || !writes[namespace]
+			}
+		}
+	}
+
+	if err := s.deleteGoneMirrorSecrets(ctx, secret, mirrorSecrets, failed); err != nil {
+		result.kubernetesErr = err
+	}
+	if ctx.Err() != nil {
+		logger.Infof("sync of mirror '%s' cancelled", secret.Name)
+		return ctx.Err()
+	}
+
+	for _, namespace := range secret.Namespaces {
+		if !failed[namespace] {
+			result.synced = append(result.synced, namespace)
+		}
+	}
+	s.reportStatus(secret, result)
+	return nil
+}
+
+// deleteGoneMirrorSecrets deletes the secrets labelled as written by the mirror, in any namespace, that are not
+// in mirrorSecrets anymore, marking the namespaces where they could not be deleted as failed. It stops before the
+// next delete once ctx is done
+func (s *SecretManager) deleteGoneMirrorSecrets(ctx context.Context, secret SecretDefinition, mirrorSecrets map[string]string, failed map[string]bool) error {
+	written, err := s.kubernetes.ListSecrets(metav1.NamespaceAll, map[string]string{k8s.ManagedByLabel: k8s.ManagedByValue, mirrorLabel: mirrorID(secret)})
+	if err != nil {
+		logger.Errorf("unable to list the secrets written by mirror '%s': %v", secret.Name, err)
+		for _, namespace := range secret.Namespaces {
+			failed[namespace] = true
+		}
+		return err
+	}
+
+	namespaces := make(map[string]bool, len(secret.Namespaces))
+	for _, namespace := range secret.Namespaces {
+		namespaces[namespace] = true
+	}
+	var lastErr error
+	for _, current := range written {
+		if _, ok := mirrorSecrets[current.Name]; ok && namespaces[current.Namespace] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Infof("secret '%s/%s' is gone from the backend, deleting it", current.Namespace, current.Name)
+		if err := s.kubernetes.DeleteSecret(current.Namespace, current.Name); err != nil {
+			logger.Errorf("unable to delete secret '%s/%s': %v", current.Namespace, current.Name, err)
+			secretSyncErrorsCount.WithLabelValues(current.Name, current.Namespace).Inc()
+			failed[current.Namespace] = true
+			lastErr = err
+			continue
+		}
+		logger.Infof("secret '%s/%s' deleted", current.Namespace, current.Name)
+		s.deleteTLSNotAfter(current.Namespace, current.Name)
+	}
+	return lastErr
+}
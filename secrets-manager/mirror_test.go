@@ -0,0 +1,128 @@
+package secretsmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMirrorSecretName(t *testing.T) {
+	tests := []struct {
+		prefix       string
+		relativePath string
+		name         string
+		valid        bool
+	}{
+		{"", "db", "db", true},
+		{"team-a-", "nested/API_Key", "team-a-nested-api-key", true},
+		{"", "/db/", "db", true},
+		{"", "config.v2", "config.v2", true},
+		{"", "__", "", false},
+	}
+
+	for _, test := range tests {
+		name, valid := mirrorSecretName(test.prefix, test.relativePath)
+		assert.Equal(t, test.name, name)
+		assert.Equal(t, test.valid, valid)
+	}
+}
+
+func TestMatchesMirror(t *testing.T) {
+	mirror := &Mirror{
+		Include: []string{"db", "apps/*"},
+		Exclude: []string{"apps/legacy-*"},
+	}
+
+	assert.True(t, matchesMirror(mirror, "db"))
+	assert.True(t, matchesMirror(mirror, "apps/web"))
+	assert.False(t, matchesMirror(mirror, "apps/legacy-web"))
+	assert.False(t, matchesMirror(mirror, "apps/web/nested"))
+	assert.False(t, matchesMirror(mirror, "other"))
+	assert.True(t, matchesMirror(&Mirror{}, "other"))
+}
+
+func TestSyncMirror(t *testing.T) {
+	secretSyncErrorsCount.Reset()
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/team/db", "user", "admin"},
+		{"secret/data/team/db", "password", "s3cr3t"},
+		{"secret/data/team/apps/web", "token", "t0k3n"},
+		{"secret/data/team/apps/legacy-web", "token", "old"},
+	})
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, kubernetes.New(client, logger), fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:       "team-",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Mirror: &Mirror{
+			Path:    "secret/data/team/",
+			Exclude: []string{"apps/legacy-*"},
+		},
+	}
+	err := secretManager.syncState(context.Background(), definition)
+
+	assert.Nil(t, err)
+	db, err := client.CoreV1().Secrets("ns").Get("team-db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"user": []byte("admin"), "password": []byte("s3cr3t")}, db.Data)
+	assert.Equal(t, mirrorID(definition), db.Labels[mirrorLabel])
+	web, err := client.CoreV1().Secrets("ns").Get("team-apps-web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"token": []byte("t0k3n")}, web.Data)
+	_, err = client.CoreV1().Secrets("ns").Get("team-apps-legacy-web", metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	// Secrets gone from the backend are deleted, even after a restart, but not the other secrets with the same prefix
+	client.CoreV1().Secrets("ns").Create(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "team-other",
+		Namespace: "ns",
+		Labels:    map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue},
+	}})
+	secretManager, _ = New(ctx, cfg, kubernetes.New(client, logger), newFakeBackend([]fakeBackendSecret{
+		{"secret/data/team/apps/web", "token", "t0k3n"},
+	}), logger)
+	err = secretManager.syncState(context.Background(), definition)
+
+	assert.Nil(t, err)
+	_, err = client.CoreV1().Secrets("ns").Get("team-db", metav1.GetOptions{})
+	assert.NotNil(t, err)
+	_, err = client.CoreV1().Secrets("ns").Get("team-apps-web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = client.CoreV1().Secrets("ns").Get("team-other", metav1.GetOptions{})
+	assert.Nil(t, err)
+	metricSecretSyncErrorsCount, _ := secretSyncErrorsCount.GetMetricWithLabelValues("team-db", "ns")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretSyncErrorsCount))
+}
+
+func TestSyncMirrorEncodingNotImplemented(t *testing.T) {
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, kubernetes.New(fake.NewSimpleClientset(), logger), fakeBackend, logger)
+
+	err := secretManager.syncState(context.Background(), SecretDefinition{
+		Name:       "team-",
+		Namespaces: []string{"ns"},
+		Mirror: &Mirror{
+			Path:     "secret/data/team",
+			Encoding: "base65",
+		},
+	})
+
+	assert.NotNil(t, err)
+}
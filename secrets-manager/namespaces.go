@@ -0,0 +1,113 @@
+package secretsmanager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tuenti/secrets-manager/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceSelector selects the namespaces of a secret by their labels, as a Kubernetes label selector
+type NamespaceSelector struct {
+	// MatchLabels is a map of labels the namespace must have. Optional
+	MatchLabels map[string]string `yaml:"matchLabels,omitempty"`
+	// MatchExpressions is a list of label selector requirements the namespace must match. Optional
+	MatchExpressions []LabelSelectorRequirement `yaml:"matchExpressions,omitempty"`
+}
+
+// LabelSelectorRequirement is a requirement of a NamespaceSelector, such as env In (prod, staging)
+type LabelSelectorRequirement struct {
+	// Key is the label key the requirement applies to
+	Key string `yaml:"key"`
+	// Operator is In, NotIn, Exists or DoesNotExist
+	Operator string `yaml:"operator"`
+	// Values of the label for In and NotIn. Optional
+	Values []string `yaml:"values,omitempty"`
+}
+
+// selector converts the namespace selector into a labels.Selector
+func (n *NamespaceSelector) selector() (labels.Selector, error) {
+	labelSelector := &metav1.LabelSelector{MatchLabels: n.MatchLabels}
+	for _, r := range n.MatchExpressions {
+		labelSelector.MatchExpressions = append(labelSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      r.Key,
+			Operator: metav1.LabelSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return metav1.LabelSelectorAsSelector(labelSelector)
+}
+
+// validateNamespaceSelector checks that the namespace selector of the secret definition is a valid label selector
+func (d SecretDefinition) validateNamespaceSelector() error {
+	if d.NamespaceSelector == nil {
+		return nil
+	}
+	if _, err := d.NamespaceSelector.selector(); err != nil {
+		return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: fmt.Sprintf("invalid namespaceSelector: %v", err)}
+	}
+	return nil
+}
+
+// startNamespaceWatch watches the namespaces of the cluster the first time it is called, syncing the secrets as
+// soon as a namespace is added or relabelled
+func (s *SecretManager) startNamespaceWatch() {
+	s.namespaceWatchOnce.Do(func() {
+		logger.Infof("watching namespaces for the namespaceSelector of secret definitions")
+		s.namespaceWatchDone = s.kubernetes.WatchNamespaces(func(resourceVersion string) {
+			select {
+			case s.syncNow <- struct{}{}:
+			default:
+			}
+		}, s.done)
+	})
+}
+
+// resolveNamespaces returns a copy of the secret definition whose Namespaces are its explicit namespaces along
+// with the ones matching its namespace selector, without its excluded namespaces nor the terminating ones
+func (s *SecretManager) resolveNamespaces(secret SecretDefinition) (SecretDefinition, error) {
+	if secret.NamespaceSelector == nil && len(secret.ExcludeNamespaces) == 0 {
+		return secret, nil
+	}
+
+	excluded := make(map[string]bool, len(secret.ExcludeNamespaces))
+	for _, namespace := range secret.ExcludeNamespaces {
+		excluded[namespace] = true
+	}
+	targets := make(map[string]bool, len(secret.Namespaces))
+	for _, namespace := range secret.Namespaces {
+		targets[namespace] = true
+	}
+
+	if secret.NamespaceSelector != nil {
+		selector, err := secret.NamespaceSelector.selector()
+		if err != nil {
+			return secret, err
+		}
+		s.startNamespaceWatch()
+		namespaces, err := s.kubernetes.ListNamespaces()
+		if err != nil {
+			return secret, err
+		}
+		for _, namespace := range namespaces {
+			switch {
+			case namespace.Terminating:
+				excluded[namespace.Name] = true
+			case selector.Matches(labels.Set(namespace.Labels)):
+				targets[namespace.Name] = true
+			}
+		}
+	}
+
+	resolved := secret
+	resolved.Namespaces = make([]string, 0, len(targets))
+	for namespace := range targets {
+		if !excluded[namespace] {
+			resolved.Namespaces = append(resolved.Namespaces, namespace)
+		}
+	}
+	sort.Strings(resolved.Namespaces)
+	return resolved, nil
+}
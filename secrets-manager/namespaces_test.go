@@ -0,0 +1,183 @@
+package secretsmanager
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newFakeNamespace(name string, labels map[string]string, phase corev1.NamespacePhase) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     corev1.NamespaceStatus{Phase: phase},
+	}
+}
+
+// newNamespacesSecretManager returns a secret manager with the prod, prod-eu, staging and terminating prod-old
+// namespaces in Kubernetes, and the function stopping it
+func newNamespacesSecretManager(t *testing.T) (*SecretManager, *fake.Clientset, func()) {
+	clientSet := fake.NewSimpleClientset(
+		newFakeNamespace("prod", map[string]string{"env": "prod"}, corev1.NamespaceActive),
+		newFakeNamespace("prod-eu", map[string]string{"env": "prod", "region": "eu"}, corev1.NamespaceActive),
+		newFakeNamespace("staging", map[string]string{"env": "staging"}, corev1.NamespaceActive),
+		newFakeNamespace("prod-old", map[string]string{"env": "prod"}, corev1.NamespaceTerminating),
+	)
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(clientSet, log.New()), nil)
+	return secretManager, clientSet, stop
+}
+
+func TestParseNamespaceSelector(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml(`
+- name: db
+  namespaces: [tools]
+  namespaceSelector:
+    matchLabels:
+      env: prod
+    matchExpressions:
+    - key: region
+      operator: NotIn
+      values: [us]
+  excludeNamespaces: [prod-legacy]
+`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &NamespaceSelector{
+		MatchLabels:      map[string]string{"env": "prod"},
+		MatchExpressions: []LabelSelectorRequirement{{Key: "region", Operator: "NotIn", Values: []string{"us"}}},
+	}, defs[0].NamespaceSelector)
+	assert.Equal(t, []string{"prod-legacy"}, defs[0].ExcludeNamespaces)
+}
+
+func TestParseNamespaceSelectorInvalid(t *testing.T) {
+	_, err := parseSecretDefsFromYaml(`
+- name: db
+  namespaceSelector:
+    matchExpressions:
+    - key: env
+      operator: Matches
+`)
+
+	assert.True(t, e.IsInvalidSecretDefinition(err))
+	assert.Contains(t, err.Error(), "invalid namespaceSelector")
+}
+
+func TestParseSecretDefinitionResourceNamespaceSelector(t *testing.T) {
+	_, err := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:      "db",
+		Namespace: "ns",
+		Spec:      []byte(`{"namespaceSelector":{"matchLabels":{"env":"prod"}},"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.True(t, e.IsInvalidSecretDefinition(err))
+	assert.Contains(t, err.Error(), "namespaceSelector is only allowed in cluster scoped secret definitions")
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	secretManager, _, stop := newNamespacesSecretManager(t)
+	defer stop()
+
+	resolved, err := secretManager.resolveNamespaces(SecretDefinition{
+		Name:              "db",
+		Namespaces:        []string{"tools"},
+		NamespaceSelector: &NamespaceSelector{MatchLabels: map[string]string{"env": "prod"}},
+		ExcludeNamespaces: []string{"prod-eu"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"prod", "tools"}, resolved.Namespaces)
+}
+
+func TestNamespaceChangesTriggerSync(t *testing.T) {
+	secretManager, clientSet, stop := newNamespacesSecretManager(t)
+	defer stop()
+
+	// The namespace watch starts with the first selector
+	secretManager.resolveNamespaces(SecretDefinition{Name: "db", NamespaceSelector: &NamespaceSelector{}})
+	clientSet.CoreV1().Namespaces().Create(newFakeNamespace("prod-us", map[string]string{"env": "prod"}, corev1.NamespaceActive))
+
+	select {
+	case <-secretManager.syncNow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sync triggered by the new namespace")
+	}
+}
+
+func TestResolveNamespacesExpressions(t *testing.T) {
+	secretManager, _, stop := newNamespacesSecretManager(t)
+	defer stop()
+
+	resolved, err := secretManager.resolveNamespaces(SecretDefinition{
+		Name: "db",
+		NamespaceSelector: &NamespaceSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "env", Operator: "In", Values: []string{"prod", "staging"}},
+			{Key: "region", Operator: "DoesNotExist"},
+		}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"prod", "staging"}, resolved.Namespaces)
+}
+
+func TestResolveNamespacesWithoutSelector(t *testing.T) {
+	secretManager, clientSet, stop := newNamespacesSecretManager(t)
+	defer stop()
+
+	secret := SecretDefinition{Name: "db", Namespaces: []string{"prod", "staging"}}
+	resolved, err := secretManager.resolveNamespaces(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, resolved)
+
+	secret.ExcludeNamespaces = []string{"staging"}
+	resolved, err = secretManager.resolveNamespaces(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"prod"}, resolved.Namespaces)
+
+	// Namespaces are only listed for selectors
+	assert.Empty(t, clientSet.Actions())
+}
+
+func TestPruneNamespaceSelector(t *testing.T) {
+	secretManager, clientSet, stop := newNamespacesSecretManager(t)
+	defer stop()
+	secretManager.prunePolicy = PruneDelete
+	for _, namespace := range []string{"prod", "staging"} {
+		clientSet.CoreV1().Secrets(namespace).Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: namespace, Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+		})
+	}
+	secretManager.setSecretDefinitions(SecretDefinitions{
+		{Name: "db", NamespaceSelector: &NamespaceSelector{MatchLabels: map[string]string{"env": "prod"}}},
+	}, nil)
+
+	secretManager.prune()
+
+	_, err := clientSet.CoreV1().Secrets("prod").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = clientSet.CoreV1().Secrets("staging").Get("db", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestPruneSkippedNamespaceSelector(t *testing.T) {
+	secretManager, clientSet, stop := newNamespacesSecretManager(t)
+	defer stop()
+	secretManager.prunePolicy = PruneDelete
+	clientSet.CoreV1().Secrets("staging").Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "staging", Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+	})
+	secretManager.setSecretDefinitions(nil, SecretDefinitions{
+		{Name: "db", NamespaceSelector: &NamespaceSelector{MatchLabels: map[string]string{"env": "prod"}}},
+	})
+
+	secretManager.prune()
+
+	// The selector of an invalid definition can not be trusted, its secrets are kept in every namespace
+	_, err := clientSet.CoreV1().Secrets("staging").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+}
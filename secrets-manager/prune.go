@@ -0,0 +1,212 @@
+package secretsmanager
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Prune policies, what to do with the secrets and configmaps managed by secrets-manager that are no longer defined
+const (
+	// PruneNone leaves them untouched
+	PruneNone = "none"
+	// PruneReport only logs them and exposes them in the secrets_manager_secret_prune_candidate_since metric
+	PruneReport = "report"
+	// PruneOrphan removes their managedBy label, so they are never updated nor pruned again
+	PruneOrphan = "orphan"
+	// PruneDelete deletes them
+	PruneDelete = "delete"
+)
+
+// writerLabel is a label of the secrets written by a mirror definition or of the generations of an immutable
+// one, in a namespace of the definition
+type writerLabel struct {
+	namespace string
+	label     string
+	value     string
+}
+
+// definedSecrets returns the '<namespace>/<name>' of every secret and of every configmap defined, including the
+// invalid definitions, and the labels of the secrets written by mirror definitions and of the generations of
+// immutable ones. The namespace selectors of invalid definitions are not resolved, their secrets are defined in
+// every namespace, as '/<name>' or with labels of the empty namespace. Invalid definitions define both a secret
+// and a configmap, whatever their kind
+func (s *SecretManager) definedSecrets() (map[string]bool, map[string]bool, map[writerLabel]bool, error) {
+	s.definitionsMutex.RLock()
+	secretDefinitions := s.secretDefinitions
+	skipped := s.skippedDefinitions
+	s.definitionsMutex.RUnlock()
+
+	defined := make(map[string]bool)
+	definedConfigMaps := make(map[string]bool)
+	writers := make(map[writerLabel]bool)
+	for i, definitions := range []SecretDefinitions{secretDefinitions, skipped} {
+		for _, secret := range definitions {
+			namespaces := secret.Namespaces
+			if i == 1 && secret.NamespaceSelector != nil {
+				namespaces = []string{metav1.NamespaceAll}
+			} else if i == 0 {
+				resolved, err := s.resolveNamespaces(secret)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				namespaces = resolved.Namespaces
+			}
+			for _, namespace := range namespaces {
+				// The secret of a definition turned into a ConfigMap is no longer defined, and the other way round
+				if i == 1 || secret.isConfigMap() {
+					definedConfigMaps[namespace+"/"+secret.Name] = true
+					if i == 0 {
+						continue
+					}
+				}
+				if secret.Mirror != nil {
+					writers[writerLabel{namespace: namespace, label: mirrorLabel, value: mirrorID(secret)}] = true
+					continue
+				}
+				if secret.Immutable != nil {
+					// The generations are deleted on their own, along with their pointer
+					writers[writerLabel{namespace: namespace, label: generationOfLabel, value: secret.Name}] = true
+				}
+				defined[namespace+"/"+secret.Name] = true
+			}
+		}
+	}
+	return defined, definedConfigMaps, writers, nil
+}
+
+// isWritten returns true if the secret is labelled as written by a mirror definition, or as a generation of an
+// immutable one, of its namespace, which delete the secrets they wrote on their own
+func isWritten(writers map[writerLabel]bool, secret k8s.Secret) bool {
+	for _, namespace := range []string{secret.Namespace, metav1.NamespaceAll} {
+		for _, label := range []string{mirrorLabel, generationOfLabel} {
+			value, ok := secret.Labels[label]
+			if ok && writers[writerLabel{namespace: namespace, label: label, value: value}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prunable is a secret or a configmap labelled as managed by secrets-manager that is no longer defined
+type prunable struct {
+	namespace string
+	name      string
+}
+
+// pruneKind holds how to report and prune the secrets or the configmaps no longer defined
+type pruneKind struct {
+	// name is the kind in the logs
+	name           string
+	candidateSince *prometheus.GaugeVec
+	prunedCount    *prometheus.CounterVec
+	orphan         func(namespace string, name string) error
+	delete         func(namespace string, name string) error
+}
+
+// isDefined returns true if '<namespace>/<name>' is in defined, or '/<name>' for the definitions of every namespace
+func isDefined(defined map[string]bool, namespace string, name string) bool {
+	return defined[namespace+"/"+name] || defined[metav1.NamespaceAll+"/"+name]
+}
+
+// prune applies the prune policy to the secrets and configmaps labelled as managed by secrets-manager that have
+// been no longer defined for longer than the prune grace period
+func (s *SecretManager) prune() {
+	s.definitionsMutex.RLock()
+	loaded := s.definitionsLoaded
+	s.definitionsMutex.RUnlock()
+	if !loaded {
+		logger.Warnf("secret definitions not loaded yet, skipping prune")
+		return
+	}
+
+	managed := map[string]string{k8s.ManagedByLabel: k8s.ManagedByValue}
+	secrets, err := s.kubernetes.ListSecrets(metav1.NamespaceAll, managed)
+	if err != nil {
+		logger.Errorf("unable to list secrets to prune: %v", err)
+		return
+	}
+
+	defined, definedConfigMaps, writers, err := s.definedSecrets()
+	if err != nil {
+		logger.Errorf("unable to resolve the namespaces of the secret definitions, skipping prune: %v", err)
+		return
+	}
+	var undefined []prunable
+	for _, secret := range secrets {
+		if !isDefined(defined, secret.Namespace, secret.Name) && !isWritten(writers, secret) {
+			undefined = append(undefined, prunable{namespace: secret.Namespace, name: secret.Name})
+		}
+	}
+	s.pruneCandidates = s.pruneUndefined(pruneKind{
+		name:           "secret",
+		candidateSince: secretPruneCandidateSince,
+		prunedCount:    secretPrunedCount,
+		orphan:         s.kubernetes.OrphanSecret,
+		delete:         s.kubernetes.DeleteSecret,
+	}, undefined, s.pruneCandidates)
+
+	// Configmaps failing to be listed do not prevent secrets from being pruned
+	configMaps, err := s.kubernetes.ListConfigMaps(metav1.NamespaceAll, managed)
+	if err != nil {
+		logger.Errorf("unable to list configmaps to prune: %v", err)
+		return
+	}
+	undefined = nil
+	for _, configMap := range configMaps {
+		if !isDefined(definedConfigMaps, configMap.Namespace, configMap.Name) {
+			undefined = append(undefined, prunable{namespace: configMap.Namespace, name: configMap.Name})
+		}
+	}
+	s.configMapPruneCandidates = s.pruneUndefined(pruneKind{
+		name:           "configmap",
+		candidateSince: configMapPruneCandidateSince,
+		prunedCount:    configMapPrunedCount,
+		orphan:         s.kubernetes.OrphanConfigMap,
+		delete:         s.kubernetes.DeleteConfigMap,
+	}, undefined, s.configMapPruneCandidates)
+}
+
+// pruneUndefined applies the prune policy to the undefined objects of kind, given when each '<namespace>/<name>'
+// was first found no longer defined in previous, and returns when the ones not pruned were
+func (s *SecretManager) pruneUndefined(kind pruneKind, undefined []prunable, previous map[string]time.Time) map[string]time.Time {
+	now := time.Now()
+	candidates := make(map[string]time.Time)
+	kind.candidateSince.Reset()
+	for _, object := range undefined {
+		key := object.namespace + "/" + object.name
+		since, ok := previous[key]
+		if !ok {
+			logger.Warnf("%s '%s' is no longer defined", kind.name, key)
+			since = now
+		}
+		candidates[key] = since
+		kind.candidateSince.WithLabelValues(object.name, object.namespace).Set(float64(since.Unix()))
+
+		if s.prunePolicy == PruneReport || now.Sub(since) < s.pruneGracePeriod {
+			continue
+		}
+		if s.pruneDryRun {
+			logger.Infof("dry run, %s '%s' would be pruned with the %s policy", kind.name, key, s.prunePolicy)
+			continue
+		}
+		var err error
+		if s.prunePolicy == PruneOrphan {
+			err = kind.orphan(object.namespace, object.name)
+		} else {
+			err = kind.delete(object.namespace, object.name)
+		}
+		if err != nil {
+			logger.Errorf("unable to prune %s '%s' with the %s policy: %v", kind.name, key, s.prunePolicy, err)
+			continue
+		}
+		logger.Infof("%s '%s' pruned with the %s policy", kind.name, key, s.prunePolicy)
+		kind.prunedCount.WithLabelValues(object.namespace, s.prunePolicy).Inc()
+		kind.candidateSince.DeleteLabelValues(object.name, object.namespace)
+		delete(candidates, key)
+	}
+	return candidates
+}
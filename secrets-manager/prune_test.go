@@ -0,0 +1,269 @@
+package secretsmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPruneSecret(name string, managed bool) *corev1.Secret {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"owner": "team-a"}}}
+	if managed {
+		secret.Labels[kubernetes.ManagedByLabel] = kubernetes.ManagedByValue
+	}
+	return secret
+}
+
+// newPruneSecretManager returns a secret manager defining the 'defined' secret and the 'team-' mirror in the ns
+// namespace, with the 'defined', 'gone', 'team-web' written by the mirror, 'team-other' and unmanaged 'handmade'
+// secrets in Kubernetes
+func newPruneSecretManager(t *testing.T, cfg Config) (*SecretManager, *fake.Clientset, func()) {
+	mirror := SecretDefinition{Name: "team-", Namespaces: []string{"ns"}, Mirror: &Mirror{Path: "secret/data/team"}}
+	mirrored := newPruneSecret("team-web", true)
+	mirrored.Labels[mirrorLabel] = mirrorID(mirror)
+	client := fake.NewSimpleClientset([]runtime.Object{
+		newPruneSecret("defined", true),
+		newPruneSecret("gone", true),
+		mirrored,
+		newPruneSecret("team-other", true),
+		newPruneSecret("handmade", false),
+	}...)
+	secretManager, stop := newTestSecretManager(t, cfg, kubernetes.New(client, log.New()), nil)
+	secretManager.setSecretDefinitions(SecretDefinitions{
+		{Name: "defined", Namespaces: []string{"ns"}},
+		mirror,
+	}, nil)
+	return secretManager, client, stop
+}
+
+// secretNames returns the names of the secrets of the ns namespace, and the names of the managed ones
+func secretNames(client *fake.Clientset) ([]string, []string) {
+	list, _ := client.CoreV1().Secrets("ns").List(metav1.ListOptions{})
+	var names, managed []string
+	for _, secret := range list.Items {
+		names = append(names, secret.Name)
+		if secret.Labels[kubernetes.ManagedByLabel] == kubernetes.ManagedByValue {
+			managed = append(managed, secret.Name)
+		}
+	}
+	return names, managed
+}
+
+func TestNewInvalidPrunePolicy(t *testing.T) {
+	_, err := New(context.Background(), Config{ConfigMap: "cm", PrunePolicy: "archive"}, nil, newFakeBackend(nil), log.New())
+	assert.True(t, e.IsInvalidPrunePolicy(err))
+}
+
+func TestPruneDelete(t *testing.T) {
+	secretPrunedCount.Reset()
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete})
+	defer stop()
+
+	secretManager.prune()
+
+	// Secrets are only left to mirrors that wrote them, whatever their names
+	names, _ := secretNames(client)
+	assert.ElementsMatch(t, []string{"defined", "team-web", "handmade"}, names)
+	metricSecretPrunedCount, _ := secretPrunedCount.GetMetricWithLabelValues("ns", PruneDelete)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricSecretPrunedCount))
+	assert.Empty(t, secretManager.pruneCandidates)
+}
+
+func TestPruneOrphan(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneOrphan})
+	defer stop()
+
+	secretManager.prune()
+
+	names, managed := secretNames(client)
+	assert.ElementsMatch(t, []string{"defined", "gone", "team-web", "team-other", "handmade"}, names)
+	assert.ElementsMatch(t, []string{"defined", "team-web"}, managed)
+	secret, _ := client.CoreV1().Secrets("ns").Get("gone", metav1.GetOptions{})
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+}
+
+func TestPruneGracePeriod(t *testing.T) {
+	secretPruneCandidateSince.Reset()
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete, PruneGracePeriod: time.Hour})
+	defer stop()
+
+	secretManager.prune()
+
+	names, _ := secretNames(client)
+	assert.Contains(t, names, "gone")
+	since := secretManager.pruneCandidates["ns/gone"]
+	assert.False(t, since.IsZero())
+	metricSecretPruneCandidateSince, _ := secretPruneCandidateSince.GetMetricWithLabelValues("gone", "ns")
+	assert.Equal(t, float64(since.Unix()), testutil.ToFloat64(metricSecretPruneCandidateSince))
+
+	secretManager.pruneCandidates["ns/gone"] = since.Add(-time.Hour)
+	secretManager.prune()
+
+	names, _ = secretNames(client)
+	assert.NotContains(t, names, "gone")
+}
+
+func TestPruneGracePeriodDefinedAgain(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete, PruneGracePeriod: time.Hour})
+	defer stop()
+
+	secretManager.prune()
+	secretManager.pruneCandidates["ns/gone"] = time.Now().Add(-2 * time.Hour)
+
+	// Defining the secret again forgets it was once no longer defined
+	definitions := secretManager.getSecretDefinitions()
+	secretManager.setSecretDefinitions(append(definitions, SecretDefinition{Name: "gone", Namespaces: []string{"ns"}}), nil)
+	secretManager.prune()
+	assert.NotContains(t, secretManager.pruneCandidates, "ns/gone")
+
+	secretManager.setSecretDefinitions(definitions, nil)
+	secretManager.prune()
+
+	names, _ := secretNames(client)
+	assert.Contains(t, names, "gone")
+}
+
+func TestPruneReport(t *testing.T) {
+	secretPruneCandidateSince.Reset()
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneReport})
+	defer stop()
+
+	secretManager.prune()
+
+	names, managed := secretNames(client)
+	assert.Len(t, names, 5)
+	assert.Len(t, managed, 4)
+	metricSecretPruneCandidateSince, _ := secretPruneCandidateSince.GetMetricWithLabelValues("gone", "ns")
+	assert.NotEqual(t, 0.0, testutil.ToFloat64(metricSecretPruneCandidateSince))
+}
+
+func TestPruneDryRun(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete, PruneDryRun: true})
+	defer stop()
+
+	secretManager.prune()
+
+	names, _ := secretNames(client)
+	assert.Len(t, names, 5)
+	assert.Contains(t, secretManager.pruneCandidates, "ns/gone")
+}
+
+func TestPruneSkippedDefinitions(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete})
+	defer stop()
+	secretManager.setSecretDefinitions(
+		SecretDefinitions{{Name: "defined", Namespaces: []string{"ns"}}},
+		SecretDefinitions{{Name: "gone", Namespaces: []string{"ns"}}})
+
+	secretManager.prune()
+
+	names, _ := secretNames(client)
+	assert.Contains(t, names, "gone")
+	assert.NotContains(t, names, "team-web")
+}
+
+// configMapNames returns the names of the configmaps of the ns namespace, and the names of the managed ones
+func configMapNames(client *fake.Clientset) ([]string, []string) {
+	list, _ := client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+	var names, managed []string
+	for _, configMap := range list.Items {
+		names = append(names, configMap.Name)
+		if configMap.Labels[kubernetes.ManagedByLabel] == kubernetes.ManagedByValue {
+			managed = append(managed, configMap.Name)
+		}
+	}
+	return names, managed
+}
+
+func newPruneConfigMap(name string, managed bool) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"owner": "team-a"}}}
+	if managed {
+		configMap.Labels[kubernetes.ManagedByLabel] = kubernetes.ManagedByValue
+	}
+	return configMap
+}
+
+func TestPruneConfigMaps(t *testing.T) {
+	configMapPrunedCount.Reset()
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete})
+	defer stop()
+	for _, configMap := range []*corev1.ConfigMap{
+		newPruneConfigMap("endpoints", true),
+		newPruneConfigMap("defined", true),
+		newPruneConfigMap("gone", true),
+		newPruneConfigMap("handmade", false),
+	} {
+		client.CoreV1().ConfigMaps("ns").Create(configMap)
+	}
+	definitions := secretManager.getSecretDefinitions()
+	secretManager.setSecretDefinitions(append(definitions, SecretDefinition{Name: "endpoints", Namespaces: []string{"ns"}, Kind: ConfigMapKind}), nil)
+
+	secretManager.prune()
+
+	// The configmap of a definition of kind Secret is no longer defined, nor the secret of a ConfigMap one
+	names, _ := configMapNames(client)
+	assert.ElementsMatch(t, []string{"endpoints", "handmade"}, names)
+	names, _ = secretNames(client)
+	assert.Contains(t, names, "defined")
+	metricConfigMapPrunedCount, _ := configMapPrunedCount.GetMetricWithLabelValues("ns", PruneDelete)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricConfigMapPrunedCount))
+	assert.Empty(t, secretManager.configMapPruneCandidates)
+}
+
+func TestPruneConfigMapsOrphan(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneOrphan, PruneGracePeriod: time.Hour})
+	defer stop()
+	client.CoreV1().ConfigMaps("ns").Create(newPruneConfigMap("gone", true))
+
+	secretManager.prune()
+
+	// Configmaps and secrets of the same name are candidates on their own
+	_, managed := configMapNames(client)
+	assert.Equal(t, []string{"gone"}, managed)
+	assert.Contains(t, secretManager.configMapPruneCandidates, "ns/gone")
+	secretManager.configMapPruneCandidates["ns/gone"] = time.Now().Add(-2 * time.Hour)
+	secretManager.prune()
+
+	names, managed := configMapNames(client)
+	assert.Equal(t, []string{"gone"}, names)
+	assert.Empty(t, managed)
+	_, managed = secretNames(client)
+	assert.Contains(t, managed, "gone")
+}
+
+func TestPruneConfigMapsSkippedDefinitions(t *testing.T) {
+	secretManager, client, stop := newPruneSecretManager(t, Config{PrunePolicy: PruneDelete})
+	defer stop()
+	client.CoreV1().ConfigMaps("ns").Create(newPruneConfigMap("gone", true))
+	secretManager.setSecretDefinitions(nil, SecretDefinitions{{Name: "gone", Namespaces: []string{"ns"}}})
+
+	secretManager.prune()
+
+	// Invalid definitions keep both their secret and their configmap, whatever their kind
+	names, _ := configMapNames(client)
+	assert.Contains(t, names, "gone")
+	names, _ = secretNames(client)
+	assert.Contains(t, names, "gone")
+}
+
+func TestPruneDefinitionsNotLoaded(t *testing.T) {
+	client := fake.NewSimpleClientset(newPruneSecret("gone", true))
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm", PrunePolicy: PruneDelete}
+	secretManager, _ := New(context.Background(), cfg, kubernetes.New(client, logger), newFakeBackend(nil), logger)
+
+	secretManager.prune()
+
+	names, _ := secretNames(client)
+	assert.Equal(t, []string{"gone"}, names)
+}
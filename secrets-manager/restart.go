@@ -0,0 +1,240 @@
+package secretsmanager
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Restart policies of the workloads consuming a secret
+const (
+	// RestartNone never restarts the workloads consuming the secret
+	RestartNone = "none"
+	// RestartAuto restarts the Deployments, StatefulSets and DaemonSets referencing the secret in env, envFrom or volumes
+	RestartAuto = "auto"
+)
+
+// checksumAnnotationPrefix is the prefix of the pod template annotation holding the checksum of a secret
+const checksumAnnotationPrefix = reservedAnnotationPrefix + "checksum-"
+
+const (
+	// defaultChecksumKeySecret is the secret holding the key of the checksums, in the namespace of the configmap
+	defaultChecksumKeySecret = "secrets-manager-checksum-key"
+	// checksumKeyKey is the data key of the checksum key in its secret
+	checksumKeyKey = "key"
+	// checksumKeySize is the size of the random checksum keys
+	checksumKeySize = 32
+)
+
+// RestartPolicy selects the workloads to restart when the data of a secret changes. It is either none, auto
+// or an explicit list of workloads as kind/name, such as deployment/web
+type RestartPolicy struct {
+	// Policy is none or auto, empty with an explicit list of Workloads
+	Policy string
+	// Workloads to restart, as kind/name
+	Workloads []string
+}
+
+// UnmarshalYAML reads a restart policy from either a string or a list of workloads
+func (p *RestartPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var workloads []string
+	if err := unmarshal(&workloads); err == nil {
+		*p = RestartPolicy{Workloads: workloads}
+		return nil
+	}
+	var policy string
+	if err := unmarshal(&policy); err != nil {
+		return err
+	}
+	*p = RestartPolicy{Policy: policy}
+	return nil
+}
+
+// workloadKinds are the workload kinds of explicit restart policies, by their lowercase name
+var workloadKinds = map[string]string{
+	"deployment":  k8s.DeploymentKind,
+	"statefulset": k8s.StatefulSetKind,
+	"daemonset":   k8s.DaemonSetKind,
+}
+
+// parseWorkload splits a workload of an explicit restart policy into its kind and name
+func parseWorkload(workload string) (string, string, bool) {
+	parts := strings.SplitN(workload, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	kind, ok := workloadKinds[strings.ToLower(parts[0])]
+	if !ok || len(validation.IsDNS1123Subdomain(parts[1])) > 0 {
+		return "", "", false
+	}
+	return kind, parts[1], true
+}
+
+// validateRestartPolicy checks that the restart policy of the secret definition is none, auto or a list of
+// valid workloads
+func (d SecretDefinition) validateRestartPolicy() error {
+	if len(d.RestartPolicy.Workloads) > 0 {
+		for _, workload := range d.RestartPolicy.Workloads {
+			if _, _, ok := parseWorkload(workload); !ok {
+				return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: fmt.Sprintf("invalid workload %s in restartPolicy, expected deployment, statefulset or daemonset/name", workload)}
+			}
+		}
+		return nil
+	}
+	switch d.RestartPolicy.Policy {
+	case "", RestartNone, RestartAuto:
+		return nil
+	default:
+		return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: fmt.Sprintf("invalid restartPolicy %s, expected none, auto or a list of workloads", d.RestartPolicy.Policy)}
+	}
+}
+
+// restarts returns true if the restart policy restarts any workload
+func (p RestartPolicy) restarts() bool {
+	return len(p.Workloads) > 0 || p.Policy == RestartAuto
+}
+
+// selects returns true if the restart policy restarts the workload when the secret name changes
+func (p RestartPolicy) selects(workload k8s.Workload, name string) bool {
+	if p.Policy == RestartAuto {
+		for _, secret := range workload.Secrets {
+			if secret == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, w := range p.Workloads {
+		if kind, workloadName, ok := parseWorkload(w); ok && kind == workload.Kind && workloadName == workload.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// secretChecksum returns the HMAC-SHA256 with key of the keys and values of data
+func secretChecksum(key []byte, data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := hmac.New(sha256.New, key)
+	for _, k := range keys {
+		fmt.Fprintf(hash, "%d:%s%d:", len(k), k, len(data[k]))
+		hash.Write(data[k])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getChecksumKey returns the key of the checksums of the data of secrets, so that checksums do not reveal the data
+// to whoever can read the workloads or the names of the generations. It is read from the checksum key secret, which
+// is created with a random key the first time, so that every replica computes the same checksums across restarts
+func (s *SecretManager) getChecksumKey() ([]byte, error) {
+	if s.checksumKey != nil {
+		return s.checksumKey, nil
+	}
+	secret, err := s.kubernetes.GetSecret(s.configMapNamespace, s.checksumKeySecret)
+	switch {
+	case err == nil:
+		if len(secret.Data[checksumKeyKey]) == 0 {
+			return nil, &errors.InvalidChecksumKeyError{ErrType: errors.InvalidChecksumKeyErrorType, Name: s.checksumKeySecret, Namespace: s.configMapNamespace}
+		}
+		s.checksumKey = secret.Data[checksumKeyKey]
+	case errors.IsK8sSecretNotFound(err):
+		key := make([]byte, checksumKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		// The secret is not labelled as managed, so it is never pruned. If another replica creates it first, the
+		// creation fails and its key is read on the next sync
+		err := s.kubernetes.UpsertSecret(&k8s.Secret{
+			Name:      s.checksumKeySecret,
+			Namespace: s.configMapNamespace,
+			Type:      "Opaque",
+			Data:      map[string][]byte{checksumKeyKey: key},
+		})
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("checksum key secret '%s/%s' created", s.configMapNamespace, s.checksumKeySecret)
+		s.checksumKey = key
+	default:
+		return nil, err
+	}
+	return s.checksumKey, nil
+}
+
+// checksumAnnotation returns the pod template annotation holding the checksum of the secret name. Names too
+// long for an annotation are hashed
+func checksumAnnotation(name string) string {
+	annotation := checksumAnnotationPrefix + name
+	if len(validation.IsQualifiedName(annotation)) > 0 {
+		sum := sha256.Sum256([]byte(name))
+		annotation = checksumAnnotationPrefix + hex.EncodeToString(sum[:])[:16]
+	}
+	return annotation
+}
+
+// restartWorkloads annotates the pod template of the workloads of namespace selected by the restart policy of
+// the secret with the checksum of data, rolling their pods. It returns false if any of them could not be restarted
+func (s *SecretManager) restartWorkloads(secret SecretDefinition, namespace string, data map[string][]byte) bool {
+	if !secret.RestartPolicy.restarts() {
+		return true
+	}
+	key, err := s.getChecksumKey()
+	if err != nil {
+		logger.Errorf("unable to get the checksum key to restart the workloads consuming secret '%s/%s': %v", namespace, secret.Name, err)
+		return false
+	}
+	workloads, err := s.kubernetes.ListWorkloads(namespace)
+	if err != nil {
+		logger.Errorf("unable to list workloads consuming secret '%s/%s': %v", namespace, secret.Name, err)
+		return false
+	}
+
+	annotation := checksumAnnotation(secret.Name)
+	checksum := secretChecksum(key, data)
+	restarted := true
+	selected := 0
+	for _, workload := range workloads {
+		if !secret.RestartPolicy.selects(workload, secret.Name) {
+			continue
+		}
+		selected++
+		if workload.Annotations[annotation] == checksum {
+			continue
+		}
+		logger.Infof("restarting %s '%s/%s' consuming secret '%s'", workload.Kind, namespace, workload.Name, secret.Name)
+		if err := s.kubernetes.AnnotatePodTemplate(workload, annotation, checksum); err != nil {
+			logger.Errorf("unable to restart %s '%s/%s': %v", workload.Kind, namespace, workload.Name, err)
+			restarted = false
+			continue
+		}
+		workloadRestartCount.WithLabelValues(workload.Kind, namespace).Inc()
+	}
+	if selected < len(secret.RestartPolicy.Workloads) {
+		logger.Warnf("some workloads of the restartPolicy of secret '%s/%s' were not found: %s", namespace, secret.Name, strings.Join(secret.RestartPolicy.Workloads, ", "))
+	}
+	return restarted
+}
+
+// hasPendingRestarts returns true if the workloads consuming the secret in any of its namespaces could not be
+// restarted yet
+func (s *SecretManager) hasPendingRestarts(secret SecretDefinition) bool {
+	for _, namespace := range secret.Namespaces {
+		if s.pendingRestarts[namespace+"/"+secret.Name] {
+			return true
+		}
+	}
+	return false
+}
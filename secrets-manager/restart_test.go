@@ -0,0 +1,235 @@
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml(`
+- name: auto
+  restartPolicy: auto
+- name: explicit
+  restartPolicy:
+  - deployment/web
+  - StatefulSet/db
+- name: default
+`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, RestartPolicy{Policy: RestartAuto}, defs[0].RestartPolicy)
+	assert.Equal(t, RestartPolicy{Workloads: []string{"deployment/web", "StatefulSet/db"}}, defs[1].RestartPolicy)
+	assert.Equal(t, RestartPolicy{}, defs[2].RestartPolicy)
+	assert.False(t, defs[2].RestartPolicy.restarts())
+}
+
+func TestParseRestartPolicyInvalid(t *testing.T) {
+	for _, policy := range []string{"always", "[web]", "[job/web]", "[deployment/Web]"} {
+		_, err := parseSecretDefsFromYaml("- name: db\n  restartPolicy: " + policy)
+		assert.True(t, e.IsInvalidSecretDefinition(err), "expected invalid restartPolicy %s, got %v", policy, err)
+	}
+}
+
+func TestRestartPolicySelects(t *testing.T) {
+	web := kubernetes.Workload{Kind: kubernetes.DeploymentKind, Name: "web", Secrets: []string{"db"}}
+	cache := kubernetes.Workload{Kind: kubernetes.StatefulSetKind, Name: "cache", Secrets: []string{"tls"}}
+
+	auto := RestartPolicy{Policy: RestartAuto}
+	assert.True(t, auto.selects(web, "db"))
+	assert.False(t, auto.selects(cache, "db"))
+
+	explicit := RestartPolicy{Workloads: []string{"statefulset/cache"}}
+	assert.False(t, explicit.selects(web, "db"))
+	assert.True(t, explicit.selects(cache, "db"))
+
+	none := RestartPolicy{Policy: RestartNone}
+	assert.False(t, none.selects(web, "db"))
+}
+
+func TestSecretChecksum(t *testing.T) {
+	checksum := secretChecksum(testChecksumKey, map[string][]byte{"user": []byte("admin"), "password": []byte("s3cr3t")})
+
+	assert.Len(t, checksum, 64)
+	assert.Equal(t, checksum, secretChecksum(testChecksumKey, map[string][]byte{"password": []byte("s3cr3t"), "user": []byte("admin")}))
+	assert.NotEqual(t, checksum, secretChecksum(testChecksumKey, map[string][]byte{"user": []byte("admin"), "password": []byte("changed")}))
+	// Entries can not be shifted between keys and values
+	assert.NotEqual(t, secretChecksum(testChecksumKey, map[string][]byte{"a": []byte("bc")}), secretChecksum(testChecksumKey, map[string][]byte{"ab": []byte("c")}))
+	// Checksums can not be computed without the key
+	assert.NotEqual(t, checksum, secretChecksum([]byte("other-key"), map[string][]byte{"user": []byte("admin"), "password": []byte("s3cr3t")}))
+}
+
+func TestGetChecksumKey(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm"}, kubernetes.New(clientSet, log.New()), newFakeBackend(nil), log.New())
+
+	// The key is created at random the first time, and shared with the other replicas and restarts
+	key, err := secretManager.getChecksumKey()
+	assert.Nil(t, err)
+	assert.Len(t, key, checksumKeySize)
+	secret, err := clientSet.CoreV1().Secrets("default").Get(defaultChecksumKeySecret, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, key, secret.Data[checksumKeyKey])
+	assert.Empty(t, secret.Labels[kubernetes.ManagedByLabel])
+
+	restarted, _ := New(context.Background(), Config{ConfigMap: "cm"}, kubernetes.New(clientSet, log.New()), newFakeBackend(nil), log.New())
+	again, err := restarted.getChecksumKey()
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+}
+
+func TestGetChecksumKeyEmpty(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "checksums", Namespace: "default"}})
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm", ChecksumKeySecret: "checksums"}, kubernetes.New(clientSet, log.New()), newFakeBackend(nil), log.New())
+
+	_, err := secretManager.getChecksumKey()
+	assert.True(t, e.IsInvalidChecksumKey(err))
+}
+
+func TestChecksumAnnotation(t *testing.T) {
+	assert.Equal(t, "secrets-manager.tuenti.io/checksum-db", checksumAnnotation("db"))
+
+	long := checksumAnnotation(strings.Repeat("a", 100))
+	assert.Empty(t, validation.IsQualifiedName(long))
+	assert.NotEqual(t, long, checksumAnnotation(strings.Repeat("b", 100)))
+}
+
+func newFakeDeployment(name string, secret string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret}}}},
+			}}},
+		}},
+	}
+}
+
+func TestSyncStateRestartsWorkloads(t *testing.T) {
+	workloadRestartCount.Reset()
+	upToDate := map[string]string{checksumAnnotation("db"): secretChecksum(testChecksumKey, map[string][]byte{"password": []byte("n3w")})}
+	clientSet := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns", Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+			Data:       map[string][]byte{"password": []byte("0ld")},
+		},
+		newFakeDeployment("web", "db", nil),
+		newFakeDeployment("restarted", "db", upToDate),
+		newFakeDeployment("other", "cache", nil),
+	)
+	clientSet.PrependReactor("patch", "deployments", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(clientSet, log.New()), []fakeBackendSecret{
+		{"secret/data/db", "password", "n3w"},
+	})
+	defer stop()
+
+	definition := SecretDefinition{
+		Name:          "db",
+		Namespaces:    []string{"ns"},
+		Type:          "Opaque",
+		RestartPolicy: RestartPolicy{Policy: RestartAuto},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	patches := make([]clientgotesting.PatchAction, 0)
+	for _, action := range clientSet.Actions() {
+		if patch, ok := action.(clientgotesting.PatchAction); ok {
+			patches = append(patches, patch)
+		}
+	}
+	assert.Len(t, patches, 1)
+	assert.Equal(t, "web", patches[0].GetName())
+	var patched map[string]map[string]map[string]map[string]map[string]string
+	assert.Nil(t, json.Unmarshal(patches[0].GetPatch(), &patched))
+	assert.Equal(t, upToDate, patched["spec"]["template"]["metadata"]["annotations"])
+}
+
+func TestSyncStateRetriesRestarts(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns", Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+			Data:       map[string][]byte{"password": []byte("0ld")},
+		},
+		newFakeDeployment("web", "db", nil),
+	)
+	patches := 0
+	clientSet.PrependReactor("patch", "deployments", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		patches++
+		if patches == 1 {
+			return true, nil, errors.New("conflict")
+		}
+		return true, nil, nil
+	})
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(clientSet, log.New()), []fakeBackendSecret{
+		{"secret/data/db", "password", "n3w"},
+	})
+	defer stop()
+	definition := SecretDefinition{
+		Name:          "db",
+		Namespaces:    []string{"ns"},
+		Type:          "Opaque",
+		RestartPolicy: RestartPolicy{Policy: RestartAuto},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	assert.Equal(t, 1, patches)
+	assert.True(t, secretManager.pendingRestarts["ns/db"])
+
+	// The restart is retried once the secret is in sync, until it succeeds
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	assert.Equal(t, 2, patches)
+	assert.Empty(t, secretManager.pendingRestarts)
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	assert.Equal(t, 2, patches)
+}
+
+func TestSyncStateDoesNotRestartWithoutPolicy(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns", Labels: map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue}},
+			Data:       map[string][]byte{"password": []byte("0ld")},
+		},
+		newFakeDeployment("web", "db", nil),
+	)
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(clientSet, log.New()), []fakeBackendSecret{
+		{"secret/data/db", "password", "n3w"},
+	})
+	defer stop()
+
+	definition := SecretDefinition{
+		Name:       "db",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	for _, action := range clientSet.Actions() {
+		assert.NotEqual(t, "deployments", action.GetResource().Resource)
+	}
+}
@@ -0,0 +1,294 @@
+package secretsmanager
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ConfigMapSource loads the secret definitions from the Config.ConfigMap ConfigMap
+	ConfigMapSource = "configmap"
+	// CustomResourceSource loads the secret definitions from SecretDefinition custom resources
+	CustomResourceSource = "crd"
+
+	syncedCondition          = "Synced"
+	backendErrorCondition    = "BackendError"
+	kubernetesErrorCondition = "KubernetesError"
+
+	// statusRefreshInterval is the longest time an unchanged status is not written again
+	statusRefreshInterval = 5 * time.Minute
+)
+
+// definitionResource references the SecretDefinition custom resource a secret definition was loaded from
+type definitionResource struct {
+	name       string
+	namespace  string
+	uid        string
+	generation int64
+}
+
+// writtenStatus is the status last written to a SecretDefinition custom resource
+type writtenStatus struct {
+	status    k8s.SecretDefinitionStatus
+	writtenAt time.Time
+}
+
+// syncResult is the outcome of the sync of a secret definition, reported in its custom resource status
+type syncResult struct {
+	synced        []string
+	invalidErr    error
+	backendErr    error
+	kubernetesErr error
+}
+
+// key identifies the secret definition among every loaded one by the secret it writes, so definitions of
+// namespaced resources are keyed on their namespace and secret name
+func (d SecretDefinition) key() string {
+	if d.resource != nil && d.resource.namespace != "" {
+		return d.resource.namespace + "/" + d.Name
+	}
+	return d.Name
+}
+
+// key identifies the custom resource among every loaded one
+func (r *definitionResource) key() string {
+	return r.namespace + "/" + r.name
+}
+
+func invalidSecretDefinition(r k8s.SecretDefinition, reason string) error {
+	name := r.Name
+	if r.Namespace != "" {
+		name = r.Namespace + "/" + r.Name
+	}
+	return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: name, Reason: reason}
+}
+
+// ParseNamespacePathPrefixes parses the backend path prefixes namespaced SecretDefinition custom resources can read,
+// as in "team-a=secret/data/team-a,secret/data/shared;team-b=secret/data/team-b". It returns nil for an empty value,
+// leaving them unrestricted
+func ParseNamespacePathPrefixes(value string) (map[string][]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	invalid := &errors.InvalidNamespacePathPrefixesError{ErrType: errors.InvalidNamespacePathPrefixesErrorType, Value: value}
+	prefixes := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		namespace := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || namespace == "" {
+			return nil, invalid
+		}
+		if _, ok := prefixes[namespace]; ok {
+			return nil, invalid
+		}
+		prefixes[namespace] = []string{}
+		for _, prefix := range strings.Split(parts[1], ",") {
+			prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+			if prefix == "" {
+				continue
+			}
+			prefixes[namespace] = append(prefixes[namespace], prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// allowedPath returns whether the backend path is under one of the prefixes, matched on whole path segments
+func allowedPath(path string, prefixes []string) bool {
+	path = strings.Trim(path, "/")
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// backendPaths returns the backend paths read by the secret definition
+func (d SecretDefinition) backendPaths() []string {
+	var paths []string
+	if d.Mirror != nil {
+		paths = append(paths, d.Mirror.Path)
+	}
+	for _, v := range d.datasources() {
+		if v.Path != "" {
+			paths = append(paths, v.Path)
+		}
+	}
+	return paths
+}
+
+// parseSecretDefinitionResource builds the secret definition of a custom resource. The name of the resource
+// is used when the spec has no name. Namespaced resources can only write secrets to their own namespace,
+// which is used when the spec has no namespaces, and only read the backend paths under the pathPrefixes of
+// their namespace, unless pathPrefixes is nil
+func parseSecretDefinitionResource(r k8s.SecretDefinition, pathPrefixes map[string][]string) (SecretDefinition, error) {
+	secretDef := SecretDefinition{}
+	err := yaml.Unmarshal(r.Spec, &secretDef)
+	secretDef.resource = &definitionResource{name: r.Name, namespace: r.Namespace, uid: r.UID, generation: r.Generation}
+	if secretDef.Name == "" {
+		secretDef.Name = r.Name
+	}
+	if r.Namespace != "" && len(secretDef.Namespaces) == 0 && secretDef.NamespaceSelector == nil {
+		secretDef.Namespaces = []string{r.Namespace}
+	}
+	if err != nil {
+		return secretDef, invalidSecretDefinition(r, err.Error())
+	}
+	if r.Namespace != "" {
+		if secretDef.NamespaceSelector != nil {
+			return secretDef, invalidSecretDefinition(r, "namespaceSelector is only allowed in cluster scoped secret definitions")
+		}
+		for _, namespace := range secretDef.Namespaces {
+			if namespace != r.Namespace {
+				return secretDef, invalidSecretDefinition(r, fmt.Sprintf("namespace %s is not the namespace of the secret definition", namespace))
+			}
+		}
+		if pathPrefixes != nil {
+			for _, path := range secretDef.backendPaths() {
+				if !allowedPath(path, pathPrefixes[r.Namespace]) {
+					return secretDef, invalidSecretDefinition(r, fmt.Sprintf("backend path %s is not allowed in namespace %s", path, r.Namespace))
+				}
+			}
+		}
+	}
+	if err := secretDef.validate(); err != nil {
+		return secretDef, err
+	}
+	return secretDef, nil
+}
+
+// loadSecretDefinitionResources loads the secret definitions from every SecretDefinition custom resource,
+// skipping and reporting the invalid ones. The secrets of skipped definitions are never pruned
+func (s *SecretManager) loadSecretDefinitionResources() error {
+	resources, err := s.kubernetes.ListSecretDefinitions()
+	if err != nil {
+		logger.Errorf("unable to load secret definitions: %v", err)
+		return err
+	}
+
+	secretDefinitions := make(SecretDefinitions, 0, len(resources))
+	var skipped SecretDefinitions
+	keys := make(map[string]bool, len(resources))
+	loaded := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		secretDef, err := parseSecretDefinitionResource(r, s.namespacePathPrefixes)
+		if err == nil && keys[secretDef.key()] {
+			err = invalidSecretDefinition(r, fmt.Sprintf("secret %s is defined more than once", secretDef.Name))
+		}
+		keys[secretDef.key()] = true
+		loaded[secretDef.resource.key()] = true
+		if err != nil {
+			logger.Errorf("skipping secret definition '%s/%s': %v", r.Namespace, r.Name, err)
+			s.reportStatus(secretDef, syncResult{invalidErr: err})
+			skipped = append(skipped, secretDef)
+			continue
+		}
+		secretDefinitions = append(secretDefinitions, secretDef)
+	}
+
+	s.statusMutex.Lock()
+	for key := range s.statuses {
+		if !loaded[key] {
+			delete(s.statuses, key)
+		}
+	}
+	s.statusMutex.Unlock()
+
+	s.setSecretDefinitions(secretDefinitions, skipped)
+	return nil
+}
+
+// newCondition returns a condition of a SecretDefinition custom resource, keeping the transition time of
+// the previous condition of the same type when its status is unchanged
+func newCondition(previous *writtenStatus, conditionType string, status bool, reason string, message string, now time.Time) k8s.SecretDefinitionCondition {
+	condition := k8s.SecretDefinitionCondition{Type: conditionType, Status: k8s.ConditionFalse, Reason: reason, Message: message}
+	if status {
+		condition.Status = k8s.ConditionTrue
+	}
+
+	condition.LastTransitionTime = now.UTC().Format(time.RFC3339)
+	if previous != nil {
+		for _, c := range previous.status.Conditions {
+			if c.Type == conditionType && c.Status == condition.Status {
+				condition.LastTransitionTime = c.LastTransitionTime
+			}
+		}
+	}
+	return condition
+}
+
+// errorCondition returns a condition that is true when err is not nil
+func errorCondition(previous *writtenStatus, conditionType string, err error, reason string, now time.Time) k8s.SecretDefinitionCondition {
+	if err == nil {
+		return newCondition(previous, conditionType, false, "", "", now)
+	}
+	return newCondition(previous, conditionType, true, reason, err.Error(), now)
+}
+
+// reportStatus writes the result of the sync of a secret definition in the status of the custom resource
+// it was loaded from. Unchanged statuses are only written again every statusRefreshInterval
+func (s *SecretManager) reportStatus(secret SecretDefinition, result syncResult) {
+	if secret.resource == nil {
+		return
+	}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	now := time.Now()
+	key := secret.resource.key()
+	previous := s.statuses[key]
+
+	synced := newCondition(previous, syncedCondition, true, syncedCondition, "", now)
+	switch {
+	case result.invalidErr != nil:
+		synced = newCondition(previous, syncedCondition, false, "InvalidSpec", result.invalidErr.Error(), now)
+	case result.backendErr != nil:
+		synced = newCondition(previous, syncedCondition, false, backendErrorCondition, result.backendErr.Error(), now)
+	case result.kubernetesErr != nil:
+		synced = newCondition(previous, syncedCondition, false, kubernetesErrorCondition, result.kubernetesErr.Error(), now)
+	}
+	namespaces := append([]string{}, result.synced...)
+	sort.Strings(namespaces)
+
+	status := k8s.SecretDefinitionStatus{
+		ObservedGeneration: secret.resource.generation,
+		LastSyncTime:       now.UTC().Format(time.RFC3339),
+		SyncedNamespaces:   namespaces,
+		Conditions: []k8s.SecretDefinitionCondition{
+			synced,
+			errorCondition(previous, backendErrorCondition, result.backendErr, "BackendReadFailed", now),
+			errorCondition(previous, kubernetesErrorCondition, result.kubernetesErr, "SecretWriteFailed", now),
+		},
+	}
+
+	if previous != nil && now.Sub(previous.writtenAt) < statusRefreshInterval {
+		unchanged := previous.status
+		unchanged.LastSyncTime = status.LastSyncTime
+		if reflect.DeepEqual(unchanged, status) {
+			return
+		}
+	}
+
+	definition := &k8s.SecretDefinition{Name: secret.resource.name, Namespace: secret.resource.namespace, Generation: secret.resource.generation}
+	if err := s.kubernetes.UpdateSecretDefinitionStatus(definition, &status); err != nil {
+		logger.Warnf("unable to update status of secret definition '%s': %v", key, err)
+		return
+	}
+	s.statuses[key] = &writtenStatus{status: status, writtenAt: now}
+}
@@ -0,0 +1,291 @@
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gomock "github.com/golang/mock/gomock"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	"github.com/tuenti/secrets-manager/mocks"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// statusCondition returns the condition of the given type of the status
+func statusCondition(status *kubernetes.SecretDefinitionStatus, conditionType string) kubernetes.SecretDefinitionCondition {
+	for _, c := range status.Conditions {
+		if c.Type == conditionType {
+			return c
+		}
+	}
+	return kubernetes.SecretDefinitionCondition{}
+}
+
+func TestNewInvalidSource(t *testing.T) {
+	_, err := New(context.Background(), Config{ConfigMap: "cm", Source: "etcd"}, nil, newFakeBackend(nil), log.New())
+	assert.True(t, e.IsInvalidConfigSource(err))
+}
+
+func TestParseSecretDefinitionResourceDefaults(t *testing.T) {
+	secretDef, err := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:       "db",
+		Namespace:  "ns",
+		UID:        "1234",
+		Generation: 2,
+		Spec:       []byte(`{"type":"Opaque","data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "db", secretDef.Name)
+	assert.Equal(t, []string{"ns"}, secretDef.Namespaces)
+	assert.Equal(t, "ns/db", secretDef.key())
+	assert.Equal(t, &definitionResource{name: "db", namespace: "ns", uid: "1234", generation: 2}, secretDef.resource)
+}
+
+func TestParseSecretDefinitionResourceOtherNamespace(t *testing.T) {
+	_, err := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:      "db",
+		Namespace: "ns",
+		Spec:      []byte(`{"namespaces":["ns","kube-system"],"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.True(t, e.IsInvalidSecretDefinition(err))
+	assert.Contains(t, err.Error(), "namespace kube-system is not the namespace of the secret definition")
+}
+
+func TestParseNamespacePathPrefixes(t *testing.T) {
+	prefixes, err := ParseNamespacePathPrefixes("team-a=secret/data/team-a/, secret/data/shared ; team-b=;")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"team-a": {"secret/data/team-a", "secret/data/shared"}, "team-b": {}}, prefixes)
+
+	prefixes, err = ParseNamespacePathPrefixes("")
+	assert.Nil(t, err)
+	assert.Nil(t, prefixes)
+
+	for _, value := range []string{"secret/data/team-a", "=secret/data/team-a", "team-a=secret/data/a;team-a=secret/data/b"} {
+		_, err = ParseNamespacePathPrefixes(value)
+		assert.True(t, e.IsInvalidNamespacePathPrefixes(err), "expected invalid prefixes %s, got %v", value, err)
+	}
+}
+
+func TestParseSecretDefinitionResourcePathPrefixes(t *testing.T) {
+	prefixes := map[string][]string{"team-a": {"secret/data/team-a"}, "team-b": {}}
+	resource := func(namespace string, spec string) kubernetes.SecretDefinition {
+		return kubernetes.SecretDefinition{Name: "db", Namespace: namespace, Spec: []byte(spec)}
+	}
+
+	for _, spec := range []string{
+		`{"data":{"password":{"path":"secret/data/team-a/db","key":"password"}}}`,
+		`{"data":{"password":{"path":"/secret/data/team-a","key":"password"}}}`,
+		`{"mirror":{"path":"secret/data/team-a/apps"}}`,
+	} {
+		_, err := parseSecretDefinitionResource(resource("team-a", spec), prefixes)
+		assert.Nil(t, err, spec)
+	}
+
+	for _, r := range []kubernetes.SecretDefinition{
+		resource("team-a", `{"data":{"password":{"path":"secret/data/team-b/db","key":"password"}}}`),
+		resource("team-a", `{"data":{"password":{"path":"secret/data/team-ab/db","key":"password"}}}`),
+		resource("team-a", `{"data":{"password":{"path":"secret/data/team-a/../team-b/db","key":"password"}}}`),
+		resource("team-a", `{"mirror":{"path":"secret/data"}}`),
+		resource("team-a", `{"data":{"dsn":{"template":"{{ .db }}","datasources":{"db":{"path":"secret/data/team-b/db","key":"password"}}}}}`),
+		resource("team-b", `{"data":{"password":{"path":"secret/data/team-b/db","key":"password"}}}`),
+		resource("team-c", `{"data":{"password":{"path":"secret/data/team-c/db","key":"password"}}}`),
+	} {
+		_, err := parseSecretDefinitionResource(r, prefixes)
+		assert.True(t, e.IsInvalidSecretDefinition(err), "expected invalid definition %s, got %v", r.Spec, err)
+	}
+
+	// Cluster scoped resources are not restricted
+	_, err := parseSecretDefinitionResource(resource("", `{"namespaces":["team-b"],"data":{"password":{"path":"secret/data/team-a/db","key":"password"}}}`), prefixes)
+	assert.Nil(t, err)
+}
+
+func TestParseSecretDefinitionResourceClusterScoped(t *testing.T) {
+	secretDef, err := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name: "db",
+		Spec: []byte(`{"name":"db-credentials","namespaces":["ns1","ns2"],"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "db-credentials", secretDef.Name)
+	assert.Equal(t, []string{"ns1", "ns2"}, secretDef.Namespaces)
+	assert.Equal(t, "db-credentials", secretDef.key())
+}
+
+func TestLoadSecretDefinitionResources(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	k8s.EXPECT().ListSecretDefinitions().Return([]kubernetes.SecretDefinition{
+		{Name: "db", Namespace: "ns", Spec: []byte(`{"data":{"password":{"path":"secret/data/db","key":"password"}}}`)},
+		{Name: "broken", Namespace: "ns", Generation: 4, Spec: []byte(`{"data":{"password":{"path":"secret/data/db","key":"password","jsonPath":"[unclosed"}}}`)},
+	}, nil)
+	k8s.EXPECT().UpdateSecretDefinitionStatus(gomock.Any(), gomock.Any()).Times(1).Do(
+		func(definition *kubernetes.SecretDefinition, status *kubernetes.SecretDefinitionStatus) {
+			assert.Equal(t, "broken", definition.Name)
+			assert.Equal(t, "ns", definition.Namespace)
+			assert.Equal(t, int64(4), status.ObservedGeneration)
+			synced := statusCondition(status, syncedCondition)
+			assert.Equal(t, kubernetes.ConditionFalse, synced.Status)
+			assert.Equal(t, "InvalidSpec", synced.Reason)
+		}).Return(nil)
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, nil)
+	defer stop()
+	err := secretManager.loadSecretDefinitions()
+
+	assert.Nil(t, err)
+	assert.Len(t, secretManager.secretDefinitions, 1)
+	assert.Equal(t, "db", secretManager.secretDefinitions[0].Name)
+}
+
+func TestLoadSecretDefinitionResourcesDuplicate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	// Namespaced resources writing the same secret in the same namespace are duplicates, whatever their names
+	spec := []byte(`{"name":"db-credentials","data":{"password":{"path":"secret/data/db","key":"password"}}}`)
+	k8s.EXPECT().ListSecretDefinitions().Return([]kubernetes.SecretDefinition{
+		{Name: "db", Namespace: "ns", Spec: spec},
+		{Name: "db-copy", Namespace: "ns", Spec: spec},
+		{Name: "db", Namespace: "other", Spec: spec},
+	}, nil)
+	k8s.EXPECT().UpdateSecretDefinitionStatus(gomock.Any(), gomock.Any()).Times(1).Do(
+		func(definition *kubernetes.SecretDefinition, status *kubernetes.SecretDefinitionStatus) {
+			assert.Equal(t, "db-copy", definition.Name)
+			synced := statusCondition(status, syncedCondition)
+			assert.Equal(t, "InvalidSpec", synced.Reason)
+			assert.Contains(t, synced.Message, "secret db-credentials is defined more than once")
+		}).Return(nil)
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, nil)
+	defer stop()
+	assert.Nil(t, secretManager.loadSecretDefinitions())
+
+	assert.Len(t, secretManager.secretDefinitions, 2)
+	assert.Equal(t, "ns/db-credentials", secretManager.secretDefinitions[0].key())
+	assert.Equal(t, "other/db-credentials", secretManager.secretDefinitions[1].key())
+	assert.Len(t, secretManager.skippedDefinitions, 1)
+}
+
+func TestLoadSecretDefinitionResourcesError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	k8s.EXPECT().ListSecretDefinitions().Return(nil, errors.New("forbidden"))
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, nil)
+	defer stop()
+	assert.NotNil(t, secretManager.loadSecretDefinitions())
+}
+
+func TestSyncStateReportsStatus(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	k8s.EXPECT().GetSecret("ns", "db").AnyTimes().Return(&kubernetes.Secret{Data: map[string][]byte{"password": []byte("s3cr3t")}, Annotations: withManagedKeys(nil, nil)}, nil)
+	// The unchanged status of the second sync is not written again
+	k8s.EXPECT().UpdateSecretDefinitionStatus(gomock.Any(), gomock.Any()).Times(1).Do(
+		func(definition *kubernetes.SecretDefinition, status *kubernetes.SecretDefinitionStatus) {
+			assert.Equal(t, int64(2), status.ObservedGeneration)
+			assert.Equal(t, []string{"ns"}, status.SyncedNamespaces)
+			assert.NotEmpty(t, status.LastSyncTime)
+			assert.Equal(t, kubernetes.ConditionTrue, statusCondition(status, syncedCondition).Status)
+			assert.Equal(t, kubernetes.ConditionFalse, statusCondition(status, backendErrorCondition).Status)
+			assert.Equal(t, kubernetes.ConditionFalse, statusCondition(status, kubernetesErrorCondition).Status)
+		}).Return(nil)
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, []fakeBackendSecret{{"secret/data/db", "password", "s3cr3t"}})
+	defer stop()
+	secretDef, err := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:       "db",
+		Namespace:  "ns",
+		Generation: 2,
+		Spec:       []byte(`{"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, secretManager.syncState(context.Background(), secretDef))
+	assert.Nil(t, secretManager.syncState(context.Background(), secretDef))
+}
+
+func TestSyncStateReportsBackendError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	resource := &kubernetes.ObjectReference{Kind: kubernetes.SecretDefinitionKind, APIVersion: kubernetes.SecretDefinitionGroupVersion.String(), Name: "db", Namespace: "ns"}
+	k8s.EXPECT().RecordSecretEvent("ns", "db", resource, corev1.EventTypeWarning, kubernetes.BackendReadFailedReason, gomock.Any()).Times(1)
+	k8s.EXPECT().UpdateSecretDefinitionStatus(gomock.Any(), gomock.Any()).Times(1).Do(
+		func(definition *kubernetes.SecretDefinition, status *kubernetes.SecretDefinitionStatus) {
+			assert.Empty(t, status.SyncedNamespaces)
+			synced := statusCondition(status, syncedCondition)
+			assert.Equal(t, kubernetes.ConditionFalse, synced.Status)
+			assert.Equal(t, backendErrorCondition, synced.Reason)
+			backendError := statusCondition(status, backendErrorCondition)
+			assert.Equal(t, kubernetes.ConditionTrue, backendError.Status)
+			assert.Equal(t, "BackendReadFailed", backendError.Reason)
+			assert.NotEmpty(t, backendError.Message)
+		}).Return(nil)
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, nil)
+	defer stop()
+	secretDef, _ := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:      "db",
+		Namespace: "ns",
+		Spec:      []byte(`{"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.NotNil(t, secretManager.syncState(context.Background(), secretDef))
+}
+
+func TestSyncStateReportsKubernetesError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	k8s.EXPECT().GetSecret("ns", "db").Return(nil, errors.New("forbidden"))
+	k8s.EXPECT().RecordSecretEvent("ns", "db", gomock.Any(), corev1.EventTypeWarning, kubernetes.SyncFailedReason, "forbidden").Times(1)
+	k8s.EXPECT().UpdateSecretDefinitionStatus(gomock.Any(), gomock.Any()).Times(1).Do(
+		func(definition *kubernetes.SecretDefinition, status *kubernetes.SecretDefinitionStatus) {
+			assert.Empty(t, status.SyncedNamespaces)
+			assert.Equal(t, kubernetesErrorCondition, statusCondition(status, syncedCondition).Reason)
+			assert.Equal(t, kubernetes.ConditionTrue, statusCondition(status, kubernetesErrorCondition).Status)
+			assert.Equal(t, "forbidden", statusCondition(status, kubernetesErrorCondition).Message)
+		}).Return(nil)
+
+	secretManager, stop := newTestSecretManager(t, Config{Source: CustomResourceSource}, k8s, []fakeBackendSecret{{"secret/data/db", "password", "s3cr3t"}})
+	defer stop()
+	secretDef, _ := parseSecretDefinitionResource(kubernetes.SecretDefinition{
+		Name:      "db",
+		Namespace: "ns",
+		Spec:      []byte(`{"data":{"password":{"path":"secret/data/db","key":"password"}}}`),
+	}, nil)
+
+	assert.Nil(t, secretManager.syncState(context.Background(), secretDef))
+}
+
+func TestSyncStateWithoutResourceDoesNotReportStatus(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	k8s.EXPECT().GetSecret("ns", "db").Return(&kubernetes.Secret{Data: map[string][]byte{"password": []byte("s3cr3t")}, Annotations: withManagedKeys(nil, nil)}, nil)
+
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(context.Background(), cfg, k8s, newFakeBackend([]fakeBackendSecret{{"secret/data/db", "password", "s3cr3t"}}), log.New())
+
+	assert.Nil(t, secretManager.syncState(context.Background(), SecretDefinition{
+		Name:       "db",
+		Namespaces: []string{"ns"},
+		Data:       map[string]Datasource{"password": {Path: "secret/data/db", Key: "password"}},
+	}))
+}
@@ -2,32 +2,113 @@ package secretsmanager
 
 import (
 	"context"
+	"encoding/json"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tuenti/secrets-manager/backend"
 	"github.com/tuenti/secrets-manager/errors"
 	k8s "github.com/tuenti/secrets-manager/kubernetes"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type SecretManager struct {
-	configMapName            string
-	configMapNamespace       string
-	secretDefinitions        SecretDefinitions
-	kubernetes               k8s.Client
-	backend                  backend.Client
-	backendScrapeInterval    time.Duration
-	configMapRefreshInterval time.Duration
+	configMapName      string
+	configMapNamespace string
+	secretDefinitions  SecretDefinitions
+	// skippedDefinitions are the invalid secret definitions, whose secrets are not synced nor pruned
+	skippedDefinitions SecretDefinitions
+	// definitionsLoaded is true once the secret definitions have been loaded
+	definitionsLoaded bool
+	// definitionsMutex guards the secret definitions, replaced by the config watch while the sync loop reads them
+	definitionsMutex sync.RWMutex
+	// loadedResourceVersion is the resourceVersion of the config source the secret definitions were loaded at
+	loadedResourceVersion string
+	// failedResourceVersion is the resourceVersion of the config source that failed to load, retried until it
+	// or a newer one is loaded
+	failedResourceVersion string
+	// configMapUID is the UID of the configmap, recorded in the events about the secrets that do not exist
+	configMapUID string
+	// configMutex guards the resourceVersions of the config source, loaded by the config watch and its retries,
+	// and the UID of the configmap
+	configMutex sync.Mutex
+	// syncedVersions keeps the backend versions of every secret definition as of its last full sync
+	syncedVersions map[string]*syncedVersions
+	// backendMetadata keeps the backend metadata read for every path during the sync of a secret definition, so
+	// that it is read once for both its versions and its labels and annotations
+	backendMetadata map[string]backendMetadataRead
+	// namespacePathPrefixes are the backend path prefixes namespaced SecretDefinition custom resources can read
+	namespacePathPrefixes map[string][]string
+	// statuses keeps the status last written to every SecretDefinition custom resource
+	statuses              map[string]*writtenStatus
+	statusMutex           sync.Mutex
+	source                string
+	kubernetes            k8s.Client
+	backend               backend.Client
+	backendScrapeInterval time.Duration
+	fullResyncInterval    time.Duration
+	prunePolicy           string
+	pruneInterval         time.Duration
+	pruneGracePeriod      time.Duration
+	pruneDryRun           bool
+	lastPrune             time.Time
+	// pruneCandidates keeps when every '<namespace>/<name>' secret no longer defined was first found
+	pruneCandidates map[string]time.Time
+	// configMapPruneCandidates keeps when every '<namespace>/<name>' configmap no longer defined was first found
+	configMapPruneCandidates map[string]time.Time
+	// checksumKeySecret holds checksumKey, the key of the checksums of the data of secrets, loaded the first time
+	// it is needed
+	checksumKeySecret string
+	checksumKey       []byte
+	// pendingRestarts keeps the '<namespace>/<name>' secrets whose consuming workloads could not be restarted yet
+	pendingRestarts map[string]bool
+	// tlsNotAfterSeries keeps the '<namespace>/<name>' series of the secretTLSNotAfter gauge set by the sync
+	tlsNotAfterSeries map[string]tlsSeries
+	// syncNow triggers a sync before the next backend scrape, when the namespaces of the cluster change
+	syncNow chan struct{}
+	// namespaceWatchOnce starts the namespace watch the first time a namespace selector is resolved
+	namespaceWatchOnce sync.Once
+	// namespaceWatchDone is closed once the namespace watch stopped, after done is closed. nil until it starts
+	namespaceWatchDone <-chan struct{}
+	// done is closed when the context of the secret manager is done
+	done <-chan struct{}
+	// syncMutex is held by the sync loop
+	syncMutex sync.Mutex
+}
+
+// backendMetadataRead holds the result of reading the backend metadata of a path
+type backendMetadataRead struct {
+	metadata *backend.SecretMetadata
+	err      error
+}
+
+// syncedVersions holds the backend versions a secret definition was last fully synced with
+type syncedVersions struct {
+	definition SecretDefinition
+	versions   map[string]int
+	syncedAt   time.Time
 }
 
 // https://golang.org/pkg/time/#pkg-constants
 const timestampFormat = "2006-01-02T15.04.05Z"
 const configMapKeySecretDefinitions = "secretDefinitions"
 
+// Annotations tracing a secret back to the backend secrets it was generated from, as JSON maps by backend path
+const (
+	backendVersionsAnnotation     = "secrets-manager.tuenti.io/backend-versions"
+	backendUpdatedTimesAnnotation = "secrets-manager.tuenti.io/backend-updated-times"
+)
+
 var logger *log.Logger
 
+// configReloadBackoff spaces the retries of a change of the config source that failed to load
+var configReloadBackoff = backend.Backoff{Initial: time.Second, Max: time.Minute}
+
 func New(ctx context.Context, config Config, kubernetes k8s.Client, backend backend.Client, l *log.Logger) (*SecretManager, error) {
 	secretManager := new(SecretManager)
 
@@ -42,30 +123,82 @@ func New(ctx context.Context, config Config, kubernetes k8s.Client, backend back
 		return nil, &errors.InvalidConfigmapNameError{ErrType: errors.InvalidConfigmapNameErrorType, Value: config.ConfigMap}
 	}
 
-	secretManager.backendScrapeInterval = config.BackendScrapeInterval
-	secretManager.configMapRefreshInterval = config.ConfigMapRefreshInterval
+	switch config.Source {
+	case "", ConfigMapSource:
+		secretManager.source = ConfigMapSource
+	case CustomResourceSource:
+		secretManager.source = CustomResourceSource
+	default:
+		return nil, &errors.InvalidConfigSourceError{ErrType: errors.InvalidConfigSourceErrorType, Value: config.Source}
+	}
+
+	switch config.PrunePolicy {
+	case "", PruneNone:
+		secretManager.prunePolicy = PruneNone
+	case PruneReport, PruneOrphan, PruneDelete:
+		secretManager.prunePolicy = config.PrunePolicy
+	default:
+		return nil, &errors.InvalidPrunePolicyError{ErrType: errors.InvalidPrunePolicyErrorType, Value: config.PrunePolicy}
+	}
+	secretManager.pruneInterval = config.PruneInterval
+	secretManager.pruneGracePeriod = config.PruneGracePeriod
+	secretManager.pruneDryRun = config.PruneDryRun
+	secretManager.pruneCandidates = make(map[string]time.Time)
+	secretManager.configMapPruneCandidates = make(map[string]time.Time)
+	secretManager.tlsNotAfterSeries = make(map[string]tlsSeries)
+	secretManager.checksumKeySecret = config.ChecksumKeySecret
+	if secretManager.checksumKeySecret == "" {
+		secretManager.checksumKeySecret = defaultChecksumKeySecret
+	}
+	secretManager.pendingRestarts = make(map[string]bool)
 
+	secretManager.backendScrapeInterval = config.BackendScrapeInterval
+	secretManager.fullResyncInterval = config.FullResyncInterval
+	secretManager.syncedVersions = make(map[string]*syncedVersions)
+	secretManager.statuses = make(map[string]*writtenStatus)
+	secretManager.namespacePathPrefixes = config.NamespacePathPrefixes
 	secretManager.kubernetes = kubernetes
 	secretManager.backend = backend
+	secretManager.syncNow = make(chan struct{}, 1)
+	secretManager.done = ctx.Done()
 	logger = l
 
 	return secretManager, nil
 }
 
+// Start watches the config source and syncs the secrets until ctx is done
 func (s *SecretManager) Start(ctx context.Context) {
-	// Start periodic refreshes of configmap configuration
-	s.startConfigMapRefresh(ctx)
+	s.Watch(ctx)
+	s.Sync(ctx)
+}
+
+// Watch loads the secret definitions and reloads them every time the config source changes, until ctx is done.
+// Replicas that are not the leader only watch, to be ready to sync as soon as they become the leader
+func (s *SecretManager) Watch(ctx context.Context) {
+	// Watch the config source to reload the secret definitions on every change
+	s.startConfigWatch(ctx)
+}
+
+// Sync syncs the secrets every backend scrape until ctx is done. Once ctx is done the sync stops before its next
+// write, and the rest is left to the next sync
+func (s *SecretManager) Sync(ctx context.Context) {
+	// Successive leadership terms never sync at the same time
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	// What was synced or found undefined in a previous term is stale, the leader of the terms in between may
+	// have changed it
+	s.syncedVersions = make(map[string]*syncedVersions)
+	s.pruneCandidates = make(map[string]time.Time)
+	s.configMapPruneCandidates = make(map[string]time.Time)
+	s.lastPrune = time.Time{}
 
 	for {
 		select {
 		case <-time.After(s.backendScrapeInterval):
-			//Read Secret list
-			logger.Debugf("syncing - found %d secrets", len(s.secretDefinitions))
-
-			for _, secret := range s.secretDefinitions {
-				logger.Debugf("syncing secret: %s", secret.Name)
-				s.syncState(secret)
-			}
+			s.syncAll(ctx)
+		case <-s.syncNow:
+			logger.Debugf("namespaces changed, syncing")
+			s.syncAll(ctx)
 		case <-ctx.Done():
 			log.Infoln("gracefully shutting down configmap refresh go routine")
 			return
@@ -74,7 +207,48 @@ func (s *SecretManager) Start(ctx context.Context) {
 	}
 }
 
+// syncAll syncs every secret definition to the namespaces it currently targets, then prunes if it is time to.
+// It stops before the next write once ctx is done
+func (s *SecretManager) syncAll(ctx context.Context) {
+	//Read Secret list
+	secretDefinitions := s.getSecretDefinitions()
+	logger.Debugf("syncing - found %d secrets", len(secretDefinitions))
+
+	// The expiration times of the TLS secrets are only dropped once every definition is known to be resolved
+	tlsDefinitions := make(map[string]SecretDefinition)
+	resolvedAll := true
+	for _, secret := range secretDefinitions {
+		if ctx.Err() != nil {
+			logger.Infof("sync cancelled before secret: %s", secret.Name)
+			return
+		}
+		logger.Debugf("syncing secret: %s", secret.Name)
+		resolved, err := s.resolveNamespaces(secret)
+		if err != nil {
+			logger.Errorf("unable to resolve the namespaces of secret '%s': %v", secret.Name, err)
+			s.reportStatus(secret, syncResult{kubernetesErr: err})
+			resolvedAll = false
+			continue
+		}
+		if resolved.Type == tlsSecretType {
+			tlsDefinitions[resolved.key()] = resolved
+		}
+		s.syncState(ctx, resolved)
+	}
+	if ctx.Err() == nil && resolvedAll {
+		s.deleteStaleTLSNotAfter(tlsDefinitions)
+	}
+
+	if ctx.Err() == nil && s.prunePolicy != PruneNone && time.Since(s.lastPrune) >= s.pruneInterval {
+		s.prune()
+		s.lastPrune = time.Now()
+	}
+}
+
 func (s *SecretManager) loadSecretDefinitions() error {
+	if s.source == CustomResourceSource {
+		return s.loadSecretDefinitionResources()
+	}
 	configMapContent, err := s.kubernetes.ReadConfigMap(s.configMapName, s.configMapNamespace, configMapKeySecretDefinitions)
 	if err != nil {
 		logger.Errorf("unable to load config: %s", err.Error())
@@ -85,88 +259,376 @@ func (s *SecretManager) loadSecretDefinitions() error {
 		logger.Errorf("unable to load config: %s", err.Error())
 		return err
 	}
-	s.secretDefinitions = secretDefinitions
+	s.setSecretDefinitions(secretDefinitions, nil)
 	return nil
 }
 
+func (s *SecretManager) getSecretDefinitions() SecretDefinitions {
+	s.definitionsMutex.RLock()
+	defer s.definitionsMutex.RUnlock()
+	return s.secretDefinitions
+}
+
+func (s *SecretManager) setSecretDefinitions(secretDefinitions SecretDefinitions, skipped SecretDefinitions) {
+	s.definitionsMutex.Lock()
+	defer s.definitionsMutex.Unlock()
+	s.secretDefinitions = secretDefinitions
+	s.skippedDefinitions = skipped
+	s.definitionsLoaded = true
+}
+
+// readDatasource will read and decode a secret from the backend, selecting its JSONPath if any
+func (s *SecretManager) readDatasource(v Datasource) ([]byte, error) {
+	bSecret, err := s.backend.ReadSecret(v.Path, v.Key)
+	if err != nil {
+		logger.Errorf("unable to read secret '%s/%s' from backend: %v", v.Path, v.Key, err)
+		return nil, err
+	}
+
+	decoder, err := backend.NewDecoder(v.Encoding)
+	if err != nil {
+		logger.Errorf("refusing to use encoding %s: %v", v.Encoding, err)
+		return nil, err
+	}
+	data, err := decoder.DecodeString(bSecret)
+	if err != nil {
+		logger.Errorf("unable to decode %s data for '%s/%s': %v", v.Encoding, v.Path, v.Key, err)
+		return nil, err
+	}
+	if v.JSONPath != "" {
+		data, err = selectJSONPath(v, data)
+		if err != nil {
+			logger.Errorf("unable to select %s from '%s/%s': %v", v.JSONPath, v.Path, v.Key, err)
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
 // getDesiredState will get the secrets from the backend source of truth
 func (s *SecretManager) getDesiredState(secret SecretDefinition) (map[string][]byte, error) {
 	desiredState := make(map[string][]byte)
-	var err error
 	for k, v := range secret.Data {
-		bSecret, err := s.backend.ReadSecret(v.Path, v.Key)
+		var data []byte
+		var err error
+		switch {
+		case v.Template != "":
+			data, err = s.renderTemplate(secret, k, v)
+			if err != nil {
+				logger.Errorf("unable to render template for key '%s' of secret '%s': %v", k, secret.Name, err)
+			}
+		case v.Keystore != nil:
+			data, err = s.buildKeystore(secret, k, v.Keystore)
+			if err != nil {
+				logger.Errorf("unable to build keystore for key '%s' of secret '%s': %v", k, secret.Name, err)
+			}
+		default:
+			data, err = s.readDatasource(v)
+			if err == nil && v.FromPKCS12 != nil {
+				data, err = s.extractPKCS12(secret, k, data, v.FromPKCS12)
+				if err != nil {
+					logger.Errorf("unable to extract PKCS#12 %s for key '%s' of secret '%s': %v", v.FromPKCS12.Output, k, secret.Name, err)
+				}
+			}
+		}
 		if err != nil {
-			logger.Errorf("unable to read secret '%s/%s' from backend: %v", v.Path, v.Key, err)
 			return nil, err
 		}
+		desiredState[k] = data
+	}
 
-		decoder, err := backend.NewDecoder(v.Encoding)
+	if len(secret.Registries) > 0 {
+		dockerConfig, err := s.getDockerConfigJSON(secret, desiredState[dockerConfigJSONKey])
 		if err != nil {
-			logger.Errorf("refusing to use encoding %s: %v", v.Encoding, err)
+			logger.Errorf("unable to build docker config for secret '%s': %v", secret.Name, err)
 			return nil, err
 		}
-		desiredState[k], err = decoder.DecodeString(bSecret)
-		if err != nil {
-			logger.Errorf("unable to decode %s data for '%s/%s': %v", v.Encoding, v.Path, v.Key, err)
+		desiredState[dockerConfigJSONKey] = dockerConfig
+	}
+	if secret.Type == dockerConfigJSONSecretType {
+		if err := validateDockerConfigJSON(secret.Name, desiredState[dockerConfigJSONKey]); err != nil {
+			logger.Errorf("refusing to write secret '%s': %v", secret.Name, err)
 			return nil, err
 		}
 	}
-	return desiredState, err
+	if err := checkTLSSecret(secret, desiredState); err != nil {
+		logger.Errorf("refusing to write secret '%s': %v", secret.Name, err)
+		return nil, err
+	}
+	return desiredState, nil
+}
+
+// getDesiredMetadata will get the labels and annotations of the secret from the backend metadata of its datasources
+func (s *SecretManager) getDesiredMetadata(secret SecretDefinition) (map[string]string, map[string]string) {
+	labels := make(map[string]string)
+	annotations := make(map[string]string)
+	versions := make(map[string]int)
+	updatedTimes := make(map[string]string)
+
+	seen := make(map[string]bool)
+	paths := make([]string, 0, len(secret.Data))
+	for _, v := range secret.datasources() {
+		if !seen[v.Path] {
+			seen[v.Path] = true
+			paths = append(paths, v.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		metadata, err := s.readSecretMetadata(path)
+		if err != nil {
+			if !errors.IsVaultSecretMetadataNotSupported(err) {
+				logger.Warnf("unable to read metadata of secret '%s' from backend: %v", path, err)
+			}
+			continue
+		}
+		versions[path] = metadata.Version
+		updatedTimes[path] = metadata.UpdatedTime.Format(time.RFC3339)
+
+		for _, key := range secret.BackendMetadata.Labels {
+			value, ok := metadata.CustomMetadata[key]
+			if !ok {
+				continue
+			}
+			if errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...); len(errs) > 0 {
+				logger.Warnf("skipping label '%s' from metadata of secret '%s': %s", key, path, strings.Join(errs, ", "))
+				continue
+			}
+			labels[key] = value
+		}
+		for _, key := range secret.BackendMetadata.Annotations {
+			value, ok := metadata.CustomMetadata[key]
+			if !ok {
+				continue
+			}
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				logger.Warnf("skipping annotation '%s' from metadata of secret '%s': %s", key, path, strings.Join(errs, ", "))
+				continue
+			}
+			annotations[key] = value
+		}
+	}
+
+	if len(versions) > 0 {
+		v, _ := json.Marshal(versions)
+		annotations[backendVersionsAnnotation] = string(v)
+		u, _ := json.Marshal(updatedTimes)
+		annotations[backendUpdatedTimesAnnotation] = string(u)
+	}
+	return labels, annotations
 }
 
 // getCurrentState will get the secrets from Kubernetes API
-func (s *SecretManager) getCurrentState(namespace string, name string) (map[string][]byte, error) {
-	currentState, err := s.kubernetes.ReadSecret(namespace, name)
+func (s *SecretManager) getCurrentState(namespace string, name string) (*k8s.Secret, error) {
+	currentState, err := s.kubernetes.GetSecret(namespace, name)
 	if err != nil {
 		logger.Debugf("failed to read '%s/%s' secret from kubernetes api: %v", namespace, name, err)
 	}
 	return currentState, err
 }
 
-func (s *SecretManager) syncState(secret SecretDefinition) error {
+// syncState syncs the secret to every namespace of its definition, reporting its status unless ctx is done
+// before it is written everywhere
+func (s *SecretManager) syncState(ctx context.Context, secret SecretDefinition) error {
+	s.backendMetadata = make(map[string]backendMetadataRead)
+	defer func() { s.backendMetadata = nil }()
+	if secret.Mirror != nil {
+		return s.syncMirror(ctx, secret)
+	}
+
+	versions, versioned := s.getBackendVersions(secret)
+	if versioned && s.isUpToDate(secret, versions) && !s.hasPendingRestarts(secret) {
+		logger.Debugf("backend versions of secret '%s' unchanged, skipping sync", secret.Name)
+		return nil
+	}
+	delete(s.syncedVersions, secret.key())
+
 	desiredState, err := s.getDesiredState(secret)
 	if err != nil {
 		logger.Errorf("unable to get desired state for secret '%s' : %v", secret.Name, err)
 		for _, namespace := range secret.Namespaces {
 			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.BackendReadFailedReason, err)
 		}
+		s.reportStatus(secret, syncResult{backendErr: err})
 		return err
 	}
+	var synced []string
+	if secret.Immutable != nil {
+		synced, err = s.syncImmutable(ctx, secret, desiredState)
+	} else {
+		synced, err = s.writeState(ctx, secret, desiredState)
+	}
+	if ctx.Err() != nil {
+		logger.Infof("sync of secret '%s' cancelled", secret.Name)
+		return ctx.Err()
+	}
+	if err == nil && versioned {
+		s.syncedVersions[secret.key()] = &syncedVersions{definition: secret, versions: versions, syncedAt: time.Now()}
+	}
+	s.reportStatus(secret, syncResult{synced: synced, kubernetesErr: err})
+	return nil
+}
+
+// readSecretMetadata reads the backend metadata of path, only once during the sync of a secret definition
+func (s *SecretManager) readSecretMetadata(path string) (*backend.SecretMetadata, error) {
+	if read, ok := s.backendMetadata[path]; ok {
+		return read.metadata, read.err
+	}
+	metadata, err := s.backend.ReadSecretMetadata(path)
+	if s.backendMetadata != nil {
+		s.backendMetadata[path] = backendMetadataRead{metadata: metadata, err: err}
+	}
+	return metadata, err
+}
+
+// getBackendVersions returns the current backend version of every path of the secret, or false if
+// any of them can not be known
+func (s *SecretManager) getBackendVersions(secret SecretDefinition) (map[string]int, bool) {
+	if s.fullResyncInterval <= 0 {
+		return nil, false
+	}
+	versions := make(map[string]int)
+	for _, v := range secret.datasources() {
+		if _, ok := versions[v.Path]; ok {
+			continue
+		}
+		metadata, err := s.readSecretMetadata(v.Path)
+		if err != nil {
+			if !errors.IsVaultSecretMetadataNotSupported(err) {
+				logger.Debugf("unable to read metadata of secret '%s' from backend: %v", v.Path, err)
+			}
+			return nil, false
+		}
+		versions[v.Path] = metadata.Version
+	}
+	return versions, true
+}
+
+// isUpToDate returns true if the secret was fully synced less than fullResyncInterval ago, with the same
+// definition and backend versions
+func (s *SecretManager) isUpToDate(secret SecretDefinition, versions map[string]int) bool {
+	synced, ok := s.syncedVersions[secret.key()]
+	if !ok || time.Since(synced.syncedAt) >= s.fullResyncInterval {
+		return false
+	}
+	return reflect.DeepEqual(synced.definition, secret) && reflect.DeepEqual(synced.versions, versions)
+}
+
+// writeState upserts the secret in every namespace where its current data, labels or annotations differ from
+// the desired ones, returning the namespaces in sync and the last error found, if any. It stops before the next
+// write once ctx is done
+func (s *SecretManager) writeState(ctx context.Context, secret SecretDefinition, desiredState map[string][]byte) ([]string, error) {
+	synced := make([]string, 0, len(secret.Namespaces))
+	var lastErr error
+	// Backend metadata is rendered into the secret, so it is part of the drift check too
+	backendLabels, backendAnnotations := s.getDesiredMetadata(secret)
+	var notAfter time.Time
+	hasNotAfter := false
+	if secret.Type == tlsSecretType {
+		notAfter, hasNotAfter = tlsNotAfter(desiredState)
+	}
 	for _, namespace := range secret.Namespaces {
-		currentState, err := s.getCurrentState(namespace, secret.Name)
-		if err != nil && !errors.IsK8sSecretNotFound(err) {
+		labels, annotations, err := secret.renderMetadata(namespace)
+		if err != nil {
+			logger.Errorf("unable to render metadata of secret '%s/%s' : %v", namespace, secret.Name, err)
+			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.SyncFailedReason, err)
+			lastErr = err
+			continue
+		}
+		labels = mergeMetadata(backendLabels, labels)
+		annotations = mergeMetadata(annotations, backendAnnotations)
+		var currentState *k8s.Secret
+		if secret.isConfigMap() {
+			currentState, err = s.getCurrentConfigMap(namespace, secret.Name)
+		} else {
+			currentState, err = s.getCurrentState(namespace, secret.Name)
+		}
+		if err != nil && !errors.IsK8sSecretNotFound(err) && !errors.IsK8sConfigMapNotFound(err) {
 			logger.Errorf("unable to get current state of secret '%s/%s' : %v", namespace, secret.Name, err)
 			secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+			s.recordFailure(secret, namespace, k8s.SyncFailedReason, err)
+			lastErr = err
 			// If we fail to read from Kubernetes, we keep trying with another namespace
 			continue
 		}
-		eq := reflect.DeepEqual(desiredState, currentState)
+		eq := currentState != nil && secret.dataInSync(currentState, desiredState, labels, annotations) &&
+			hasMetadata(currentState.Labels, labels) && hasMetadata(currentState.Annotations, annotations)
 		if !eq {
+			if ctx.Err() != nil {
+				return synced, ctx.Err()
+			}
 			logger.Infof("secret '%s/%s' must be updated", namespace, secret.Name)
-			if err := s.upsertSecret(secret.Type, namespace, secret.Name, desiredState); err != nil {
+			if err := s.upsertSecret(secret, namespace, desiredState, labels, annotations); err != nil {
 				log.Errorf("unable to upsert secret %s/%s: %v", namespace, secret.Name, err)
 				secretSyncErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+				// Secrets not owned already have an event explaining why they are not updated
+				if !errors.IsK8sSecretNotOwned(err) && !errors.IsK8sConfigMapNotOwned(err) {
+					s.recordFailure(secret, namespace, k8s.SyncFailedReason, err)
+				}
+				lastErr = err
 				continue
 			}
 			logger.Infof("secret '%s/%s' updated", namespace, secret.Name)
+			if currentState != nil && secret.dataChanged(currentState, desiredState) {
+				s.pendingRestarts[namespace+"/"+secret.Name] = true
+			}
+		}
+		// Restarts that failed are retried on every sync, restarting only the workloads not annotated yet
+		if s.pendingRestarts[namespace+"/"+secret.Name] {
+			if ctx.Err() != nil {
+				return synced, ctx.Err()
+			}
+			if s.restartWorkloads(secret, namespace, desiredState) {
+				delete(s.pendingRestarts, namespace+"/"+secret.Name)
+			}
 		}
+		if hasNotAfter {
+			s.setTLSNotAfter(secret, namespace, notAfter)
+		}
+		synced = append(synced, namespace)
 	}
-	return nil
+	return synced, lastErr
 }
 
-func (s *SecretManager) upsertSecret(secretType string, namespace string, name string, data map[string][]byte) error {
+func (s *SecretManager) upsertSecret(secretDef SecretDefinition, namespace string, data map[string][]byte, labels map[string]string, annotations map[string]string) error {
+	name := secretDef.Name
 	lastUpdate := time.Now()
+	secretLabels := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		secretLabels[k] = v
+	}
+	secretLabels[k8s.ManagedByLabel] = k8s.ManagedByValue
+	secretLabels[lastUpdateLabel] = lastUpdate.Format(timestampFormat)
+
 	secret := &k8s.Secret{
-		Type: secretType,
-		Name: name,
-		Labels: map[string]string{
-			"managedBy":  "secrets-manager",
-			"lastUpdate": lastUpdate.Format(timestampFormat),
-		},
-		Namespace: namespace,
-		Data:      data,
-	}
-	err := s.kubernetes.UpsertSecret(secret)
+		Type:        secretDef.Type,
+		Name:        name,
+		Labels:      secretLabels,
+		Annotations: annotations,
+		Namespace:   namespace,
+		Data:        data,
+		Adopt:       secretDef.Adopt,
+		Merge:       secretDef.UpdateStrategy == UpdateMerge,
+		Immutable:   secretDef.Immutable != nil,
+	}
+	var err error
+	if secretDef.isConfigMap() {
+		text, binary := configMapData(data)
+		err = s.kubernetes.UpsertConfigMap(&k8s.ConfigMap{
+			Name:        name,
+			Namespace:   namespace,
+			Data:        text,
+			BinaryData:  binary,
+			Labels:      secretLabels,
+			Annotations: annotations,
+			Adopt:       secretDef.Adopt,
+		})
+	} else {
+		err = s.kubernetes.UpsertSecret(secret)
+	}
 	if err != nil {
 		log.Errorf("unable to upsert secret %s/%s: %v", namespace, name, err)
 		return err
@@ -175,19 +637,76 @@ func (s *SecretManager) upsertSecret(secretType string, namespace string, name s
 	return nil
 }
 
-func (s *SecretManager) startConfigMapRefresh(ctx context.Context) {
+// startConfigWatch loads the secret definitions and watches the config source to reload them every time
+// it changes, until ctx is done
+func (s *SecretManager) startConfigWatch(ctx context.Context) {
 	// initial load of secretDefinitions
 	s.loadSecretDefinitions()
 
-	go func(ctx context.Context) {
-		for {
-			select {
-			case <-time.After(s.configMapRefreshInterval):
-				s.loadSecretDefinitions()
-			case <-ctx.Done():
-				log.Infoln("gracefully shutting down configmap refresh go routine")
-				return
-			}
+	onChange := func(resourceVersion string) {
+		s.configMutex.Lock()
+		defer s.configMutex.Unlock()
+		// Relisting after a disconnect notifies the unchanged resources again
+		if resourceVersion == s.loadedResourceVersion {
+			return
 		}
-	}(ctx)
+		retrying := s.failedResourceVersion != ""
+		if err := s.loadResourceVersion(resourceVersion); err != nil && !retrying {
+			go s.retryConfigLoad(ctx)
+		}
+	}
+
+	if s.source == CustomResourceSource {
+		if err := s.kubernetes.WatchSecretDefinitions(onChange, ctx.Done()); err != nil {
+			logger.Errorf("unable to watch secret definitions: %v", err)
+		}
+		return
+	}
+	s.kubernetes.WatchConfigMap(s.configMapName, s.configMapNamespace, onChange, ctx.Done())
+}
+
+// loadResourceVersion loads the secret definitions after the config source changed to resourceVersion,
+// keeping it as the failed one when they can not be loaded. It must be called with configMutex held
+func (s *SecretManager) loadResourceVersion(resourceVersion string) error {
+	if err := s.loadSecretDefinitions(); err != nil {
+		s.failedResourceVersion = resourceVersion
+		return err
+	}
+	s.loadedResourceVersion = resourceVersion
+	s.failedResourceVersion = ""
+	// The configmap may have been recreated
+	s.configMapUID = ""
+	logger.Infof("secret definitions loaded from %s at resourceVersion %s", s.source, resourceVersion)
+	if v, err := strconv.ParseFloat(resourceVersion, 64); err == nil {
+		configResourceVersion.WithLabelValues(s.source).Set(v)
+	}
+	return nil
+}
+
+// retryConfigLoad loads the secret definitions again with configReloadBackoff until they are loaded or ctx is
+// done, so a change of the config source that failed to load is not dropped until the next change
+func (s *SecretManager) retryConfigLoad(ctx context.Context) {
+	wait := configReloadBackoff.Initial
+	for {
+		logger.Warnf("unable to load the secret definitions from %s, retrying in %s", s.source, wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		s.configMutex.Lock()
+		failed := s.failedResourceVersion
+		if failed == "" {
+			// A newer change was loaded meanwhile
+			s.configMutex.Unlock()
+			return
+		}
+		err := s.loadResourceVersion(failed)
+		s.configMutex.Unlock()
+		if err == nil {
+			return
+		}
+		wait = configReloadBackoff.Next(wait)
+	}
 }
@@ -4,16 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"testing"
 
 	gomock "github.com/golang/mock/gomock"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tuenti/secrets-manager/backend"
 	e "github.com/tuenti/secrets-manager/errors"
 	"github.com/tuenti/secrets-manager/kubernetes"
 	"github.com/tuenti/secrets-manager/mocks"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
 
 	log "github.com/sirupsen/logrus"
 
@@ -27,7 +34,10 @@ type fakeBackendSecret struct {
 }
 
 type fakeBackend struct {
-	fakeSecrets []fakeBackendSecret
+	fakeSecrets  []fakeBackendSecret
+	fakeMetadata map[string]*backend.SecretMetadata
+	// metadataReads counts the reads of the metadata of every path, if set
+	metadataReads map[string]int
 }
 
 func (f fakeBackend) ReadSecret(path string, key string) (string, error) {
@@ -39,6 +49,45 @@ func (f fakeBackend) ReadSecret(path string, key string) (string, error) {
 	return "", errors.New("Not found")
 }
 
+func (f fakeBackend) ReadSecretData(path string) (map[string]string, error) {
+	data := make(map[string]string)
+	for _, fakeSecret := range f.fakeSecrets {
+		if fakeSecret.Path == path {
+			data[fakeSecret.Key] = fakeSecret.Content
+		}
+	}
+	if len(data) == 0 {
+		return nil, errors.New("Not found")
+	}
+	return data, nil
+}
+
+func (f fakeBackend) ListSecrets(path string) ([]string, error) {
+	seen := make(map[string]bool)
+	paths := []string{}
+	for _, fakeSecret := range f.fakeSecrets {
+		if strings.HasPrefix(fakeSecret.Path, path+"/") && !seen[fakeSecret.Path] {
+			seen[fakeSecret.Path] = true
+			paths = append(paths, fakeSecret.Path)
+		}
+	}
+	return paths, nil
+}
+
+func (f fakeBackend) ReadSecretMetadata(path string) (*backend.SecretMetadata, error) {
+	if f.metadataReads != nil {
+		f.metadataReads[path]++
+	}
+	if metadata, ok := f.fakeMetadata[path]; ok {
+		return metadata, nil
+	}
+	return nil, &e.VaultSecretMetadataNotSupportedError{ErrType: e.VaultSecretMetadataNotSupportedErrorType, Engine: "kv1", Path: path}
+}
+
+func (f fakeBackend) Close() error {
+	return nil
+}
+
 func newFakeBackend(fakeSecrets []fakeBackendSecret) fakeBackend {
 	return fakeBackend{
 		fakeSecrets: fakeSecrets,
@@ -176,6 +225,145 @@ func TestGetDesiredStateBackendError(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestGetDesiredMetadata(t *testing.T) {
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	fakeBackend.fakeMetadata = map[string]*backend.SecretMetadata{
+		"secret/data/path": {
+			Version:     3,
+			UpdatedTime: time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC),
+			CustomMetadata: map[string]string{
+				"owner":       "team-a",
+				"description": "not a valid label value",
+			},
+		},
+	}
+	logger := log.New()
+	k8s := kubernetes.New(fake.NewSimpleClientset(), logger)
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+
+	labels, annotations := secretManager.getDesiredMetadata(SecretDefinition{
+		Data: map[string]Datasource{
+			"key1": {Path: "secret/data/path", Key: "key1"},
+			"key2": {Path: "secret/data/path", Key: "key2"},
+			"key3": {Path: "secret/kv1/path", Key: "key3"},
+		},
+		BackendMetadata: BackendMetadata{
+			Labels:      []string{"owner", "description", "missing"},
+			Annotations: []string{"description"},
+		},
+	})
+
+	assert.Equal(t, map[string]string{"owner": "team-a"}, labels)
+	assert.Equal(t, map[string]string{
+		"description":                 "not a valid label value",
+		backendVersionsAnnotation:     `{"secret/data/path":3}`,
+		backendUpdatedTimesAnnotation: `{"secret/data/path":"2019-02-01T10:00:00Z"}`,
+	}, annotations)
+}
+
+func TestGetDesiredMetadataNotSupported(t *testing.T) {
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	k8s := kubernetes.New(fake.NewSimpleClientset(), logger)
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+
+	labels, annotations := secretManager.getDesiredMetadata(SecretDefinition{
+		Data: map[string]Datasource{
+			"key1": {Path: "secret/path", Key: "key1"},
+		},
+		BackendMetadata: BackendMetadata{
+			Labels: []string{"owner"},
+		},
+	})
+
+	assert.Empty(t, labels)
+	assert.Empty(t, annotations)
+}
+
+func TestSyncStateBackendMetadataDrift(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "ns",
+			Labels:    map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"password": []byte("s3cr3t")},
+	})
+	logger := log.New()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/db", "password", "s3cr3t"},
+	})
+	fakeBackend.fakeMetadata = map[string]*backend.SecretMetadata{
+		"secret/data/db": {
+			Version:        3,
+			UpdatedTime:    time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC),
+			CustomMetadata: map[string]string{"owner": "team-a"},
+		},
+	}
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm"}, kubernetes.New(clientSet, logger), fakeBackend, logger)
+	definition := SecretDefinition{
+		Name:            "db",
+		Namespaces:      []string{"ns"},
+		Type:            "Opaque",
+		BackendMetadata: BackendMetadata{Labels: []string{"owner"}},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}
+
+	// The data is in sync, but not the backend metadata
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	secret, _ := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+	assert.Equal(t, `{"secret/data/db":3}`, secret.Annotations[backendVersionsAnnotation])
+
+	// Nothing is written while the backend metadata is unchanged
+	clientSet.ClearActions()
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	for _, action := range clientSet.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+
+	// A new backend version with the same data is written too
+	fakeBackend.fakeMetadata["secret/data/db"].Version = 4
+	fakeBackend.fakeMetadata["secret/data/db"].CustomMetadata["owner"] = "team-b"
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	secret, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, "team-b", secret.Labels["owner"])
+	assert.Equal(t, `{"secret/data/db":4}`, secret.Annotations[backendVersionsAnnotation])
+}
+
+func TestSyncStateReadsMetadataOnce(t *testing.T) {
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/db", "password", "s3cr3t"},
+		{"secret/data/db", "user", "admin"},
+	})
+	fakeBackend.fakeMetadata = map[string]*backend.SecretMetadata{
+		"secret/data/db": {Version: 3, CustomMetadata: map[string]string{"owner": "team-a"}},
+	}
+	fakeBackend.metadataReads = make(map[string]int)
+	logger := log.New()
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm", FullResyncInterval: time.Hour}, kubernetes.New(fake.NewSimpleClientset(), logger), fakeBackend, logger)
+
+	// The metadata is read once for the versions and the labels, even with several datasources on the same path
+	assert.Nil(t, secretManager.syncState(context.Background(), SecretDefinition{
+		Name:            "db",
+		Namespaces:      []string{"ns1", "ns2"},
+		Type:            "Opaque",
+		BackendMetadata: BackendMetadata{Labels: []string{"owner"}},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+			"user":     {Path: "secret/data/db", Key: "user"},
+		},
+	}))
+	assert.Equal(t, map[string]int{"secret/data/db": 1}, fakeBackend.metadataReads)
+}
+
 func TestGetCurrentState(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -183,7 +371,7 @@ func TestGetCurrentState(t *testing.T) {
 	fakeSecretData := map[string][]byte{
 		"value1": []byte("Fake Value"),
 	}
-	k8s.EXPECT().ReadSecret("ns", "secret-name").AnyTimes().Return(fakeSecretData, nil)
+	k8s.EXPECT().GetSecret("ns", "secret-name").AnyTimes().Return(&kubernetes.Secret{Data: fakeSecretData}, nil)
 
 	ctx := context.Background()
 	fakeBackend := newFakeBackend([]fakeBackendSecret{})
@@ -191,17 +379,17 @@ func TestGetCurrentState(t *testing.T) {
 	cfg := Config{ConfigMap: "cm"}
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
-	data, err := secretManager.getCurrentState("ns", "secret-name")
+	current, err := secretManager.getCurrentState("ns", "secret-name")
 
 	assert.Nil(t, err)
-	assert.Equal(t, fakeSecretData, data)
+	assert.Equal(t, fakeSecretData, current.Data)
 }
 
 func TestGetCurrentStateError(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	k8s := mocks.NewMockKubernetesClient(mockCtrl)
-	k8s.EXPECT().ReadSecret("ns", "secret-name").AnyTimes().Return(nil, errors.New("some-error"))
+	k8s.EXPECT().GetSecret("ns", "secret-name").AnyTimes().Return(nil, errors.New("some-error"))
 
 	ctx := context.Background()
 	fakeBackend := newFakeBackend([]fakeBackendSecret{})
@@ -236,16 +424,41 @@ func TestUpsertSecret(t *testing.T) {
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
 	err := secretManager.upsertSecret(
-		"Opaque",
+		SecretDefinition{Name: "secret-name", Type: "Opaque"},
 		"ns",
-		"secret-name",
 		map[string][]byte{
 			"value1": []byte("fake-data"),
-		})
+		},
+		nil,
+		nil)
 
 	assert.Nil(t, err)
 }
 
+func TestUpsertSecretWithMetadata(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, kubernetes.New(client, logger), fakeBackend, logger)
+
+	err := secretManager.upsertSecret(
+		SecretDefinition{Name: "secret-name", Type: "Opaque"},
+		"ns",
+		map[string][]byte{
+			"value1": []byte("fake-data"),
+		},
+		map[string]string{"owner": "team-a", "managedBy": "someone-else"},
+		map[string]string{backendVersionsAnnotation: `{"secret/data/path":3}`})
+
+	assert.Nil(t, err)
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-name", metav1.GetOptions{})
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+	assert.Equal(t, "secrets-manager", secret.Labels["managedBy"])
+	assert.Equal(t, `{"secret/data/path":3}`, secret.Annotations[backendVersionsAnnotation])
+}
+
 func TestUpsertSecretError(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -268,12 +481,13 @@ func TestUpsertSecretError(t *testing.T) {
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
 	err := secretManager.upsertSecret(
-		"Opaque",
+		SecretDefinition{Name: "secret-name", Type: "Opaque"},
 		"ns",
-		"secret-name",
 		map[string][]byte{
 			"value1": []byte("fake-data"),
-		})
+		},
+		nil,
+		nil)
 
 	assert.NotNil(t, err)
 }
@@ -296,7 +510,7 @@ func TestSyncState(t *testing.T) {
 		},
 	}
 
-	k8s.EXPECT().ReadSecret("ns", "secret-name").AnyTimes().Return(fakeCurrentSecretData, nil)
+	k8s.EXPECT().GetSecret("ns", "secret-name").AnyTimes().Return(&kubernetes.Secret{Data: fakeCurrentSecretData}, nil)
 	k8s.EXPECT().UpsertSecret(EqSecret(expectedSecret)).Times(1).Return(nil)
 
 	ctx := context.Background()
@@ -307,7 +521,7 @@ func TestSyncState(t *testing.T) {
 	cfg := Config{ConfigMap: "cm"}
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
-	err := secretManager.syncState(SecretDefinition{
+	err := secretManager.syncState(context.Background(), SecretDefinition{
 		Name:       "secret-name",
 		Namespaces: []string{"ns"},
 		Type:       "Opaque",
@@ -330,6 +544,9 @@ func TestSyncStateErrorGetDesired(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+	k8s.EXPECT().GetConfigMap("default", "cm").Return(&kubernetes.ConfigMap{Name: "cm", Namespace: "default", UID: "1234"}, nil).Times(1)
+	configMap := &kubernetes.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Name: "cm", Namespace: "default", UID: "1234"}
+	k8s.EXPECT().RecordSecretEvent("ns", "secret-name", configMap, corev1.EventTypeWarning, kubernetes.BackendReadFailedReason, gomock.Any()).Times(1)
 
 	ctx := context.Background()
 	fakeBackend := newFakeBackend([]fakeBackendSecret{})
@@ -337,7 +554,7 @@ func TestSyncStateErrorGetDesired(t *testing.T) {
 	cfg := Config{ConfigMap: "cm"}
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
-	err := secretManager.syncState(SecretDefinition{
+	err := secretManager.syncState(context.Background(), SecretDefinition{
 		Name:       "secret-name",
 		Namespaces: []string{"ns"},
 		Type:       "Opaque",
@@ -381,9 +598,11 @@ func TestSyncStateErrorGetCurrentInOneSecret(t *testing.T) {
 		},
 	}
 
-	k8s.EXPECT().ReadSecret("ns1", "secret-name").AnyTimes().Return(fakeCurrentSecretData, nil)
-	k8s.EXPECT().ReadSecret("ns2", "secret-name").AnyTimes().Return(nil, errors.New("some error"))
-	k8s.EXPECT().ReadSecret("ns3", "secret-name").AnyTimes().Return(fakeCurrentSecretData, nil)
+	k8s.EXPECT().GetSecret("ns1", "secret-name").AnyTimes().Return(&kubernetes.Secret{Data: fakeCurrentSecretData}, nil)
+	k8s.EXPECT().GetSecret("ns2", "secret-name").AnyTimes().Return(nil, errors.New("some error"))
+	k8s.EXPECT().GetConfigMap("default", "cm").Return(&kubernetes.ConfigMap{Name: "cm", Namespace: "default", UID: "1234"}, nil).AnyTimes()
+	k8s.EXPECT().RecordSecretEvent("ns2", "secret-name", gomock.Any(), corev1.EventTypeWarning, kubernetes.SyncFailedReason, "some error").Times(1)
+	k8s.EXPECT().GetSecret("ns3", "secret-name").AnyTimes().Return(&kubernetes.Secret{Data: fakeCurrentSecretData}, nil)
 	k8s.EXPECT().UpsertSecret(EqSecret(expectedSecret1)).Times(1).Return(nil)
 	k8s.EXPECT().UpsertSecret(EqSecret(expectedSecret3)).Times(1).Return(nil)
 
@@ -395,7 +614,7 @@ func TestSyncStateErrorGetCurrentInOneSecret(t *testing.T) {
 	cfg := Config{ConfigMap: "cm"}
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
-	err := secretManager.syncState(SecretDefinition{
+	err := secretManager.syncState(context.Background(), SecretDefinition{
 		Name:       "secret-name",
 		Namespaces: []string{"ns1", "ns2", "ns3"},
 		Type:       "Opaque",
@@ -437,8 +656,10 @@ func TestSyncStateErrorUpsertSecret(t *testing.T) {
 		},
 	}
 
-	k8s.EXPECT().ReadSecret("ns1", "secret-name").AnyTimes().Return(fakeCurrentSecretData, nil)
+	k8s.EXPECT().GetSecret("ns1", "secret-name").AnyTimes().Return(&kubernetes.Secret{Data: fakeCurrentSecretData}, nil)
 	k8s.EXPECT().UpsertSecret(EqSecret(expectedSecret1)).Times(1).Return(errors.New("some error"))
+	k8s.EXPECT().GetConfigMap("default", "cm").Return(&kubernetes.ConfigMap{Name: "cm", Namespace: "default", UID: "1234"}, nil).AnyTimes()
+	k8s.EXPECT().RecordSecretEvent("ns1", "secret-name", gomock.Any(), corev1.EventTypeWarning, kubernetes.SyncFailedReason, "some error").Times(1)
 
 	ctx := context.Background()
 	fakeBackend := newFakeBackend([]fakeBackendSecret{
@@ -448,7 +669,7 @@ func TestSyncStateErrorUpsertSecret(t *testing.T) {
 	cfg := Config{ConfigMap: "cm"}
 	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
 
-	err := secretManager.syncState(SecretDefinition{
+	err := secretManager.syncState(context.Background(), SecretDefinition{
 		Name:       "secret-name",
 		Namespaces: []string{"ns1"},
 		Type:       "Opaque",
@@ -466,6 +687,100 @@ func TestSyncStateErrorUpsertSecret(t *testing.T) {
 	assert.Equal(t, 0.0, testutil.ToFloat64(metricSecretSyncErrorsCount))
 }
 
+func TestSyncStateSkipsUnchangedVersions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	expectedSecret := &kubernetes.Secret{
+		Name:      "secret-name",
+		Namespace: "ns",
+		Data: map[string][]byte{
+			"value1": []byte("fake-content"),
+		},
+	}
+
+	// Only the first and the last syncs read and write the secret
+	k8s.EXPECT().GetSecret("ns", "secret-name").Times(2).Return(&kubernetes.Secret{Data: map[string][]byte{}}, nil)
+	k8s.EXPECT().UpsertSecret(EqSecret(expectedSecret)).Times(2).Return(nil)
+
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/path", "key-in-vault", "fake-content"},
+	})
+	fakeBackend.fakeMetadata = map[string]*backend.SecretMetadata{
+		"secret/data/path": {Version: 1},
+	}
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm", FullResyncInterval: time.Hour}
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:       "secret-name",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"value1": {
+				Path: "secret/data/path",
+				Key:  "key-in-vault",
+			},
+		},
+	}
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	fakeBackend.fakeMetadata["secret/data/path"] = &backend.SecretMetadata{Version: 2}
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+}
+
+func TestSyncStateFullResync(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+
+	fakeCurrentSecretData := map[string][]byte{
+		"value1": []byte("fake-content"),
+	}
+
+	// The secret already has the backend metadata, as written by a previous sync
+	fakeCurrentSecret := &kubernetes.Secret{
+		Data: fakeCurrentSecretData,
+		Annotations: withManagedKeys(nil, map[string]string{
+			backendVersionsAnnotation:     `{"secret/data/path":1}`,
+			backendUpdatedTimesAnnotation: `{"secret/data/path":"0001-01-01T00:00:00Z"}`,
+		}),
+	}
+	k8s.EXPECT().GetSecret("ns", "secret-name").Times(2).Return(fakeCurrentSecret, nil)
+
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/path", "key-in-vault", "fake-content"},
+	})
+	fakeBackend.fakeMetadata = map[string]*backend.SecretMetadata{
+		"secret/data/path": {Version: 1},
+	}
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm", FullResyncInterval: time.Hour}
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:       "secret-name",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"value1": {
+				Path: "secret/data/path",
+				Key:  "key-in-vault",
+			},
+		},
+	}
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	secretManager.syncedVersions["secret-name"].syncedAt = time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+}
+
 func TestLoadConfig(t *testing.T) {
 	configText := `
 - name: supersecret1
@@ -550,3 +865,183 @@ func TestLoadConfigConfigMapBadYaml(t *testing.T) {
 
 	assert.NotNil(t, err)
 }
+
+func TestStartConfigWatch(t *testing.T) {
+	configText := `- name: secret1
+  namespaces:
+  - default
+  data:
+    value1:
+      path: secret/data/pathtosecret1
+      key: value`
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+	cfg := Config{ConfigMap: "cm"}
+
+	var onChange kubernetes.ChangeHandler
+	k8s.EXPECT().WatchConfigMap("cm", "default", gomock.Any(), ctx.Done()).Do(
+		func(name string, namespace string, handler kubernetes.ChangeHandler, stopCh <-chan struct{}) {
+			onChange = handler
+		})
+	// Loaded at startup and once more for the change, unchanged resourceVersions are not loaded again
+	k8s.EXPECT().ReadConfigMap("cm", "default", "secretDefinitions").Times(2).Return(configText, nil)
+
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+	secretManager.startConfigWatch(ctx)
+	assert.Len(t, secretManager.getSecretDefinitions(), 1)
+
+	onChange("42")
+	onChange("42")
+
+	assert.Equal(t, "42", secretManager.loadedResourceVersion)
+	metricConfigResourceVersion, _ := configResourceVersion.GetMetricWithLabelValues(ConfigMapSource)
+	assert.Equal(t, 42.0, testutil.ToFloat64(metricConfigResourceVersion))
+}
+
+func TestStartConfigWatchReloadError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	defer func(b backend.Backoff) { configReloadBackoff = b }(configReloadBackoff)
+	configReloadBackoff = backend.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	k8s := mocks.NewMockKubernetesClient(mockCtrl)
+	cfg := Config{ConfigMap: "cm"}
+
+	var onChange kubernetes.ChangeHandler
+	k8s.EXPECT().WatchConfigMap("cm", "default", gomock.Any(), ctx.Done()).Do(
+		func(name string, namespace string, handler kubernetes.ChangeHandler, stopCh <-chan struct{}) {
+			onChange = handler
+		})
+	loaded := make(chan struct{})
+	gomock.InOrder(
+		k8s.EXPECT().ReadConfigMap("cm", "default", "secretDefinitions").Times(3).Return("This is Bad Yaml", nil),
+		k8s.EXPECT().ReadConfigMap("cm", "default", "secretDefinitions").Do(
+			func(name string, namespace string, key string) { close(loaded) }).Return("", nil),
+	)
+
+	secretManager, _ := New(ctx, cfg, k8s, fakeBackend, logger)
+	secretManager.startConfigWatch(ctx)
+
+	// A resourceVersion that failed to load is retried until it is loaded
+	onChange("7")
+	select {
+	case <-loaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed config load not retried")
+	}
+	secretManager.configMutex.Lock()
+	defer secretManager.configMutex.Unlock()
+	assert.Equal(t, "7", secretManager.loadedResourceVersion)
+	assert.Equal(t, "", secretManager.failedResourceVersion)
+}
+
+func TestUpsertSecretAdopt(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret-name", Namespace: "ns"},
+		Data:       map[string][]byte{"value1": []byte("handmade")},
+	})
+	ctx := context.Background()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{})
+	logger := log.New()
+	cfg := Config{ConfigMap: "cm"}
+	secretManager, _ := New(ctx, cfg, kubernetes.New(client, logger), fakeBackend, logger)
+
+	data := map[string][]byte{"value1": []byte("fake-data")}
+	err := secretManager.upsertSecret(SecretDefinition{Name: "secret-name", Type: "Opaque"}, "ns", data, nil, nil)
+	assert.True(t, e.IsK8sSecretNotOwned(err))
+
+	err = secretManager.upsertSecret(SecretDefinition{Name: "secret-name", Type: "Opaque", Adopt: true}, "ns", data, nil, nil)
+	assert.Nil(t, err)
+	secret, _ := client.CoreV1().Secrets("ns").Get("secret-name", metav1.GetOptions{})
+	assert.Equal(t, data, secret.Data)
+	assert.Equal(t, "secrets-manager", secret.Labels["managedBy"])
+}
+
+func TestSyncAllCancelled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/db", "password", "s3cr3t"},
+	})
+	logger := log.New()
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm", PrunePolicy: PruneDelete}, kubernetes.New(client, logger), fakeBackend, logger)
+	secretManager.setSecretDefinitions(SecretDefinitions{{
+		Name:       "db",
+		Namespaces: []string{"ns"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	}}, nil)
+
+	// Once the leadership is lost nothing else is synced nor pruned
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	secretManager.syncAll(ctx)
+	_, err := client.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.NotNil(t, err)
+	assert.True(t, secretManager.lastPrune.IsZero())
+
+	secretManager.syncAll(context.Background())
+	secret, err := client.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("s3cr3t"), secret.Data["password"])
+}
+
+func TestSyncStateCancelled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The leadership is lost while writing the first namespace
+	client.PrependReactor("create", "secrets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+	secretManager, stop := newTestSecretManager(t, Config{}, kubernetes.New(client, log.New()), []fakeBackendSecret{
+		{"secret/data/db", "password", "s3cr3t"},
+	})
+	defer stop()
+
+	err := secretManager.syncState(ctx, SecretDefinition{
+		Name:       "db",
+		Namespaces: []string{"ns1", "ns2"},
+		Type:       "Opaque",
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+		},
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	_, err = client.CoreV1().Secrets("ns1").Get("db", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = client.CoreV1().Secrets("ns2").Get("db", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestSyncResetsLeadershipState(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, nil)
+	defer stop()
+	secretManager.syncedVersions["db"] = &syncedVersions{syncedAt: time.Now()}
+	secretManager.pruneCandidates["ns/gone"] = time.Now()
+	secretManager.configMapPruneCandidates["ns/gone"] = time.Now()
+	secretManager.lastPrune = time.Now()
+
+	// A new leadership term does not trust what was synced or found undefined in the previous one
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	secretManager.Sync(ctx)
+
+	assert.Empty(t, secretManager.syncedVersions)
+	assert.Empty(t, secretManager.pruneCandidates)
+	assert.Empty(t, secretManager.configMapPruneCandidates)
+	assert.True(t, secretManager.lastPrune.IsZero())
+}
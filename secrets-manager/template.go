@@ -0,0 +1,61 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/tuenti/secrets-manager/errors"
+)
+
+// templateFuncs are the helpers available to data entry templates, on top of the text/template builtins
+var templateFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"toJson": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"trim": strings.TrimSpace,
+	"default": func(def string, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate renders the template of the data entry key of the secret, with the value of each of
+// its datasources available by name
+func (s *SecretManager) renderTemplate(secret SecretDefinition, key string, v Datasource) ([]byte, error) {
+	tmpl, err := parseTemplate(key, v.Template)
+	if err != nil {
+		return nil, &errors.InvalidTemplateError{ErrType: errors.InvalidTemplateErrorType, Name: secret.Name, Key: key, Reason: err.Error()}
+	}
+
+	values := make(map[string]string, len(v.Datasources))
+	for name, ds := range v.Datasources {
+		value, err := s.readDatasource(ds)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = string(value)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, values); err != nil {
+		return nil, &errors.TemplateRenderError{ErrType: errors.TemplateRenderErrorType, Name: secret.Name, Key: key, Reason: err.Error()}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+package secretsmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+)
+
+// templateSecrets are the backend secrets the templates are rendered from
+var templateSecrets = []fakeBackendSecret{
+	{"secret/data/db", "host", "db.example.com"},
+	{"secret/data/db", "user", " admin\n"},
+	{"secret/data/db", "password", "s3cr3t"},
+	{"secret/data/db", "empty", ""},
+	{"secret/data/db", "config", "a: 1\nb: 2"},
+}
+
+func TestRenderTemplate(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, templateSecrets)
+	defer stop()
+
+	data, err := secretManager.getDesiredState(SecretDefinition{
+		Name: "db",
+		Data: map[string]Datasource{
+			"jdbc.url": {
+				Template: `jdbc:postgresql://{{ .host }}:{{ .port | default "5432" }}/app?user={{ trim .user }}&password={{ .password }}`,
+				Datasources: map[string]Datasource{
+					"host":     {Path: "secret/data/db", Key: "host"},
+					"port":     {Path: "secret/data/db", Key: "empty"},
+					"user":     {Path: "secret/data/db", Key: "user"},
+					"password": {Path: "secret/data/db", Key: "password"},
+				},
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "jdbc:postgresql://db.example.com:5432/app?user=admin&password=s3cr3t", string(data["jdbc.url"]))
+}
+
+func TestRenderTemplateFuncs(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, templateSecrets)
+	defer stop()
+
+	data, err := secretManager.renderTemplate(SecretDefinition{Name: "db"}, "config.yaml", Datasource{
+		Template: "password: {{ base64 .password }}\nconfig:\n{{ indent 2 .config }}\njson: {{ toJson . }}",
+		Datasources: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+			"config":   {Path: "secret/data/db", Key: "config"},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "password: czNjcjN0\nconfig:\n  a: 1\n  b: 2\njson: {\"config\":\"a: 1\\nb: 2\",\"password\":\"s3cr3t\"}", string(data))
+}
+
+func TestRenderTemplateMissingDatasource(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, templateSecrets)
+	defer stop()
+
+	data, err := secretManager.renderTemplate(SecretDefinition{Name: "db"}, "url", Datasource{
+		Template: "{{ .host }}:{{ .port }}",
+		Datasources: map[string]Datasource{
+			"host": {Path: "secret/data/db", Key: "host"},
+		},
+	})
+
+	assert.Nil(t, data)
+	assert.True(t, e.IsTemplateRender(err))
+}
+
+func TestRenderTemplateBackendError(t *testing.T) {
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, templateSecrets)
+	defer stop()
+
+	data, err := secretManager.renderTemplate(SecretDefinition{Name: "db"}, "url", Datasource{
+		Template: "{{ .host }}",
+		Datasources: map[string]Datasource{
+			"host": {Path: "secret/data/other", Key: "host"},
+		},
+	})
+
+	assert.Nil(t, data)
+	assert.NotNil(t, err)
+}
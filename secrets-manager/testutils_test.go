@@ -1,13 +1,68 @@
 package secretsmanager
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"testing"
 
 	gomock "github.com/golang/mock/gomock"
+	log "github.com/sirupsen/logrus"
 	"github.com/tuenti/secrets-manager/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
+// testChecksumKey is the checksum key of the secret managers of the tests
+var testChecksumKey = []byte("test-checksum-key")
+
+// withManagedKeys returns annotations along with the ManagedKeysAnnotation recorded by a sync writing labels and
+// annotations, as they are read from a secret in sync
+func withManagedKeys(labels map[string]string, annotations map[string]string) map[string]string {
+	keys := kubernetes.ManagedKeys{Labels: []string{kubernetes.ManagedByLabel, lastUpdateLabel}}
+	withKeys := make(map[string]string, len(annotations)+1)
+	for k := range labels {
+		keys.Labels = append(keys.Labels, k)
+	}
+	for k, v := range annotations {
+		keys.Annotations = append(keys.Annotations, k)
+		withKeys[k] = v
+	}
+	sort.Strings(keys.Labels)
+	sort.Strings(keys.Annotations)
+	value, _ := json.Marshal(keys)
+	withKeys[kubernetes.ManagedKeysAnnotation] = string(value)
+	return withKeys
+}
+
+// newTestSecretManager returns a secret manager reading secrets from a fake backend and writing them with k8s, or
+// with an empty fake clientset if k8s is nil, and computing checksums with testChecksumKey. cfg defaults to the
+// "cm" configmap. The returned function cancels the context of the secret manager and waits for its namespace
+// watch to stop, tests defer it
+func newTestSecretManager(t *testing.T, cfg Config, k8s kubernetes.Client, secrets []fakeBackendSecret) (*SecretManager, func()) {
+	logger := log.New()
+	if k8s == nil {
+		k8s = kubernetes.New(fake.NewSimpleClientset(), logger)
+	}
+	if cfg.ConfigMap == "" {
+		cfg.ConfigMap = "cm"
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	secretManager, err := New(ctx, cfg, k8s, newFakeBackend(secrets), logger)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	secretManager.checksumKey = testChecksumKey
+	return secretManager, func() {
+		cancel()
+		if secretManager.namespaceWatchDone != nil {
+			<-secretManager.namespaceWatchDone
+		}
+	}
+}
+
 type secretMatcher struct {
 	secret *kubernetes.Secret
 }
@@ -0,0 +1,111 @@
+package secretsmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tuenti/secrets-manager/errors"
+)
+
+const (
+	tlsSecretType = "kubernetes.io/tls"
+	tlsCertKey    = "tls.crt"
+	tlsKeyKey     = "tls.key"
+)
+
+func invalidTLSSecret(name string, reason string) error {
+	return &errors.InvalidTLSSecretError{ErrType: errors.InvalidTLSSecretErrorType, Name: name, Reason: reason}
+}
+
+// validateTLSSecret checks that the private key of a kubernetes.io/tls secret matches its leaf certificate, that
+// every certificate of the chain is issued by the next one and that none of them has expired
+func validateTLSSecret(name string, data map[string][]byte, now time.Time) error {
+	if len(data[tlsCertKey]) == 0 || len(data[tlsKeyKey]) == 0 {
+		return invalidTLSSecret(name, fmt.Sprintf("%s and %s are required", tlsCertKey, tlsKeyKey))
+	}
+	certs, err := parsePEMCertificates(data[tlsCertKey])
+	if err != nil {
+		return invalidTLSSecret(name, fmt.Sprintf("invalid %s: %v", tlsCertKey, err))
+	}
+	key, err := parsePEMPrivateKey(data[tlsKeyKey])
+	if err != nil {
+		return invalidTLSSecret(name, fmt.Sprintf("invalid %s: %v", tlsKeyKey, err))
+	}
+	if !keyMatchesCertificate(key, certs[0]) {
+		return invalidTLSSecret(name, fmt.Sprintf("%s does not match the certificate", tlsKeyKey))
+	}
+	for i, cert := range certs {
+		if now.After(cert.NotAfter) {
+			return invalidTLSSecret(name, fmt.Sprintf("certificate '%s' expired on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)))
+		}
+		if i+1 == len(certs) {
+			break
+		}
+		if err := cert.CheckSignatureFrom(certs[i+1]); err != nil {
+			return invalidTLSSecret(name, fmt.Sprintf("certificate '%s' is not issued by the next certificate in the chain, '%s': %v", cert.Subject.CommonName, certs[i+1].Subject.CommonName, err))
+		}
+	}
+	return nil
+}
+
+// checkTLSSecret validates the data of kubernetes.io/tls secrets, counting every failure
+func checkTLSSecret(secret SecretDefinition, data map[string][]byte) error {
+	if secret.Type != tlsSecretType {
+		return nil
+	}
+	if err := validateTLSSecret(secret.Name, data, time.Now()); err != nil {
+		for _, namespace := range secret.Namespaces {
+			secretTLSValidationErrorsCount.WithLabelValues(secret.Name, namespace).Inc()
+		}
+		return err
+	}
+	return nil
+}
+
+// tlsNotAfter returns the expiration time of the leaf certificate of a kubernetes.io/tls secret
+func tlsNotAfter(data map[string][]byte) (time.Time, bool) {
+	certs, err := parsePEMCertificates(data[tlsCertKey])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return certs[0].NotAfter, true
+}
+
+// tlsSeries is a series of the secretTLSNotAfter gauge, along with the key of the definition of its secret
+type tlsSeries struct {
+	name      string
+	namespace string
+	owner     string
+}
+
+// setTLSNotAfter exports the expiration time of the certificate of the secret in namespace
+func (s *SecretManager) setTLSNotAfter(secret SecretDefinition, namespace string, notAfter time.Time) {
+	owner := secret.owner
+	if owner == "" {
+		owner = secret.key()
+	}
+	secretTLSNotAfter.WithLabelValues(secret.Name, namespace).Set(float64(notAfter.Unix()))
+	s.tlsNotAfterSeries[namespace+"/"+secret.Name] = tlsSeries{name: secret.Name, namespace: namespace, owner: owner}
+}
+
+// deleteTLSNotAfter stops exporting the expiration time of the certificate of the secret name in namespace
+func (s *SecretManager) deleteTLSNotAfter(namespace string, name string) {
+	secretTLSNotAfter.DeleteLabelValues(name, namespace)
+	delete(s.tlsNotAfterSeries, namespace+"/"+name)
+}
+
+// deleteStaleTLSNotAfter stops exporting the expiration times of the secrets whose definition is not in
+// definitions, the kubernetes.io/tls secret definitions by key, or no longer targets their namespace
+func (s *SecretManager) deleteStaleTLSNotAfter(definitions map[string]SecretDefinition) {
+	for _, series := range s.tlsNotAfterSeries {
+		defined := false
+		for _, namespace := range definitions[series.owner].Namespaces {
+			defined = defined || namespace == series.namespace
+		}
+		if defined {
+			continue
+		}
+		logger.Debugf("secret '%s/%s' is no longer a defined TLS secret, dropping its expiration time", series.namespace, series.name)
+		s.deleteTLSNotAfter(series.namespace, series.name)
+	}
+}
@@ -0,0 +1,162 @@
+package secretsmanager
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+)
+
+func TestValidateTLSSecret(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	leaf := newTestCertificate(t, "leaf", ca)
+
+	err := validateTLSSecret("tls", map[string][]byte{
+		tlsCertKey: []byte(leaf.certPEM + ca.certPEM),
+		tlsKeyKey:  []byte(leaf.keyPEM),
+	}, time.Now())
+	assert.Nil(t, err)
+}
+
+func TestValidateTLSSecretKeyMismatch(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+	other := newTestCertificate(t, "other", nil)
+
+	err := validateTLSSecret("tls", map[string][]byte{
+		tlsCertKey: []byte(leaf.certPEM),
+		tlsKeyKey:  []byte(other.keyPEM),
+	}, time.Now())
+	assert.True(t, e.IsInvalidTLSSecret(err))
+	assert.Contains(t, err.Error(), "does not match the certificate")
+}
+
+func TestValidateTLSSecretChainOrder(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	otherCA := newTestCertificate(t, "other-ca", nil)
+	leaf := newTestCertificate(t, "leaf", ca)
+
+	err := validateTLSSecret("tls", map[string][]byte{
+		tlsCertKey: []byte(leaf.certPEM + otherCA.certPEM),
+		tlsKeyKey:  []byte(leaf.keyPEM),
+	}, time.Now())
+	assert.True(t, e.IsInvalidTLSSecret(err))
+	assert.Contains(t, err.Error(), "is not issued by the next certificate in the chain, 'other-ca'")
+}
+
+func TestValidateTLSSecretExpired(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+
+	err := validateTLSSecret("tls", map[string][]byte{
+		tlsCertKey: []byte(leaf.certPEM),
+		tlsKeyKey:  []byte(leaf.keyPEM),
+	}, time.Now().Add(2*time.Hour))
+	assert.True(t, e.IsInvalidTLSSecret(err))
+	assert.Contains(t, err.Error(), "certificate 'leaf' expired")
+}
+
+func TestValidateTLSSecretMissingKey(t *testing.T) {
+	leaf := newTestCertificate(t, "leaf", nil)
+
+	err := validateTLSSecret("tls", map[string][]byte{
+		tlsCertKey: []byte(leaf.certPEM),
+	}, time.Now())
+	assert.True(t, e.IsInvalidTLSSecret(err))
+}
+
+func TestSyncStateTLSSecret(t *testing.T) {
+	secretTLSNotAfter.Reset()
+	secretTLSValidationErrorsCount.Reset()
+	leaf := newTestCertificate(t, "leaf", nil)
+	other := newTestCertificate(t, "other", nil)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", leaf.certPEM},
+		{"secret/data/tls", "key", leaf.keyPEM},
+		{"secret/data/tls", "other-key", other.keyPEM},
+	})
+	defer stop()
+	definition := SecretDefinition{
+		Name:       "tls",
+		Namespaces: []string{"ns"},
+		Type:       tlsSecretType,
+		Data: map[string]Datasource{
+			tlsCertKey: {Path: "secret/data/tls", Key: "crt"},
+			tlsKeyKey:  {Path: "secret/data/tls", Key: "key"},
+		},
+	}
+
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	notAfter, _ := secretTLSNotAfter.GetMetricWithLabelValues("tls", "ns")
+	assert.Equal(t, float64(leaf.cert.NotAfter.Unix()), testutil.ToFloat64(notAfter))
+
+	// The secret is kept as it was when the new certificate and key do not match
+	definition.Data[tlsKeyKey] = Datasource{Path: "secret/data/tls", Key: "other-key"}
+	err := secretManager.syncState(context.Background(), definition)
+	assert.True(t, e.IsInvalidTLSSecret(err))
+	validationErrors, _ := secretTLSValidationErrorsCount.GetMetricWithLabelValues("tls", "ns")
+	assert.Equal(t, 1.0, testutil.ToFloat64(validationErrors))
+
+	data, err := secretManager.kubernetes.ReadSecret("ns", "tls")
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.keyPEM, string(data[tlsKeyKey]))
+}
+
+// exportedTLSNotAfter returns the '<namespace>/<name>' series exported by the secretTLSNotAfter gauge
+func exportedTLSNotAfter() []string {
+	metrics := make(chan prometheus.Metric, 16)
+	secretTLSNotAfter.Collect(metrics)
+	close(metrics)
+	series := []string{}
+	for metric := range metrics {
+		m := &dto.Metric{}
+		metric.Write(m)
+		labels := make(map[string]string)
+		for _, label := range m.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		series = append(series, labels["namespace"]+"/"+labels["name"])
+	}
+	sort.Strings(series)
+	return series
+}
+
+func TestSyncAllTLSNotAfterStale(t *testing.T) {
+	secretTLSNotAfter.Reset()
+	leaf := newTestCertificate(t, "leaf", nil)
+	secretManager, stop := newTestSecretManager(t, Config{}, nil, []fakeBackendSecret{
+		{"secret/data/tls", "crt", leaf.certPEM},
+		{"secret/data/tls", "key", leaf.keyPEM},
+	})
+	defer stop()
+	tlsDefinition := func(name string, namespaces ...string) SecretDefinition {
+		return SecretDefinition{
+			Name:       name,
+			Namespaces: namespaces,
+			Type:       tlsSecretType,
+			Data: map[string]Datasource{
+				tlsCertKey: {Path: "secret/data/tls", Key: "crt"},
+				tlsKeyKey:  {Path: "secret/data/tls", Key: "key"},
+			},
+		}
+	}
+
+	secretManager.setSecretDefinitions(SecretDefinitions{
+		tlsDefinition("tls", "ns", "other"),
+		tlsDefinition("removed", "ns"),
+		tlsDefinition("opaque", "ns"),
+	}, nil)
+	secretManager.syncAll(context.Background())
+	assert.Equal(t, []string{"ns/opaque", "ns/removed", "ns/tls", "other/tls"}, exportedTLSNotAfter())
+
+	// Expiration times are dropped for removed definitions and namespaces, and secrets that are no longer TLS
+	opaque := tlsDefinition("opaque", "ns")
+	opaque.Type = "Opaque"
+	secretManager.setSecretDefinitions(SecretDefinitions{tlsDefinition("tls", "ns"), opaque}, nil)
+	secretManager.syncAll(context.Background())
+	assert.Equal(t, []string{"ns/tls"}, exportedTLSNotAfter())
+}
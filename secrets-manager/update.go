@@ -0,0 +1,71 @@
+package secretsmanager
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/tuenti/secrets-manager/errors"
+	k8s "github.com/tuenti/secrets-manager/kubernetes"
+)
+
+// Update strategies of existing secrets
+const (
+	// UpdateReplace replaces the whole secret, removing the data keys, labels and annotations not defined
+	UpdateReplace = "replace"
+	// UpdateMerge only writes the data keys, labels and annotations defined, preserving the ones added by others
+	UpdateMerge = "merge"
+)
+
+// validateUpdateStrategy checks that the update strategy of the secret definition is replace or merge
+func (d SecretDefinition) validateUpdateStrategy() error {
+	switch d.UpdateStrategy {
+	case "", UpdateReplace, UpdateMerge:
+		return nil
+	default:
+		return &errors.InvalidSecretDefinitionError{ErrType: errors.InvalidSecretDefinitionErrorType, Name: d.Name, Reason: fmt.Sprintf("invalid updateStrategy %s, expected replace or merge", d.UpdateStrategy)}
+	}
+}
+
+// dataChanged returns true if the data of the current secret differs from the desired one. With the merge
+// strategy, only the keys written by secrets-manager are compared
+func (d SecretDefinition) dataChanged(current *k8s.Secret, desired map[string][]byte) bool {
+	if d.UpdateStrategy != UpdateMerge {
+		return !reflect.DeepEqual(desired, current.Data)
+	}
+	for k, v := range desired {
+		if value, ok := current.Data[k]; !ok || !bytes.Equal(value, v) {
+			return true
+		}
+	}
+	for _, k := range current.ManagedKeys().Data {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if _, ok := current.Data[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dataInSync returns true if the current secret has the desired data and records the keys of the desired labels
+// and annotations as the ones written by secrets-manager. With the merge strategy, the current secret must also
+// record the desired data keys
+func (d SecretDefinition) dataInSync(current *k8s.Secret, desired map[string][]byte, labels map[string]string, annotations map[string]string) bool {
+	if d.dataChanged(current, desired) || !hasManagedKeys(current, labels, annotations) {
+		return false
+	}
+	if d.UpdateStrategy != UpdateMerge {
+		return true
+	}
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	_, recorded := current.Annotations[k8s.ManagedKeysAnnotation]
+	managed := current.ManagedKeys().Data
+	return recorded && len(managed) == len(keys) && (len(keys) == 0 || reflect.DeepEqual(managed, keys))
+}
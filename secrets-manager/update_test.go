@@ -0,0 +1,114 @@
+package secretsmanager
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	e "github.com/tuenti/secrets-manager/errors"
+	"github.com/tuenti/secrets-manager/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestParseUpdateStrategy(t *testing.T) {
+	defs, err := parseSecretDefsFromYaml("- name: db\n  updateStrategy: merge")
+	assert.Nil(t, err)
+	assert.Equal(t, UpdateMerge, defs[0].UpdateStrategy)
+
+	_, err = parseSecretDefsFromYaml("- name: db\n  updateStrategy: patch")
+	assert.True(t, e.IsInvalidSecretDefinition(err))
+}
+
+func TestDataChanged(t *testing.T) {
+	desired := map[string][]byte{"password": []byte("s3cr3t")}
+	current := &kubernetes.Secret{
+		Data:        map[string][]byte{"password": []byte("s3cr3t"), "foreign": []byte("kept")},
+		Annotations: map[string]string{kubernetes.ManagedKeysAnnotation: `{"data":["password"],"labels":["lastUpdate","managedBy","team"]}`},
+	}
+	labels := map[string]string{"team": "a"}
+
+	replace := SecretDefinition{Name: "db"}
+	assert.True(t, replace.dataChanged(current, desired))
+	assert.False(t, replace.dataInSync(current, desired, labels, nil))
+
+	merge := SecretDefinition{Name: "db", UpdateStrategy: UpdateMerge}
+	assert.False(t, merge.dataChanged(current, desired))
+	assert.True(t, merge.dataInSync(current, desired, labels, nil))
+
+	// A label or annotation written before and no longer desired must be removed
+	assert.False(t, merge.dataInSync(current, desired, nil, nil))
+	assert.False(t, merge.dataInSync(current, desired, labels, map[string]string{"reloader": "true"}))
+
+	// A key written before and no longer desired must be removed
+	current.Data["removed"] = []byte("x")
+	current.Annotations[kubernetes.ManagedKeysAnnotation] = `{"data":["password","removed"]}`
+	assert.True(t, merge.dataChanged(current, desired))
+
+	// Keys not recorded as managed must be recorded
+	current = &kubernetes.Secret{Data: map[string][]byte{"password": []byte("s3cr3t")}}
+	assert.False(t, merge.dataChanged(current, desired))
+	assert.False(t, merge.dataInSync(current, desired, labels, nil))
+}
+
+func TestSyncStateMerge(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "db",
+			Namespace:   "ns",
+			Labels:      map[string]string{kubernetes.ManagedByLabel: kubernetes.ManagedByValue, "owner": "team-a"},
+			Annotations: map[string]string{"reloader": "true"},
+		},
+		Data: map[string][]byte{"password": []byte("0ld"), "extra": []byte("added by hand")},
+	})
+	logger := log.New()
+	fakeBackend := newFakeBackend([]fakeBackendSecret{
+		{"secret/data/db", "password", "n3w"},
+		{"secret/data/db", "user", "admin"},
+	})
+	secretManager, _ := New(context.Background(), Config{ConfigMap: "cm"}, kubernetes.New(clientSet, logger), fakeBackend, logger)
+
+	definition := SecretDefinition{
+		Name:           "db",
+		Namespaces:     []string{"ns"},
+		Type:           "Opaque",
+		UpdateStrategy: UpdateMerge,
+		Labels:         map[string]string{"env": "prod"},
+		Data: map[string]Datasource{
+			"password": {Path: "secret/data/db", Key: "password"},
+			"user":     {Path: "secret/data/db", Key: "user"},
+		},
+	}
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+
+	secret, _ := clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, map[string][]byte{"password": []byte("n3w"), "user": []byte("admin"), "extra": []byte("added by hand")}, secret.Data)
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+	assert.Equal(t, "prod", secret.Labels["env"])
+	assert.Equal(t, "true", secret.Annotations["reloader"])
+
+	// Secrets in sync are not updated again
+	clientSet.ClearActions()
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	for _, action := range clientSet.Actions() {
+		_, updated := action.(clientgotesting.UpdateAction)
+		assert.False(t, updated && action.GetResource().Resource == "secrets")
+	}
+
+	// Keys removed from the definition are removed from the secret
+	delete(definition.Data, "user")
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	secret, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	assert.Equal(t, map[string][]byte{"password": []byte("n3w"), "extra": []byte("added by hand")}, secret.Data)
+
+	// Labels removed from the definition are removed from the secret, the ones added by hand are kept
+	definition.Labels = nil
+	assert.Nil(t, secretManager.syncState(context.Background(), definition))
+	secret, _ = clientSet.CoreV1().Secrets("ns").Get("db", metav1.GetOptions{})
+	_, found := secret.Labels["env"]
+	assert.False(t, found)
+	assert.Equal(t, "team-a", secret.Labels["owner"])
+}